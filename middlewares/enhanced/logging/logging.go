@@ -0,0 +1,47 @@
+// Package logging sets up log/slog for the services and defines the fields
+// of the request log line, so Loki can query every service the same way.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Handler formats.
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// New returns a logger writing to w in format, dropping records below
+// level.
+func New(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, use %q or %q", format, FormatJSON, FormatText)
+}
+
+// FromEnv returns a logger writing to stderr as configured by LOG_FORMAT
+// (json or text, default json) and LOG_LEVEL (debug, info, warn or error,
+// default info).
+func FromEnv() (*slog.Logger, error) {
+	var level slog.Level
+	if s, ok := os.LookupEnv("LOG_LEVEL"); ok {
+		if err := level.UnmarshalText([]byte(s)); err != nil {
+			return nil, fmt.Errorf("LOG_LEVEL: %w", err)
+		}
+	}
+	format := FormatJSON
+	if s, ok := os.LookupEnv("LOG_FORMAT"); ok {
+		format = strings.ToLower(s)
+	}
+	return New(os.Stderr, format, level)
+}
@@ -0,0 +1,93 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Request log line fields.
+const (
+	KeyMethod   = "method"
+	KeyRoute    = "route"
+	KeyStatus   = "status"
+	KeyDuration = "duration"
+	KeyBytes    = "bytes"
+	KeyTraceID  = "trace_id"
+	KeyUserID   = "user_id"
+)
+
+// Request describes a served request. Route is the matched pattern, not the
+// path, and the IDs are left out when empty.
+type Request struct {
+	Method   string
+	Route    string
+	Status   int
+	Duration time.Duration
+	Bytes    int64
+	TraceID  string
+	UserID   string
+}
+
+// LogRequest logs req at info level, or error level for 5xx responses.
+func LogRequest(ctx context.Context, logger *slog.Logger, req Request) {
+	level := slog.LevelInfo
+	if req.Status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+
+	attrs := []slog.Attr{
+		slog.String(KeyMethod, req.Method),
+		slog.String(KeyRoute, req.Route),
+		slog.Int(KeyStatus, req.Status),
+		slog.Duration(KeyDuration, req.Duration),
+		slog.Int64(KeyBytes, req.Bytes),
+	}
+	if req.TraceID != "" {
+		attrs = append(attrs, slog.String(KeyTraceID, req.TraceID))
+	}
+	if req.UserID != "" {
+		attrs = append(attrs, slog.String(KeyUserID, req.UserID))
+	}
+	logger.LogAttrs(ctx, level, "request", attrs...)
+}
+
+type contextKey struct {
+	name string
+}
+
+func (k *contextKey) String() string {
+	return "logging context key " + k.name
+}
+
+// userKey holds an *atomic.Value: the user is authenticated by handlers
+// the request logger wraps, which only see copies of its request, and may
+// still be running after a timeout was logged.
+var userKey = &contextKey{"user"}
+
+// WithUser returns ctx with room for SetUserID to record the user in.
+func WithUser(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(userKey).(*atomic.Value); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, userKey, new(atomic.Value))
+}
+
+// SetUserID records the authenticated user of the request for its log
+// line. It does nothing outside a context made by WithUser.
+func SetUserID(ctx context.Context, id string) {
+	if user, ok := ctx.Value(userKey).(*atomic.Value); ok {
+		user.Store(id)
+	}
+}
+
+// UserID returns the user recorded by SetUserID.
+func UserID(ctx context.Context) string {
+	if user, ok := ctx.Value(userKey).(*atomic.Value); ok {
+		id, _ := user.Load().(string)
+		return id
+	}
+	return ""
+}
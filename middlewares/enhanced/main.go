@@ -4,14 +4,21 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/ZiadMansourM/middleware/logging"
 	"github.com/ZiadMansourM/middleware/middleware"
+	"github.com/ZiadMansourM/middleware/telemetry"
 	"github.com/ZiadMansourM/middleware/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func UsersMeHandler(w http.ResponseWriter, r *http.Request) {
@@ -35,6 +42,28 @@ func main() {
 		cancel()
 	}()
 
+	logger, err := logging.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid logging config: %v\n", err)
+	}
+	// Also turns the log package's output into structured records.
+	slog.SetDefault(logger)
+
+	tracing, err := telemetry.ConfigFromEnv("enhanced")
+	if err != nil {
+		log.Fatalf("Invalid tracing config: %v\n", err)
+	}
+	tp, err := telemetry.NewTracerProvider(context.Background(), tracing)
+	if err != nil {
+		log.Fatalf("Could not set up tracing: %v\n", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
 	router := http.NewServeMux()
 
 	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -44,19 +73,54 @@ func main() {
 	router.Handle("/users/me", middleware.NewEnsureAuth(http.HandlerFunc(UsersMeHandler)))
 
 	v1 := http.NewServeMux()
-	v1.Handle("/api/v1/", http.StripPrefix("/api/v1", router))
+	v1.Handle("/api/v1/", http.StripPrefix("/api/v1", middleware.Routes("/api/v1", router)))
+	v1.Handle("GET /metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
 
-	const addr = "127.0.0.1:8080"
+	// Listen on 0.0.0.0:8080 to be scraped by the Prometheus container.
+	addr := os.Getenv("ADDR")
+	if addr == "" {
+		addr = "127.0.0.1:8080"
+	}
+
+	// Share the limits between replicas through Redis when REDIS_ADDR is set.
+	var store middleware.RateLimitStore = middleware.NewMemoryRateLimitStore()
+	if redisAddr := os.Getenv("REDIS_ADDR"); redisAddr != "" {
+		redis := middleware.NewRedisRateLimitStore(redisAddr, os.Getenv("REDIS_PASSWORD"))
+		defer redis.Close()
+		store = redis
+	}
+	limiter := middleware.NewRateLimiter(store, middleware.KeyByIP, middleware.Rate{Requests: 100, Window: time.Minute, Burst: 20}).
+		Route("/api/v1/health", middleware.Rate{}).
+		Route("GET /metrics", middleware.Rate{})
+
+	limits := middleware.NewRouteLimits(middleware.Limits{MaxBodyBytes: 1 << 20, Timeout: 10 * time.Second})
 
 	middlewares := []middleware.Middleware{
+		middleware.Recover(registry),
+		middleware.Limit(limits),
+		middleware.RateLimit(limiter),
+		// Outside the limiter, so preflights aren't counted and 429s can
+		// be read by the browser app.
+		middleware.CORS(middleware.CORSOptions{
+			AllowedOrigins: strings.Fields(os.Getenv("CORS_ALLOWED_ORIGINS")),
+			AllowedHeaders: []string{"Authorization"},
+			ExposedHeaders: []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+			MaxAge:         10 * time.Minute,
+		}),
+		middleware.Compress(middleware.CompressOptions{}),
 		middleware.Timer,
 		middleware.Logger,
-		middleware.Tracer,
+		middleware.Metrics(registry),
+		middleware.Tracing(tp),
 	}
 
 	server := http.Server{
-		Addr:    addr,
-		Handler: middleware.ChainMiddlewares(v1, middlewares...),
+		Addr:              addr,
+		Handler:           middleware.ChainMiddlewares(v1, middlewares...),
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
 	}
 
 	go func() {
@@ -73,5 +137,8 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Could not shutdown server: %v\n", err)
 	}
+	if err := tp.Shutdown(ctx); err != nil {
+		log.Printf("Could not flush spans: %v\n", err)
+	}
 	log.Println("Server Exited Properly")
 }
@@ -0,0 +1,366 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressOptions configures Compress.
+type CompressOptions struct {
+	// MinSize in bytes of the responses worth compressing, 1024 when zero.
+	// Smaller ones are sent as is.
+	MinSize int
+	// SkipContentTypes aren't compressed, like "application/zip" or
+	// "image/*". Nil means media and archive types, which are compressed
+	// already; image/svg+xml is compressed anyway.
+	SkipContentTypes []string
+}
+
+var defaultSkipContentTypes = []string{
+	"image/*", "audio/*", "video/*", "font/woff", "font/woff2",
+	"application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+	"application/x-bzip2", "application/x-xz", "application/x-7z-compressed",
+	"application/vnd.rar", "application/pdf", "application/octet-stream",
+}
+
+// encoder is implemented by the gzip, brotli and zstd writers.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// encodings in order of preference when the client accepts several
+// equally: zstd is the fastest, brotli compresses text the best.
+var encodings = []struct {
+	name string
+	pool *sync.Pool
+}{
+	{"zstd", &sync.Pool{New: func() any {
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return zstdEncoder{enc}
+	}}},
+	{"br", &sync.Pool{New: func() any { return brotli.NewWriterLevel(nil, 5) }}},
+	{"gzip", &sync.Pool{New: func() any { return gzip.NewWriter(nil) }}},
+}
+
+// zstdEncoder drops the error of Reset, which only fails for a nil
+// dictionary.
+type zstdEncoder struct{ *zstd.Encoder }
+
+func (e zstdEncoder) Reset(w io.Writer) { e.Encoder.Reset(w) }
+
+// Compress compresses responses with zstd, brotli or gzip, whichever the
+// client prefers in Accept-Encoding. Responses under MinSize, of skipped
+// content types, already encoded, partial, or marked Cache-Control:
+// no-transform are sent as is.
+//
+// Bodies are buffered until MinSize is reached, or until the handler
+// flushes: then the encoder is flushed too, so streams like SSE keep
+// delivering events as they're written. Like WrapResponseWriter, the
+// ResponseWriter implements http.Flusher and http.Hijacker exactly when
+// the wrapped one does.
+func Compress(opts CompressOptions) Middleware {
+	if opts.MinSize <= 0 {
+		opts.MinSize = 1024
+	}
+	if opts.SkipContentTypes == nil {
+		opts.SkipContentTypes = defaultSkipContentTypes
+	}
+
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			// Whatever the outcome, it depends on Accept-Encoding.
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			pool, name := negotiateEncoding(r.Header.Values("Accept-Encoding"))
+			if pool == nil || r.Method == http.MethodHead {
+				handler.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{w: w, opts: &opts, pool: pool, encoding: name}
+			handler.ServeHTTP(cw.wrap(), r)
+			cw.close()
+		}
+	}
+}
+
+// negotiateEncoding picks the encoding with the highest q-value in
+// Accept-Encoding headers, nil if none is acceptable.
+func negotiateEncoding(headers []string) (*sync.Pool, string) {
+	weights := make(map[string]float64)
+	for _, header := range headers {
+		for _, part := range strings.Split(header, ",") {
+			name, params, _ := strings.Cut(part, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			weights[name] = qValue(params)
+		}
+	}
+
+	var best *sync.Pool
+	name, bestQ := "", 0.0
+	for _, enc := range encodings {
+		q, ok := weights[enc.name]
+		if !ok {
+			q = weights["*"]
+		}
+		if q > bestQ {
+			best, name, bestQ = enc.pool, enc.name, q
+		}
+	}
+	return best, name
+}
+
+// qValue returns the q parameter of an Accept-Encoding entry, 1 when it's
+// missing or unparsable: a malformed weight doesn't make a client refuse an
+// encoding it named.
+func qValue(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		key, value, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(strings.TrimSpace(key), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 1
+		}
+		return q
+	}
+	return 1
+}
+
+type compressWriter struct {
+	w        http.ResponseWriter
+	opts     *CompressOptions
+	pool     *sync.Pool
+	encoding string
+
+	status int
+	buf    []byte
+	// decided is set once the response is known to be compressed, with
+	// enc, or sent as is.
+	decided bool
+	enc     encoder
+}
+
+// wrap returns cw with the optional interfaces of the wrapped writer.
+func (cw *compressWriter) wrap() http.ResponseWriter {
+	f, isFlusher := cw.w.(http.Flusher)
+	h, isHijacker := cw.w.(http.Hijacker)
+	fl, hj := compressFlusher{cw, f}, compressHijacker{cw, h}
+
+	switch {
+	case isFlusher && isHijacker:
+		return struct {
+			*compressWriter
+			compressFlusher
+			compressHijacker
+		}{cw, fl, hj}
+	case isFlusher:
+		return struct {
+			*compressWriter
+			compressFlusher
+		}{cw, fl}
+	case isHijacker:
+		return struct {
+			*compressWriter
+			compressHijacker
+		}{cw, hj}
+	}
+	return cw
+}
+
+func (cw *compressWriter) Header() http.Header {
+	return cw.w.Header()
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	// Informational responses go out right away, the final one follows.
+	if cw.decided || status < 200 && status != http.StatusSwitchingProtocols {
+		cw.w.WriteHeader(status)
+		return
+	}
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+
+	switch {
+	case !cw.compressible():
+		cw.decide(false)
+	case cw.contentLength() >= int64(cw.opts.MinSize):
+		cw.decide(true)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.WriteHeader(http.StatusOK)
+		}
+		if !cw.decided {
+			cw.buf = append(cw.buf, b...)
+			if len(cw.buf) >= cw.opts.MinSize {
+				if err := cw.decide(cw.compressible()); err != nil {
+					return 0, err
+				}
+			}
+			return len(b), nil
+		}
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(b)
+	}
+	return cw.w.Write(b)
+}
+
+// Unwrap returns the wrapped ResponseWriter, for http.ResponseController.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.w
+}
+
+// compressible reports whether the response may be compressed, from its
+// status and headers.
+func (cw *compressWriter) compressible() bool {
+	switch cw.status {
+	case http.StatusSwitchingProtocols, http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return false
+	}
+	h := cw.w.Header()
+	if h.Get("Content-Encoding") != "" || strings.Contains(h.Get("Cache-Control"), "no-transform") {
+		return false
+	}
+
+	contentType := h.Get("Content-Type")
+	if contentType == "" {
+		// net/http would sniff it from the first bytes, which must happen
+		// before they're compressed, see decide.
+		if len(cw.buf) == 0 {
+			return true
+		}
+		contentType = http.DetectContentType(cw.buf)
+		h.Set("Content-Type", contentType)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, skip := range cw.opts.SkipContentTypes {
+		if prefix, ok := strings.CutSuffix(skip, "*"); ok {
+			if strings.HasPrefix(mediaType, prefix) && mediaType != "image/svg+xml" {
+				return false
+			}
+		} else if mediaType == skip {
+			return false
+		}
+	}
+	return true
+}
+
+// contentLength returns the Content-Length the handler set, -1 if none.
+func (cw *compressWriter) contentLength() int64 {
+	n, err := strconv.ParseInt(cw.w.Header().Get("Content-Length"), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// decide sends the header, set up to compress or not, and the buffered
+// body.
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	// Without a body to sniff the content type from, net/http would sniff
+	// the compressed one.
+	if cw.w.Header().Get("Content-Type") == "" && len(cw.buf) == 0 {
+		compress = false
+	}
+	if compress {
+		h := cw.w.Header()
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		h.Set("Content-Encoding", cw.encoding)
+		// The bytes differ from the uncompressed representation's.
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.enc = cw.pool.Get().(encoder)
+		cw.enc.Reset(cw.w)
+	}
+	if cw.status != 0 {
+		cw.w.WriteHeader(cw.status)
+	}
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	buf := cw.buf
+	cw.buf = nil
+	if cw.enc != nil {
+		_, err := cw.enc.Write(buf)
+		return err
+	}
+	_, err := cw.w.Write(buf)
+	return err
+}
+
+// close sends what's left once the handler returned.
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		// The body turned out smaller than MinSize.
+		cw.decide(false)
+	}
+	if cw.enc != nil {
+		cw.enc.Close()
+		cw.enc.Reset(nil)
+		cw.pool.Put(cw.enc)
+		cw.enc = nil
+	}
+}
+
+type compressFlusher struct {
+	cw *compressWriter
+	f  http.Flusher
+}
+
+// Flush decides on compression with what was written so far, as the rest
+// of a stream can't be waited for.
+func (f compressFlusher) Flush() {
+	cw := f.cw
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.WriteHeader(http.StatusOK)
+		}
+		if !cw.decided {
+			cw.decide(cw.compressible())
+		}
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	f.f.Flush()
+}
+
+type compressHijacker struct {
+	cw *compressWriter
+	h  http.Hijacker
+}
+
+func (h compressHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := h.h.Hijack()
+	if err == nil {
+		// Nothing is sent through the ResponseWriter anymore.
+		h.cw.decided = true
+	}
+	return conn, rw, err
+}
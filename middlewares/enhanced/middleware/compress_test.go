@@ -0,0 +1,180 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ZiadMansourM/middleware/utils"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                          "",
+		"identity":                  "",
+		"gzip":                      "gzip",
+		"gzip, deflate, br, zstd":   "zstd",
+		"GZIP;q=0.5, br;q=0.8":      "br",
+		"br;q=0, gzip":              "gzip",
+		"*":                         "zstd",
+		"*;q=0.1, zstd;q=0, br;q=0": "gzip",
+		"gzip;q=0":                  "",
+		"deflate, gzip;q=nonsense":  "gzip",
+		"br; q = 0.5, gzip ;Q=0.8":  "gzip",
+		"gzip;level=1;q=0":          "",
+	}
+	for header, expected := range tests {
+		if _, got := negotiateEncoding([]string{header}); got != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, header, got)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	tasks := make([]map[string]any, 100)
+	for i := range tasks {
+		tasks[i] = map[string]any{"id": i, "name": "Write the docs", "status": "TODO"}
+	}
+	router := http.NewServeMux()
+	router.HandleFunc("GET /tasks", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		utils.WriteJson(w, http.StatusOK, tasks)
+	})
+	router.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		utils.WriteJson(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	router.HandleFunc("GET /avatar", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(make([]byte, 4096))
+	})
+	router.HandleFunc("GET /page", func(w http.ResponseWriter, r *http.Request) {
+		// Sniffed by net/http, from the uncompressed bytes.
+		w.Write([]byte("<!DOCTYPE html><html>" + strings.Repeat("<p>hello</p>", 200)))
+	})
+	handler := ChainMiddlewares(router, Compress(CompressOptions{}))
+
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	decoders := map[string]func(io.Reader) io.Reader{
+		"gzip": func(r io.Reader) io.Reader {
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return zr
+		},
+		"br": func(r io.Reader) io.Reader { return brotli.NewReader(r) },
+		"zstd": func(r io.Reader) io.Reader {
+			zr, err := zstd.NewReader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return zr
+		},
+	}
+	for encoding, decode := range decoders {
+		t.Run("Compresses with "+encoding, func(t *testing.T) {
+			uncompressed := get("/tasks", "identity").Body.String()
+			rec := get("/tasks", encoding)
+			if rec.Header().Get("Content-Encoding") != encoding || rec.Body.Len() >= len(uncompressed) {
+				t.Fatalf("Expected a smaller %s body, got %v %d bytes", encoding, rec.Header(), rec.Body.Len())
+			}
+			body, err := io.ReadAll(decode(rec.Body))
+			if err != nil || string(body) != uncompressed {
+				t.Errorf("Expected the body to decompress to the original, got %v", err)
+			}
+			if rec.Header().Get("Vary") != "Accept-Encoding" || rec.Header().Get("ETag") != `W/"v1"` {
+				t.Errorf("Expected Vary and a weak ETag, got %v", rec.Header())
+			}
+		})
+	}
+
+	t.Run("Skips small responses", func(t *testing.T) {
+		rec := get("/health", "gzip")
+		if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != "{\"status\":\"ok\"}\n" {
+			t.Errorf("Expected the response as is, got %v %q", rec.Header(), rec.Body.String())
+		}
+		if rec.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("Expected Vary even when not compressed, got %v", rec.Header())
+		}
+	})
+
+	t.Run("Skips compressed content types", func(t *testing.T) {
+		if rec := get("/avatar", "gzip"); rec.Header().Get("Content-Encoding") != "" || rec.Body.Len() != 4096 {
+			t.Errorf("Expected the image as is, got %v", rec.Header())
+		}
+	})
+
+	t.Run("Sniffs the content type first", func(t *testing.T) {
+		rec := get("/page", "gzip")
+		if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+			t.Errorf("Expected compressed HTML, got %v", rec.Header())
+		}
+	})
+}
+
+func TestCompressStreams(t *testing.T) {
+	events := make(chan string)
+	handler := ChainMiddlewares(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for event := range events {
+			io.WriteString(w, "data: "+event+"\n\n")
+			w.(http.Flusher).Flush()
+		}
+	}), Compress(CompressOptions{}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected a gzip stream, got %v", resp.Header)
+	}
+
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := make(chan string)
+	go func() {
+		lines := bufio.NewScanner(zr)
+		for lines.Scan() {
+			if lines.Text() != "" {
+				read <- lines.Text()
+			}
+		}
+	}()
+	for _, event := range []string{"created", "moved"} {
+		events <- event
+		// Each event must arrive before the next one is sent, small as it is.
+		select {
+		case got := <-read:
+			if got != "data: "+event {
+				t.Errorf("Expected %q, got %q", "data: "+event, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %q to be flushed", event)
+		}
+	}
+	close(events)
+}
@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ZiadMansourM/middleware/utils"
+)
+
+// CORSOptions configures which cross-origin requests browsers may make.
+type CORSOptions struct {
+	// AllowedOrigins are origins such as "https://app.example.com", or
+	// patterns with one "*" such as "https://*.example.com". "*" alone
+	// allows every origin.
+	AllowedOrigins []string
+	// AllowedMethods of preflighted requests, GET, HEAD and POST when empty.
+	AllowedMethods []string
+	// AllowedHeaders clients may send, compared case-insensitively.
+	AllowedHeaders []string
+	// ExposedHeaders scripts may read besides the safelisted ones.
+	ExposedHeaders []string
+	// AllowCredentials lets browsers send cookies and read the response.
+	// It can't be combined with the "*" origin.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight, they cap it
+	// themselves. Zero leaves it to the browser's default of 5 seconds.
+	MaxAge time.Duration
+}
+
+// CORS answers preflight requests itself, so put it before authentication
+// in the chain: browsers never send credentials with a preflight. Other
+// requests from allowed origins get the CORS headers and are served as
+// usual; requests from other origins are served without them, and the
+// browser keeps the response from the script.
+func CORS(opts CORSOptions) Middleware {
+	if opts.AllowCredentials && slices.Contains(opts.AllowedOrigins, "*") {
+		panic("middleware: CORS can't allow credentials from every origin")
+	}
+	if len(opts.AllowedMethods) == 0 {
+		opts.AllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+	allowedHeaders := make(map[string]bool, len(opts.AllowedHeaders))
+	for _, h := range opts.AllowedHeaders {
+		allowedHeaders[http.CanonicalHeaderKey(h)] = true
+	}
+	methods := strings.Join(opts.AllowedMethods, ", ")
+	exposed := strings.Join(opts.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(opts.MaxAge.Seconds()))
+
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			h := w.Header()
+			// Responses differ per origin, caches must not mix them up.
+			h.Add("Vary", "Origin")
+			if origin == "" {
+				handler.ServeHTTP(w, r)
+				return
+			}
+
+			allowed := opts.allowsOrigin(origin)
+			if !preflight {
+				if allowed {
+					opts.setOrigin(h, origin)
+					if exposed != "" {
+						h.Set("Access-Control-Expose-Headers", exposed)
+					}
+				}
+				handler.ServeHTTP(w, r)
+				return
+			}
+
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			method := r.Header.Get("Access-Control-Request-Method")
+			requested := requestedHeaders(r)
+			if !allowed || !slices.Contains(opts.AllowedMethods, method) || !allHeadersAllowed(requested, allowedHeaders) {
+				utils.WriteProblem(w, http.StatusForbidden, "cross-origin request not allowed")
+				return
+			}
+
+			opts.setOrigin(h, origin)
+			h.Set("Access-Control-Allow-Methods", methods)
+			if len(requested) > 0 {
+				h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+			}
+			if opts.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+func (opts CORSOptions) allowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range opts.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		prefix, suffix, ok := strings.Cut(allowed, "*")
+		if ok && len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (opts CORSOptions) setOrigin(h http.Header, origin string) {
+	if opts.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Credentials", "true")
+		return
+	}
+	if slices.Contains(opts.AllowedOrigins, "*") {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+}
+
+// requestedHeaders returns the canonical names of the headers a preflight
+// asks for.
+func requestedHeaders(r *http.Request) []string {
+	var headers []string
+	for _, v := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, h := range strings.Split(v, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				headers = append(headers, http.CanonicalHeaderKey(h))
+			}
+		}
+	}
+	return headers
+}
+
+func allHeadersAllowed(requested []string, allowed map[string]bool) bool {
+	for _, h := range requested {
+		if !allowed[h] {
+			return false
+		}
+	}
+	return true
+}
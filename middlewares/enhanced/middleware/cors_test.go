@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	served := 0
+	// Stands in for authentication, preflights must never reach it.
+	auth := func(w http.ResponseWriter, r *http.Request) {
+		served++
+		if r.Header.Get("Authorization") == "" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+	handler := ChainMiddlewares(http.HandlerFunc(auth), CORS(CORSOptions{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.preview.example.com"},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodPatch},
+		AllowedHeaders:   []string{"authorization", "Content-Type"},
+		ExposedHeaders:   []string{"Retry-After", "RateLimit-Remaining"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}))
+
+	preflight := func(origin, method, headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/api/v1/tasks/1", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		if headers != "" {
+			req.Header.Set("Access-Control-Request-Headers", headers)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Answers preflights before authentication", func(t *testing.T) {
+		served = 0
+		rec := preflight("https://app.example.com", http.MethodPatch, "Authorization, content-type")
+		expected := map[string]string{
+			"Access-Control-Allow-Origin":      "https://app.example.com",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Allow-Methods":     "GET, POST, PATCH",
+			"Access-Control-Allow-Headers":     "Authorization, Content-Type",
+			"Access-Control-Max-Age":           "600",
+		}
+		if rec.Code != http.StatusNoContent || served != 0 {
+			t.Errorf("Expected a 204 without reaching the handler, got %d, served %d", rec.Code, served)
+		}
+		for key, value := range expected {
+			if got := rec.Header().Get(key); got != value {
+				t.Errorf("Expected %s to be %q, got %q", key, value, got)
+			}
+		}
+	})
+
+	t.Run("Matches origin patterns", func(t *testing.T) {
+		if rec := preflight("https://pr-42.preview.example.com", http.MethodGet, ""); rec.Code != http.StatusNoContent {
+			t.Errorf("Expected the pattern to match, got %d", rec.Code)
+		}
+		for _, origin := range []string{"https://.preview.example.com", "https://evil.com", "http://app.example.com"} {
+			if rec := preflight(origin, http.MethodGet, ""); rec.Code != http.StatusForbidden || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+				t.Errorf("Expected %s to be rejected, got %d %v", origin, rec.Code, rec.Header())
+			}
+		}
+	})
+
+	t.Run("Rejects methods and headers not allowed", func(t *testing.T) {
+		if rec := preflight("https://app.example.com", http.MethodDelete, ""); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected DELETE to be rejected, got %d", rec.Code)
+		}
+		if rec := preflight("https://app.example.com", http.MethodGet, "X-Debug"); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected X-Debug to be rejected, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Adds headers to requests", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/tasks/1", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected the handler to answer, got %d", rec.Code)
+		}
+		if rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" || rec.Header().Get("Access-Control-Expose-Headers") != "Retry-After, RateLimit-Remaining" {
+			t.Errorf("Expected CORS headers on the 401, got %v", rec.Header())
+		}
+		if rec.Header().Get("Vary") != "Origin" {
+			t.Errorf("Expected Vary: Origin, got %v", rec.Header().Values("Vary"))
+		}
+
+		req.Header.Set("Origin", "https://evil.com")
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("Expected no CORS headers for other origins, got %v", rec.Header())
+		}
+	})
+
+	t.Run("Allows every origin without credentials", func(t *testing.T) {
+		everyone := ChainMiddlewares(http.HandlerFunc(auth), CORS(CORSOptions{AllowedOrigins: []string{"*"}}))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Origin", "https://anywhere.example")
+		rec := httptest.NewRecorder()
+		everyone.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+			t.Errorf("Expected *, got %q", got)
+		}
+
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected credentials with every origin to panic")
+			}
+		}()
+		CORS(CORSOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+	})
+}
@@ -3,6 +3,9 @@ package middleware
 import (
 	"context"
 	"net/http"
+	"strconv"
+
+	"github.com/ZiadMansourM/middleware/logging"
 )
 
 type EnsureAuth struct {
@@ -36,6 +39,8 @@ func (ea *EnsureAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "please sign-in", http.StatusUnauthorized)
 		return
 	}
+	SetSpanUser(r, strconv.Itoa(user.ID))
+	logging.SetUserID(r.Context(), strconv.Itoa(user.ID))
 
 	// Create a new request context containing the authenticated user
 	ctxWithUser := context.WithValue(r.Context(), authenticatedUserKey, user)
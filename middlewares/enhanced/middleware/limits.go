@@ -0,0 +1,370 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ZiadMansourM/middleware/utils"
+)
+
+// Limits bound what a request may cost. Zero values don't limit.
+type Limits struct {
+	// MaxBodyBytes is the largest request body accepted.
+	MaxBodyBytes int64
+	// Timeout is how long the handler may take to answer. Its context is
+	// canceled then, so database queries and calls made with it give up.
+	Timeout time.Duration
+}
+
+// RouteLimits applies default limits and limits per route pattern.
+type RouteLimits struct {
+	limits Limits
+	// routes matches requests against the patterns given to Route, like
+	// RateLimiter's.
+	routes   *http.ServeMux
+	patterns map[string]Limits
+}
+
+// NewRouteLimits applies limits to every request.
+func NewRouteLimits(limits Limits) *RouteLimits {
+	return &RouteLimits{
+		limits:   limits,
+		routes:   http.NewServeMux(),
+		patterns: make(map[string]Limits),
+	}
+}
+
+// Route gives requests matching pattern their own limits instead of the
+// default ones. Patterns are ServeMux patterns of the full path, e.g.
+// "POST /api/v1/tasks"; a zero Limits lifts them, for streams and uploads.
+func (l *RouteLimits) Route(pattern string, limits Limits) *RouteLimits {
+	l.routes.Handle(pattern, http.NotFoundHandler())
+	l.patterns[pattern] = limits
+	return l
+}
+
+// For returns the limits of r.
+func (l *RouteLimits) For(r *http.Request) Limits {
+	if _, pattern := l.routes.Handler(r); pattern != "" {
+		return l.patterns[pattern]
+	}
+	return l.limits
+}
+
+// Limit enforces the limits of each request.
+//
+// Bodies declaring a larger Content-Length are refused with 413 Content
+// Too Large before the handler runs. Others fail to read past the limit
+// with an *http.MaxBytesError, and the handler's answer is replaced by a
+// 413 too.
+//
+// Handlers still running at their deadline are answered 503 Service
+// Unavailable, and their later writes fail with http.ErrHandlerTimeout;
+// handlers returning without an answer after their context expired, say
+// on a database timeout, get 504 Gateway Timeout. A response already
+// under way when the deadline passes is aborted. Within a timeout the
+// ResponseWriter implements http.Flusher if the wrapped one does, but not
+// http.Hijacker: lift the timeout of WebSocket routes.
+//
+// A handler's panic is panicked again on the server's goroutine as a
+// *PanicError with the handler's stack, for Recover. Panics after the
+// timeout was answered are logged instead.
+func Limit(l *RouteLimits) Middleware {
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			limits := l.For(r)
+			var body *limitedBody
+			if limits.MaxBodyBytes > 0 && r.Body != nil && r.Body != http.NoBody {
+				if r.ContentLength > limits.MaxBodyBytes {
+					writeTooLarge(w, limits.MaxBodyBytes)
+					return
+				}
+				body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, limits.MaxBodyBytes), limit: limits.MaxBodyBytes}
+				r.Body = body
+			}
+
+			if limits.Timeout <= 0 {
+				if body == nil {
+					handler.ServeHTTP(w, r)
+					return
+				}
+				lw := &limitWriter{w: w, body: body}
+				handler.ServeHTTP(lw.wrap(true), r)
+				lw.finish(nil)
+				return
+			}
+			serveWithTimeout(handler, w, r, body, limits.Timeout)
+		}
+	}
+}
+
+func serveWithTimeout(handler http.Handler, w http.ResponseWriter, r *http.Request, body *limitedBody, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	// The handler gets its own header map, it may still be changing it
+	// when the 503 is sent.
+	lw := &limitWriter{w: w, body: body, header: w.Header().Clone()}
+	done := make(chan struct{})
+	panicked := make(chan any, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				lw.panicked(r, p, panicked)
+			}
+		}()
+		handler.ServeHTTP(lw.wrap(false), r)
+		close(done)
+	}()
+
+	select {
+	case p := <-panicked:
+		lw.stop()
+		// Re-panic on the server's goroutine, for Recover and net/http.
+		panic(p)
+	case <-done:
+		lw.finish(ctx.Err())
+	case <-ctx.Done():
+		answered := lw.stop()
+		// A panic passed on before stop still gets to Recover, later ones
+		// are logged by the handler's goroutine.
+		select {
+		case p := <-panicked:
+			panic(p)
+		default:
+		}
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			// The client went away, there's no one to answer.
+			return
+		}
+		if answered {
+			// Cut the response under way, it can't end well anymore.
+			panic(http.ErrAbortHandler)
+		}
+		writeTimeout(w, http.StatusServiceUnavailable)
+	}
+}
+
+func writeTooLarge(w http.ResponseWriter, limit int64) {
+	// The rest of the body isn't read, the connection can't be reused.
+	w.Header().Set("Connection", "close")
+	utils.WriteProblem(w, http.StatusRequestEntityTooLarge, "request body is larger than "+strconv.FormatInt(limit, 10)+" bytes")
+}
+
+func writeTimeout(w http.ResponseWriter, status int) {
+	utils.WriteProblem(w, status, "request took too long")
+}
+
+// limitedBody records whether the handler tried to read past the limit.
+type limitedBody struct {
+	io.ReadCloser
+	limit int64
+	// exceeded is read by the middleware while a timed out handler may
+	// still be reading.
+	exceeded atomic.Bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		b.exceeded.Store(true)
+	}
+	return n, err
+}
+
+// limitWriter replaces the handler's answer by a 413 when the body was too
+// large and, within a timeout, keeps it from writing once the timeout was
+// answered.
+type limitWriter struct {
+	w    http.ResponseWriter
+	body *limitedBody
+	// header is the handler's own header map within a timeout, nil means
+	// w's.
+	header http.Header
+
+	mu          sync.Mutex
+	wroteHeader bool
+	// replaced is set when the handler's answer was replaced by a 413.
+	replaced bool
+	// done is set once the middleware returned, the handler must not touch
+	// w anymore.
+	done bool
+}
+
+// wrap returns lw with the optional interfaces of the wrapped writer,
+// without http.Hijacker unless hijack is set.
+func (lw *limitWriter) wrap(hijack bool) http.ResponseWriter {
+	f, isFlusher := lw.w.(http.Flusher)
+	h, isHijacker := lw.w.(http.Hijacker)
+	isHijacker = isHijacker && hijack
+	fl, hj := limitFlusher{lw, f}, limitHijacker{lw, h}
+
+	switch {
+	case isFlusher && isHijacker:
+		return struct {
+			*limitWriter
+			limitFlusher
+			limitHijacker
+		}{lw, fl, hj}
+	case isFlusher:
+		return struct {
+			*limitWriter
+			limitFlusher
+		}{lw, fl}
+	case isHijacker:
+		return struct {
+			*limitWriter
+			limitHijacker
+		}{lw, hj}
+	}
+	return lw
+}
+
+func (lw *limitWriter) Header() http.Header {
+	if lw.header != nil {
+		return lw.header
+	}
+	return lw.w.Header()
+}
+
+func (lw *limitWriter) WriteHeader(status int) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if !lw.done {
+		lw.writeHeader(status)
+	}
+}
+
+func (lw *limitWriter) writeHeader(status int) {
+	if lw.wroteHeader {
+		return
+	}
+	if lw.body != nil && lw.body.exceeded.Load() {
+		lw.wroteHeader, lw.replaced = true, true
+		writeTooLarge(lw.w, lw.body.limit)
+		return
+	}
+	if lw.header != nil {
+		h := lw.w.Header()
+		clear(h)
+		maps.Copy(h, lw.header)
+	}
+	// Informational responses go out right away, the final one follows.
+	if status >= 200 || status == http.StatusSwitchingProtocols {
+		lw.wroteHeader = true
+	}
+	lw.w.WriteHeader(status)
+}
+
+// Unwrap returns the wrapped ResponseWriter, for http.ResponseController.
+func (lw *limitWriter) Unwrap() http.ResponseWriter {
+	return lw.w
+}
+
+func (lw *limitWriter) Write(b []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if lw.done {
+		return 0, http.ErrHandlerTimeout
+	}
+	lw.writeHeader(http.StatusOK)
+	if lw.replaced {
+		return len(b), nil
+	}
+	return lw.w.Write(b)
+}
+
+// finish answers for a handler that returned without: 413 when the body
+// was too large, 504 when err says its deadline passed, or what net/http
+// would send.
+func (lw *limitWriter) finish(err error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	lw.done = true
+	switch {
+	case lw.wroteHeader:
+	case lw.body != nil && lw.body.exceeded.Load():
+		writeTooLarge(lw.w, lw.body.limit)
+	case errors.Is(err, context.DeadlineExceeded):
+		writeTimeout(lw.w, http.StatusGatewayTimeout)
+	case lw.header != nil:
+		h := lw.w.Header()
+		clear(h)
+		maps.Copy(h, lw.header)
+	}
+}
+
+// panicked passes the handler's panic on to the middleware, with the stack
+// where it happened, or logs it when the middleware returned already.
+func (lw *limitWriter) panicked(r *http.Request, p any, to chan<- any) {
+	// net/http's way to abort a response, it has no stack worth keeping.
+	if p != http.ErrAbortHandler {
+		p = newPanicError(p)
+	}
+
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if !lw.done {
+		to <- p
+		return
+	}
+	if pe, ok := p.(*PanicError); ok {
+		route := Route(r)
+		if route == "" {
+			route = unmatchedRoute
+		}
+		logPanic(r, "panic serving request after its timeout", route, pe.Value, pe.Stack)
+	}
+}
+
+// stop keeps a handler that's still running from writing. It reports
+// whether the handler had started the response.
+func (lw *limitWriter) stop() (answered bool) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	lw.done = true
+	return lw.wroteHeader
+}
+
+type limitFlusher struct {
+	lw *limitWriter
+	f  http.Flusher
+}
+
+func (f limitFlusher) Flush() {
+	f.lw.mu.Lock()
+	defer f.lw.mu.Unlock()
+	if f.lw.done {
+		return
+	}
+	f.lw.writeHeader(http.StatusOK)
+	if !f.lw.replaced {
+		f.f.Flush()
+	}
+}
+
+type limitHijacker struct {
+	lw *limitWriter
+	h  http.Hijacker
+}
+
+func (h limitHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := h.h.Hijack()
+	if err == nil {
+		h.lw.mu.Lock()
+		h.lw.wroteHeader = true
+		h.lw.mu.Unlock()
+	}
+	return conn, rw, err
+}
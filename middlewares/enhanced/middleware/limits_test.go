@@ -0,0 +1,192 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ZiadMansourM/middleware/logging"
+	"github.com/ZiadMansourM/middleware/utils"
+)
+
+func TestLimit(t *testing.T) {
+	release := make(chan struct{})
+	writeErr := make(chan error, 1)
+	router := http.NewServeMux()
+	router.HandleFunc("POST /tasks", func(w http.ResponseWriter, r *http.Request) {
+		// Like most handlers, answers 400 when the body can't be read.
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Location", "/tasks/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	})
+	router.HandleFunc("POST /uploads", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		fmt.Fprintf(w, "read %d", n)
+	})
+	router.HandleFunc("GET /slow", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", "slow")
+		<-release
+		_, err := w.Write([]byte("too late"))
+		writeErr <- err
+	})
+	router.HandleFunc("GET /query", func(w http.ResponseWriter, r *http.Request) {
+		// Stands in for a database query that honors the context.
+		<-r.Context().Done()
+	})
+	router.HandleFunc("GET /stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("started"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	router.HandleFunc("GET /panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	limits := NewRouteLimits(Limits{MaxBodyBytes: 16, Timeout: 20 * time.Millisecond}).
+		Route("POST /uploads", Limits{})
+	handler := ChainMiddlewares(router, Limit(limits))
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	problem := func(t *testing.T, rec *httptest.ResponseRecorder, status int) {
+		t.Helper()
+		var p utils.Problem
+		json.NewDecoder(rec.Body).Decode(&p)
+		if rec.Code != status || p.Status != status {
+			t.Errorf("Expected a %d problem, got %d %+v", status, rec.Code, p)
+		}
+	}
+
+	t.Run("Allows bodies within the limit", func(t *testing.T) {
+		rec := serve(httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(`{"name":"docs"}`)))
+		if rec.Code != http.StatusCreated || rec.Header().Get("Location") != "/tasks/1" || rec.Body.String() != `{"name":"docs"}` {
+			t.Errorf("Expected the handler's answer, got %d %v %q", rec.Code, rec.Header(), rec.Body.String())
+		}
+	})
+
+	t.Run("Refuses a large Content-Length upfront", func(t *testing.T) {
+		rec := serve(httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(strings.Repeat("x", 17))))
+		problem(t, rec, http.StatusRequestEntityTooLarge)
+	})
+
+	t.Run("Replaces the answer to a large streamed body", func(t *testing.T) {
+		// Without a Content-Length, as with chunked bodies.
+		req := httptest.NewRequest(http.MethodPost, "/tasks", struct{ io.Reader }{strings.NewReader(strings.Repeat("x", 17))})
+		req.ContentLength = -1
+		problem(t, serve(req), http.StatusRequestEntityTooLarge)
+	})
+
+	t.Run("Lifts the limits of a route", func(t *testing.T) {
+		rec := serve(httptest.NewRequest(http.MethodPost, "/uploads", strings.NewReader(strings.Repeat("x", 100))))
+		if rec.Body.String() != "read 100" {
+			t.Errorf("Expected the whole body to be read, got %q", rec.Body.String())
+		}
+	})
+
+	t.Run("Answers 503 when the handler is still running", func(t *testing.T) {
+		rec := serve(httptest.NewRequest(http.MethodGet, "/slow", nil))
+		problem(t, rec, http.StatusServiceUnavailable)
+		if rec.Header().Get("X-Handler") != "" {
+			t.Errorf("Expected the handler's headers to be left out, got %v", rec.Header())
+		}
+		close(release)
+		if err := <-writeErr; !errors.Is(err, http.ErrHandlerTimeout) {
+			t.Errorf("Expected later writes to fail, got %v", err)
+		}
+	})
+
+	t.Run("Answers a timeout when the handler gave up", func(t *testing.T) {
+		// Whether the handler returns before the deadline is noticed is a
+		// race: it's a 504 or a 503.
+		rec := serve(httptest.NewRequest(http.MethodGet, "/query", nil))
+		if rec.Code != http.StatusGatewayTimeout && rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected a timeout, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Aborts a response under way", func(t *testing.T) {
+		defer func() {
+			if p := recover(); p != http.ErrAbortHandler {
+				t.Errorf("Expected the response to be aborted, got %v", p)
+			}
+		}()
+		serve(httptest.NewRequest(http.MethodGet, "/stream", nil))
+	})
+
+	t.Run("Passes panics on with their stack", func(t *testing.T) {
+		defer func() {
+			p, ok := recover().(*PanicError)
+			if !ok || p.Value != "boom" || !strings.Contains(string(p.Stack), "limits_test.go") {
+				t.Errorf("Expected the handler's panic and stack, got %v", p)
+			}
+		}()
+		serve(httptest.NewRequest(http.MethodGet, "/panic", nil))
+	})
+
+	t.Run("Logs panics after the timeout", func(t *testing.T) {
+		lines := make(logLines, 1)
+		logger, _ := logging.New(lines, logging.FormatJSON, slog.LevelInfo)
+		defer slog.SetDefault(slog.Default())
+		slog.SetDefault(logger)
+
+		handler := ChainMiddlewares(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			// Let the middleware answer first.
+			time.Sleep(10 * time.Millisecond)
+			panic("late")
+		}), Limit(NewRouteLimits(Limits{Timeout: time.Millisecond})))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected the timeout to be answered, got %d", rec.Code)
+		}
+
+		var line map[string]any
+		select {
+		case b := <-lines:
+			json.Unmarshal(b, &line)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the panic to be logged")
+		}
+		if stack, _ := line["stack"].(string); line["panic"] != "late" || !strings.Contains(stack, "limits_test.go") {
+			t.Errorf("Expected the panic and its stack, got %v", line)
+		}
+	})
+
+	t.Run("Cancels the handler's context", func(t *testing.T) {
+		ctxErr := make(chan error, 1)
+		handler := ChainMiddlewares(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			ctxErr <- r.Context().Err()
+		}), Limit(NewRouteLimits(Limits{Timeout: time.Millisecond})))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		if err := <-ctxErr; !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected the deadline to be exceeded, got %v", err)
+		}
+	})
+}
+
+// logLines passes each line written to it on, for logs written by other
+// goroutines.
+type logLines chan []byte
+
+func (l logLines) Write(b []byte) (int, error) {
+	l <- append([]byte(nil), b...)
+	return len(b), nil
+}
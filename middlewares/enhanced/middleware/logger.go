@@ -1,30 +1,30 @@
 package middleware
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
-)
-
-type statusRecorder struct {
-	http.ResponseWriter
-	status int
-}
 
-func (rec *statusRecorder) WriteHeader(status int) {
-	rec.status = status
-	rec.ResponseWriter.WriteHeader(status)
-}
+	"github.com/ZiadMansourM/middleware/logging"
+)
 
+// Logger logs a structured line per request with slog's default logger,
+// see the logging package. The user is the one EnsureAuth authenticated.
 func Logger(handler http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		rec := &statusRecorder{
-			ResponseWriter: w,
-			status:         http.StatusOK,
-		}
-		log.Printf("Req: %s %s %s", FormatTracing(r), r.Method, r.URL.Path)
-		handler.ServeHTTP(rec, r)
-		log.Printf("Res: %s %s %d %v", FormatTracing(r), r.Method, rec.status, time.Since(start))
+		w, rec := WrapResponseWriter(w)
+		r = WithRoute(r.WithContext(logging.WithUser(r.Context())))
+		handler.ServeHTTP(w, r)
+
+		logging.LogRequest(r.Context(), slog.Default(), logging.Request{
+			Method:   r.Method,
+			Route:    Route(r),
+			Status:   rec.Status(),
+			Duration: time.Since(start),
+			Bytes:    rec.Bytes(),
+			TraceID:  requestTraceID(r.Context()),
+			UserID:   logging.UserID(r.Context()),
+		})
 	}
 }
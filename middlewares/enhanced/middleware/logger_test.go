@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ZiadMansourM/middleware/logging"
+)
+
+func TestLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := logging.New(&out, logging.FormatJSON, slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+
+	router := http.NewServeMux()
+	router.Handle("/users/me", NewEnsureAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("boom"))
+	})))
+	v1 := http.NewServeMux()
+	v1.Handle("/api/v1/", http.StripPrefix("/api/v1", Routes("/api/v1", router)))
+	handler := ChainMiddlewares(v1, Logger, Tracer)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/me", nil)
+	req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var line map[string]any
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("Expected one JSON line, got %q", out.String())
+	}
+	expected := map[string]any{
+		"level":    "ERROR",
+		"msg":      "request",
+		"method":   "GET",
+		"route":    "/api/v1/users/me",
+		"status":   float64(http.StatusInternalServerError),
+		"bytes":    float64(4),
+		"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736",
+		"user_id":  "1",
+	}
+	for key, value := range expected {
+		if line[key] != value {
+			t.Errorf("Expected %s to be %v, got %v", key, value, line[key])
+		}
+	}
+	if _, ok := line["duration"].(float64); !ok {
+		t.Errorf("Expected a duration, got %v", line["duration"])
+	}
+}
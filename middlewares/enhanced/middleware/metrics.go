@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// unmatchedRoute labels requests no route matched, so scanners probing
+// random paths can't blow up the number of series.
+const unmatchedRoute = "unmatched"
+
+// Metrics records the RED metrics of every request in reg: the request
+// rate, errors (by status class) and duration, plus the requests in flight
+// and the response sizes. Requests are labelled by method, status class
+// and route pattern (see Routes), never by raw path.
+func Metrics(reg prometheus.Registerer) Middleware {
+	labels := []string{"method", "route", "status"}
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests served.",
+	}, labels)
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time taken to serve HTTP requests.",
+		Buckets: prometheus.DefBuckets,
+	}, labels)
+	size := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_response_size_bytes",
+		Help:    "Size of HTTP response bodies.",
+		Buckets: prometheus.ExponentialBuckets(100, 10, 6),
+	}, labels)
+	inFlight := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "HTTP requests being served.",
+	}, []string{"method"})
+	reg.MustRegister(requests, duration, size, inFlight)
+
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			method := methodLabel(r.Method)
+			inFlight.WithLabelValues(method).Inc()
+			defer inFlight.WithLabelValues(method).Dec()
+
+			start := time.Now()
+			r = WithRoute(r)
+			w, rec := WrapResponseWriter(w)
+			handler.ServeHTTP(w, r)
+
+			route := Route(r)
+			if route == "" {
+				route = unmatchedRoute
+			}
+			lv := []string{method, route, statusClass(rec.Status())}
+			requests.WithLabelValues(lv...).Inc()
+			duration.WithLabelValues(lv...).Observe(time.Since(start).Seconds())
+			size.WithLabelValues(lv...).Observe(float64(rec.Bytes()))
+		}
+	}
+}
+
+// methodLabel maps methods outside the standard ones to OTHER, they are
+// client-controlled.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// statusClass returns "2xx" for 200 and so on.
+func statusClass(status int) string {
+	return strconv.Itoa(status/100) + "xx"
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+
+	router := http.NewServeMux()
+	router.HandleFunc("GET /tasks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") == "0" {
+			http.Error(w, "no such task", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("task"))
+	})
+	v1 := http.NewServeMux()
+	v1.Handle("/api/v1/", http.StripPrefix("/api/v1", Routes("/api/v1", router)))
+	handler := ChainMiddlewares(v1, Metrics(registry))
+
+	for _, path := range []string{"/api/v1/tasks/1", "/api/v1/tasks/2", "/api/v1/tasks/0", "/wp-login.php"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PROPFIND", "/api/v1/tasks/1", nil))
+
+	expected := `
+# HELP http_requests_total HTTP requests served.
+# TYPE http_requests_total counter
+http_requests_total{method="GET",route="GET /api/v1/tasks/{id}",status="2xx"} 2
+http_requests_total{method="GET",route="GET /api/v1/tasks/{id}",status="4xx"} 1
+http_requests_total{method="GET",route="unmatched",status="4xx"} 1
+http_requests_total{method="OTHER",route="/api/v1/",status="4xx"} 1
+# HELP http_requests_in_flight HTTP requests being served.
+# TYPE http_requests_in_flight gauge
+http_requests_in_flight{method="GET"} 0
+http_requests_in_flight{method="OTHER"} 0
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "http_requests_total", "http_requests_in_flight"); err != nil {
+		t.Error(err)
+	}
+
+	if n := testutil.CollectAndCount(registry, "http_request_duration_seconds", "http_response_size_bytes"); n != 8 {
+		t.Errorf("Expected a duration and a size series per label set, got %d", n)
+	}
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/ZiadMansourM/middleware"
+
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Tracing starts an OpenTelemetry server span for every request, continuing
+// the caller's W3C trace. Spans are named by the matched route pattern
+// (see Routes) and record the status and, via SetSpanUser, the user.
+//
+// Like Tracer it stores the trace context in the request context and
+// echoes the traceparent, but of the exported span, so use one or the
+// other.
+func Tracing(tp trace.TracerProvider) Middleware {
+	tracer := tp.Tracer(instrumentationName)
+
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			parent := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tracer.Start(parent, r.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("http.request.method", r.Method),
+					attribute.String("url.path", r.URL.Path),
+					attribute.String("url.scheme", scheme(r)),
+					attribute.String("server.address", r.Host),
+					attribute.String("client.address", r.RemoteAddr),
+					attribute.String("user_agent.original", r.UserAgent()),
+				),
+			)
+			defer span.End()
+
+			sc := span.SpanContext()
+			tc := TraceContext{
+				TraceID: sc.TraceID(),
+				SpanID:  sc.SpanID(),
+				Flags:   byte(sc.TraceFlags()),
+				State:   sc.TraceState().String(),
+			}
+			if p := trace.SpanContextFromContext(parent); p.IsValid() {
+				tc.ParentID = p.SpanID()
+			}
+			propagator.Inject(ctx, propagation.HeaderCarrier(w.Header()))
+
+			r = WithRoute(r.WithContext(ContextWithTrace(ctx, tc)))
+			w, rec := WrapResponseWriter(w)
+			handler.ServeHTTP(w, r)
+
+			if route := Route(r); route != "" {
+				if !strings.Contains(route, " ") {
+					route = r.Method + " " + route
+				}
+				span.SetName(route)
+				_, path, _ := strings.Cut(route, " ")
+				span.SetAttributes(attribute.String("http.route", path))
+			}
+			span.SetAttributes(attribute.Int("http.response.status_code", rec.Status()))
+			if rec.Status() >= 500 {
+				span.SetStatus(codes.Error, http.StatusText(rec.Status()))
+			}
+		}
+	}
+}
+
+// SetSpanUser records the authenticated user on the request's span.
+func SetSpanUser(r *http.Request, userID string) {
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("enduser.id", userID))
+}
+
+func scheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	router := http.NewServeMux()
+	router.Handle("/users/me", NewEnsureAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})))
+	router.HandleFunc("GET /fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	v1 := http.NewServeMux()
+	v1.Handle("/api/v1/", http.StripPrefix("/api/v1", Routes("/api/v1", router)))
+	handler := ChainMiddlewares(v1, Tracing(tp))
+
+	attributes := func(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+		m := make(map[attribute.Key]attribute.Value)
+		for _, kv := range span.Attributes {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+
+	t.Run("Names the span by route and continues the caller's trace", func(t *testing.T) {
+		exporter.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users/me", nil)
+		req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		spans := exporter.GetSpans()
+		if len(spans) != 1 {
+			t.Fatalf("Expected one span, got %d", len(spans))
+		}
+		span := spans[0]
+		if span.Name != "GET /api/v1/users/me" || span.SpanKind != trace.SpanKindServer {
+			t.Errorf("Unexpected span %q of kind %v", span.Name, span.SpanKind)
+		}
+		if span.Parent.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || span.Parent.SpanID().String() != "00f067aa0ba902b7" {
+			t.Errorf("Expected the caller's span as parent, got %v", span.Parent)
+		}
+		attrs := attributes(span)
+		if attrs["http.route"].AsString() != "/api/v1/users/me" || attrs["http.response.status_code"].AsInt64() != http.StatusTeapot || attrs["enduser.id"].AsString() != "1" {
+			t.Errorf("Unexpected attributes %v", span.Attributes)
+		}
+		if span.Status.Code == codes.Error {
+			t.Error("Expected a 4xx response to leave the span status unset")
+		}
+		if rec.Header().Get(TraceparentHeader) != "00-4bf92f3577b34da6a3ce929d0e0e4736-"+span.SpanContext.SpanID().String()+"-01" {
+			t.Errorf("Expected the response to carry the span's traceparent, got %q", rec.Header().Get(TraceparentHeader))
+		}
+	})
+
+	t.Run("Marks server errors", func(t *testing.T) {
+		exporter.Reset()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/fail", nil))
+
+		span := exporter.GetSpans()[0]
+		if span.Name != "GET /api/v1/fail" || span.Status.Code != codes.Error || span.Parent.IsValid() {
+			t.Errorf("Expected a failed root span, got %q %v", span.Name, span.Status)
+		}
+	})
+
+	t.Run("Falls back to the method for unmatched requests", func(t *testing.T) {
+		exporter.Reset()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nowhere", nil))
+
+		if span := exporter.GetSpans()[0]; span.Name != "GET" {
+			t.Errorf("Unexpected span name %q", span.Name)
+		}
+	})
+}
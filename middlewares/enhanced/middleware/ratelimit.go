@@ -0,0 +1,284 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ZiadMansourM/middleware/utils"
+)
+
+// RateAlgorithm decides how requests are counted against a Rate.
+type RateAlgorithm int
+
+const (
+	// TokenBucket refills Requests tokens per Window into a bucket of Burst
+	// tokens, so clients can burst and then go on at the average rate.
+	TokenBucket RateAlgorithm = iota
+	// SlidingWindow allows Requests in any Window, estimated from the counts
+	// of the current and previous fixed windows.
+	SlidingWindow
+)
+
+// Rate allows Requests per Window. A zero Rate doesn't limit.
+type Rate struct {
+	Requests  int
+	Window    time.Duration
+	Algorithm RateAlgorithm
+	// Burst is the token bucket size, Requests when zero.
+	Burst int
+}
+
+// RateLimitDecision is the outcome of counting a request.
+type RateLimitDecision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	Window    time.Duration
+	// Reset is when the client has its whole quota again, RetryAfter when
+	// a denied request would be allowed.
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the state of the limits. Update must be atomic per
+// key: with concurrent updates each fn sees the state the previous one
+// returned. The state is nil for new keys and can be dropped after ttl.
+type RateLimitStore interface {
+	Update(ctx context.Context, key string, ttl time.Duration, fn func(state []byte) ([]byte, error)) error
+}
+
+// RateLimitKey returns who a request is counted for.
+type RateLimitKey func(r *http.Request) string
+
+// KeyByIP counts requests per client IP, as seen by the server: behind a
+// proxy, set RemoteAddr from the forwarded headers first.
+func KeyByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// KeyByUser counts requests per user authenticated by EnsureAuth, and
+// anonymous ones per IP. Only handlers wrapped by EnsureAuth see the user.
+func KeyByUser(r *http.Request) string {
+	if user := AuthenticatedUser(r); user != nil {
+		return "user:" + strconv.Itoa(user.ID)
+	}
+	return KeyByIP(r)
+}
+
+// KeyByAPIKey counts requests per API key sent in header, and requests
+// without one per IP. Keys are hashed so the store never holds them.
+func KeyByAPIKey(header string) RateLimitKey {
+	return func(r *http.Request) string {
+		key := r.Header.Get(header)
+		if key == "" {
+			return KeyByIP(r)
+		}
+		sum := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(sum[:16])
+	}
+}
+
+// RateLimiter applies a default rate and rates per route pattern.
+type RateLimiter struct {
+	store RateLimitStore
+	key   RateLimitKey
+	rate  Rate
+	// routes matches requests against the patterns given to Route, with
+	// ServeMux precedence, so it doesn't matter where the limiter sits.
+	routes *http.ServeMux
+	rates  map[string]Rate
+	now    func() time.Time
+}
+
+// NewRateLimiter limits every client, as told apart by key, to rate.
+func NewRateLimiter(store RateLimitStore, key RateLimitKey, rate Rate) *RateLimiter {
+	return &RateLimiter{
+		store:  store,
+		key:    key,
+		rate:   rate,
+		routes: http.NewServeMux(),
+		rates:  make(map[string]Rate),
+		now:    time.Now,
+	}
+}
+
+// Route gives requests matching pattern their own rate, counted apart
+// from the default one. Patterns are ServeMux patterns of the full path,
+// e.g. "POST /api/v1/users/login"; a zero rate exempts the route.
+func (l *RateLimiter) Route(pattern string, rate Rate) *RateLimiter {
+	l.routes.Handle(pattern, http.NotFoundHandler())
+	l.rates[pattern] = rate
+	return l
+}
+
+// Allow counts r and reports whether it's within its rate. ok is false for
+// requests that aren't limited.
+func (l *RateLimiter) Allow(r *http.Request) (d RateLimitDecision, ok bool, err error) {
+	rate, scope := l.rate, "*"
+	if _, pattern := l.routes.Handler(r); pattern != "" {
+		rate, scope = l.rates[pattern], pattern
+	}
+	if rate.Requests <= 0 || rate.Window <= 0 {
+		return d, false, nil
+	}
+
+	now := l.now()
+	key := "ratelimit:" + scope + ":" + l.key(r)
+	err = l.store.Update(r.Context(), key, rate.ttl(), func(state []byte) ([]byte, error) {
+		d, state = rate.take(state, now)
+		return state, nil
+	})
+	return d, err == nil, err
+}
+
+// RateLimit answers requests over their rate with 429 Too Many Requests.
+// Responses carry the RateLimit-Limit, RateLimit-Remaining,
+// RateLimit-Reset and RateLimit-Policy headers, and Retry-After when
+// denied. When the store fails, requests are let through.
+func RateLimit(l *RateLimiter) Middleware {
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			d, ok, err := l.Allow(r)
+			if err != nil {
+				slog.Default().ErrorContext(r.Context(), "rate limit store failed, not limiting", "error", err)
+			}
+			if !ok {
+				handler.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+			h.Set("RateLimit-Reset", seconds(d.Reset))
+			h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%s", d.Limit, seconds(d.Window)))
+			if !d.Allowed {
+				h.Set("Retry-After", seconds(d.RetryAfter))
+				utils.WriteProblem(w, http.StatusTooManyRequests, "rate limit exceeded, retry in "+seconds(d.RetryAfter)+"s")
+				return
+			}
+			handler.ServeHTTP(w, r)
+		}
+	}
+}
+
+// seconds rounds up, a client retrying after a rounded down delay would
+// be denied again.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+func (rate Rate) burst() int {
+	if rate.Algorithm == TokenBucket && rate.Burst > 0 {
+		return rate.Burst
+	}
+	return rate.Requests
+}
+
+// ttl is how long the state matters: until the bucket is full again, or
+// the current window became the previous one and ended too.
+func (rate Rate) ttl() time.Duration {
+	if rate.Algorithm == SlidingWindow {
+		return 2 * rate.Window
+	}
+	return rate.Window * time.Duration(rate.burst()) / time.Duration(rate.Requests)
+}
+
+func (rate Rate) take(state []byte, now time.Time) (RateLimitDecision, []byte) {
+	if rate.Algorithm == SlidingWindow {
+		return rate.slidingWindow(state, now)
+	}
+	return rate.tokenBucket(state, now)
+}
+
+// tokenBucket state is the tokens left and when they were counted.
+func (rate Rate) tokenBucket(state []byte, now time.Time) (RateLimitDecision, []byte) {
+	capacity := float64(rate.burst())
+	perNano := float64(rate.Requests) / float64(rate.Window)
+
+	tokens, last := capacity, now.UnixNano()
+	if len(state) == 16 {
+		tokens = math.Float64frombits(binary.BigEndian.Uint64(state))
+		last = int64(binary.BigEndian.Uint64(state[8:]))
+	}
+	// Another server's clock may be ahead, don't refill backwards.
+	if elapsed := now.UnixNano() - last; elapsed > 0 {
+		tokens = math.Min(capacity, tokens+float64(elapsed)*perNano)
+		last = now.UnixNano()
+	}
+
+	d := RateLimitDecision{Limit: int(capacity), Window: rate.Window}
+	if tokens >= 1 {
+		tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = time.Duration(math.Ceil((1 - tokens) / perNano))
+	}
+	d.Remaining = int(tokens)
+	d.Reset = time.Duration(math.Ceil((capacity - tokens) / perNano))
+
+	state = binary.BigEndian.AppendUint64(nil, math.Float64bits(tokens))
+	return d, binary.BigEndian.AppendUint64(state, uint64(last))
+}
+
+// slidingWindow state is the start of the current fixed window and the
+// counts of the previous and current one. The previous count is weighted
+// by how much of it the sliding window still covers.
+func (rate Rate) slidingWindow(state []byte, now time.Time) (RateLimitDecision, []byte) {
+	window, n := int64(rate.Window), now.UnixNano()
+	start := n - n%window
+
+	var prev, curr int64
+	if len(state) == 24 {
+		switch s := int64(binary.BigEndian.Uint64(state)); s {
+		case start:
+			prev = int64(binary.BigEndian.Uint64(state[8:]))
+			curr = int64(binary.BigEndian.Uint64(state[16:]))
+		case start - window:
+			prev = int64(binary.BigEndian.Uint64(state[16:]))
+		}
+	}
+
+	limit := float64(rate.Requests)
+	elapsed := float64(n-start) / float64(window)
+	estimate := float64(prev)*(1-elapsed) + float64(curr)
+
+	d := RateLimitDecision{Limit: rate.Requests, Window: rate.Window}
+	if estimate+1 <= limit {
+		curr++
+		estimate++
+		d.Allowed = true
+	} else if float64(curr)+1 <= limit {
+		// Room once enough of the previous window slid out.
+		at := start + int64(math.Ceil(float64(window)*(1-(limit-1-float64(curr))/float64(prev))))
+		d.RetryAfter = time.Duration(at - n)
+	} else {
+		// The current window becomes the previous one and has to slide out.
+		at := start + window + int64(math.Ceil(float64(window)*(1-(limit-1)/float64(curr))))
+		d.RetryAfter = time.Duration(at - n)
+	}
+	d.Remaining = max(0, int(limit-estimate))
+	switch {
+	case curr > 0:
+		d.Reset = time.Duration(start + 2*window - n)
+	case prev > 0:
+		d.Reset = time.Duration(start + window - n)
+	}
+
+	state = binary.BigEndian.AppendUint64(nil, uint64(start))
+	state = binary.BigEndian.AppendUint64(state, uint64(prev))
+	return d, binary.BigEndian.AppendUint64(state, uint64(curr))
+}
@@ -0,0 +1,280 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryRateLimitStore keeps the limits of a single server in memory.
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryRateLimitEntry
+	updates int
+}
+
+type memoryRateLimitEntry struct {
+	state   []byte
+	expires time.Time
+}
+
+// sweepEvery updates expired entries are dropped, so clients that went
+// away don't pile up.
+const sweepEvery = 1024
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{entries: make(map[string]memoryRateLimitEntry)}
+}
+
+func (s *MemoryRateLimitStore) Update(ctx context.Context, key string, ttl time.Duration, fn func(state []byte) ([]byte, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.updates++
+	if s.updates%sweepEvery == 0 {
+		for k, e := range s.entries {
+			if now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+	}
+
+	var state []byte
+	if e, ok := s.entries[key]; ok && now.Before(e.expires) {
+		state = e.state
+	}
+	state, err := fn(state)
+	if err != nil {
+		return err
+	}
+	s.entries[key] = memoryRateLimitEntry{state: state, expires: now.Add(ttl)}
+	return nil
+}
+
+// RedisRateLimitStore shares the limits of several servers through a
+// server speaking the Redis protocol (RESP), such as Redis or Valkey.
+// Updates are optimistic transactions: WATCH, GET, then SET in MULTI/EXEC,
+// retried when another server changed the key in between.
+type RedisRateLimitStore struct {
+	addr     string
+	password string
+	// Timeout bounds each update when the context has no deadline.
+	Timeout time.Duration
+
+	pool chan *respConn
+}
+
+// maxUpdateAttempts bounds the retries of a contended key.
+const maxUpdateAttempts = 10
+
+var errRateLimitContention = errors.New("ratelimit: too many concurrent updates")
+
+// NewRedisRateLimitStore connects to addr on first use, authenticating
+// with password unless it's empty.
+func NewRedisRateLimitStore(addr, password string) *RedisRateLimitStore {
+	return &RedisRateLimitStore{
+		addr:     addr,
+		password: password,
+		Timeout:  time.Second,
+		pool:     make(chan *respConn, 16),
+	}
+}
+
+func (s *RedisRateLimitStore) Update(ctx context.Context, key string, ttl time.Duration, fn func(state []byte) ([]byte, error)) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	for range maxUpdateAttempts {
+		conn, err := s.get(ctx)
+		if err != nil {
+			return err
+		}
+		done, err := s.update(conn, key, ttl, fn)
+		if err != nil {
+			// The connection may be mid-reply or still watching the key.
+			conn.Close()
+			return err
+		}
+		s.put(conn)
+		if done {
+			return nil
+		}
+	}
+	return errRateLimitContention
+}
+
+// update runs one transaction. done is false when it lost a race.
+func (s *RedisRateLimitStore) update(conn *respConn, key string, ttl time.Duration, fn func(state []byte) ([]byte, error)) (done bool, err error) {
+	if _, err := conn.do("WATCH", key); err != nil {
+		return false, err
+	}
+	reply, err := conn.do("GET", key)
+	if err != nil {
+		return false, err
+	}
+	state, _ := reply.([]byte)
+
+	state, err = fn(state)
+	if err != nil {
+		return false, err
+	}
+
+	ms := max(1, ttl.Milliseconds())
+	conn.send("MULTI")
+	conn.send("SET", key, string(state), "PX", strconv.FormatInt(ms, 10))
+	conn.send("EXEC")
+	if err := conn.flush(); err != nil {
+		return false, err
+	}
+	for range 2 {
+		if _, err := conn.receive(); err != nil {
+			return false, err
+		}
+	}
+	reply, err = conn.receive()
+	if err != nil {
+		return false, err
+	}
+	// EXEC replies with a nil array when the watched key changed.
+	return reply != nil, nil
+}
+
+func (s *RedisRateLimitStore) get(ctx context.Context) (*respConn, error) {
+	var conn *respConn
+	select {
+	case conn = <-s.pool:
+	default:
+		var d net.Dialer
+		c, err := d.DialContext(ctx, "tcp", s.addr)
+		if err != nil {
+			return nil, err
+		}
+		conn = newRESPConn(c)
+		if s.password != "" {
+			if _, err := conn.do("AUTH", s.password); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+	}
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (s *RedisRateLimitStore) put(conn *respConn) {
+	select {
+	case s.pool <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// Close closes the idle connections.
+func (s *RedisRateLimitStore) Close() error {
+	for {
+		select {
+		case conn := <-s.pool:
+			conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// respConn speaks just enough RESP2 for RedisRateLimitStore.
+type respConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// respError is an error reply.
+type respError string
+
+func (e respError) Error() string {
+	return "redis: " + string(e)
+}
+
+func newRESPConn(c net.Conn) *respConn {
+	return &respConn{Conn: c, r: bufio.NewReader(c), w: bufio.NewWriter(c)}
+}
+
+func (c *respConn) do(args ...string) (any, error) {
+	c.send(args...)
+	if err := c.flush(); err != nil {
+		return nil, err
+	}
+	return c.receive()
+}
+
+// send buffers a command as an array of bulk strings.
+func (c *respConn) send(args ...string) {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+func (c *respConn) flush() error {
+	return c.w.Flush()
+}
+
+// receive reads a reply: a string, int64, []byte, []any or nil. Error
+// replies are returned as a respError.
+func (c *respConn) receive() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, respError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = c.receive(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
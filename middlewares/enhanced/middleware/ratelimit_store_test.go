@@ -0,0 +1,240 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-process stand-in for a Redis server, implementing the
+// commands RedisRateLimitStore sends.
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+
+	mu       sync.Mutex
+	values   map[string]string
+	expires  map[string]time.Time
+	versions map[string]int
+	// beforeExec runs before each EXEC, to interfere with transactions.
+	beforeExec func()
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{
+		ln:       ln,
+		password: password,
+		values:   make(map[string]string),
+		expires:  make(map[string]time.Time),
+		versions: make(map[string]int),
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) set(key, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.values[key] = value
+	f.versions[key]++
+}
+
+func (f *fakeRedis) serve(c net.Conn) {
+	defer c.Close()
+	conn := newRESPConn(c)
+	authenticated := f.password == ""
+	watched := map[string]int{}
+	var queue [][]string
+	inMulti := false
+
+	reply := func(s string) { conn.w.WriteString(s) }
+	for {
+		v, err := conn.receive()
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range v.([]any) {
+			args = append(args, string(arg.([]byte)))
+		}
+		cmd := strings.ToUpper(args[0])
+
+		switch {
+		case cmd == "AUTH":
+			if args[1] != f.password {
+				reply("-WRONGPASS invalid password\r\n")
+				break
+			}
+			authenticated = true
+			reply("+OK\r\n")
+		case !authenticated:
+			reply("-NOAUTH Authentication required.\r\n")
+		case cmd == "MULTI":
+			inMulti = true
+			reply("+OK\r\n")
+		case inMulti && cmd != "EXEC":
+			queue = append(queue, args)
+			reply("+QUEUED\r\n")
+		case cmd == "WATCH":
+			f.mu.Lock()
+			watched[args[1]] = f.versions[args[1]]
+			f.mu.Unlock()
+			reply("+OK\r\n")
+		case cmd == "GET":
+			f.mu.Lock()
+			value, ok := f.values[args[1]]
+			if exp, set := f.expires[args[1]]; set && time.Now().After(exp) {
+				ok = false
+			}
+			f.mu.Unlock()
+			if !ok {
+				reply("$-1\r\n")
+				break
+			}
+			reply("$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n")
+		case cmd == "EXEC":
+			if f.beforeExec != nil {
+				f.beforeExec()
+			}
+			f.mu.Lock()
+			conflict := false
+			for key, version := range watched {
+				conflict = conflict || f.versions[key] != version
+			}
+			if conflict {
+				reply("*-1\r\n")
+			} else {
+				reply("*" + strconv.Itoa(len(queue)) + "\r\n")
+				for _, q := range queue {
+					// Only SET key value PX ms is queued.
+					ms, _ := strconv.Atoi(q[4])
+					f.values[q[1]] = q[2]
+					f.expires[q[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+					f.versions[q[1]]++
+					reply("+OK\r\n")
+				}
+			}
+			f.mu.Unlock()
+			watched, queue, inMulti = map[string]int{}, nil, false
+		default:
+			reply("-ERR unknown command '" + args[0] + "'\r\n")
+		}
+		conn.flush()
+	}
+}
+
+func TestRedisRateLimitStore(t *testing.T) {
+	fake := newFakeRedis(t, "hunter2")
+	store := NewRedisRateLimitStore(fake.ln.Addr().String(), "hunter2")
+	defer store.Close()
+	ctx := context.Background()
+
+	t.Run("Serializes concurrent updates", func(t *testing.T) {
+		increment := func(state []byte) ([]byte, error) {
+			return append(state, 'x'), nil
+		}
+		var wg sync.WaitGroup
+		errs := make(chan error, 20)
+		for range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- store.Update(ctx, "k", time.Minute, increment)
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			// Heavy contention may exhaust the retries, that's reported.
+			if err != nil && !errors.Is(err, errRateLimitContention) {
+				t.Fatal(err)
+			}
+		}
+
+		var got []byte
+		store.Update(ctx, "k", time.Minute, func(state []byte) ([]byte, error) {
+			got = state
+			return state, nil
+		})
+		fake.mu.Lock()
+		writes := fake.versions["k"]
+		fake.mu.Unlock()
+		if len(got) != writes-1 {
+			t.Errorf("Expected every write to build on the previous one, got %d bytes after %d writes", len(got), writes)
+		}
+	})
+
+	t.Run("Retries when the key changed", func(t *testing.T) {
+		interfered := false
+		fake.beforeExec = func() {
+			if !interfered {
+				interfered = true
+				fake.set("race", "other")
+			}
+		}
+		defer func() { fake.beforeExec = nil }()
+
+		var seen []string
+		err := store.Update(ctx, "race", time.Minute, func(state []byte) ([]byte, error) {
+			seen = append(seen, string(state))
+			return []byte("mine"), nil
+		})
+		fake.mu.Lock()
+		value := fake.values["race"]
+		fake.mu.Unlock()
+		if err != nil || len(seen) != 2 || seen[1] != "other" || value != "mine" {
+			t.Errorf("Expected a retry on top of the other write, got %v %q", err, seen)
+		}
+	})
+
+	t.Run("Expires state", func(t *testing.T) {
+		store.Update(ctx, "short", time.Millisecond, func([]byte) ([]byte, error) { return []byte("x"), nil })
+		time.Sleep(5 * time.Millisecond)
+		store.Update(ctx, "short", time.Minute, func(state []byte) ([]byte, error) {
+			if state != nil {
+				t.Errorf("Expected the state to have expired, got %q", state)
+			}
+			return state, nil
+		})
+	})
+
+	t.Run("Reports errors", func(t *testing.T) {
+		wrong := NewRedisRateLimitStore(fake.ln.Addr().String(), "wrong")
+		err := wrong.Update(ctx, "k", time.Minute, func(state []byte) ([]byte, error) { return state, nil })
+		var respErr respError
+		if !errors.As(err, &respErr) || !strings.HasPrefix(string(respErr), "WRONGPASS") {
+			t.Errorf("Expected the server's error, got %v", err)
+		}
+	})
+
+	t.Run("Limits requests", func(t *testing.T) {
+		limiter := NewRateLimiter(store, KeyByIP, Rate{Requests: 1, Window: time.Minute})
+		req := httptest.NewRequest("GET", "/", nil)
+		if d, ok, err := limiter.Allow(req); err != nil || !ok || !d.Allowed {
+			t.Fatalf("Expected the first request to be allowed, got %+v %v", d, err)
+		}
+		if d, _, _ := limiter.Allow(req); d.Allowed {
+			t.Errorf("Expected the second request to be denied, got %+v", d)
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	rate := Rate{Requests: 2, Window: time.Second, Burst: 4}
+	now := time.Unix(1700000000, 0)
+
+	var state []byte
+	var d RateLimitDecision
+	for i := range 4 {
+		d, state = rate.take(state, now)
+		if !d.Allowed || d.Remaining != 3-i {
+			t.Fatalf("Expected request %d of the burst to be allowed, got %+v", i, d)
+		}
+	}
+	if d, state = rate.take(state, now); d.Allowed || d.RetryAfter != 500*time.Millisecond || d.Reset != 2*time.Second {
+		t.Errorf("Expected the empty bucket to deny for 500ms, got %+v", d)
+	}
+	if d, state = rate.take(state, now.Add(500*time.Millisecond)); !d.Allowed || d.Remaining != 0 {
+		t.Errorf("Expected a token to be refilled, got %+v", d)
+	}
+	if d, _ = rate.take(state, now.Add(time.Hour)); !d.Allowed || d.Remaining != 3 {
+		t.Errorf("Expected the bucket to refill no further than its size, got %+v", d)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	rate := Rate{Requests: 4, Window: time.Minute, Algorithm: SlidingWindow}
+	start := time.Unix(1700000000-1700000000%60, 0)
+
+	var state []byte
+	var d RateLimitDecision
+	for i := range 4 {
+		d, state = rate.take(state, start.Add(30*time.Second))
+		if !d.Allowed || d.Remaining != 3-i {
+			t.Fatalf("Expected request %d to be allowed, got %+v", i, d)
+		}
+	}
+	if d, state = rate.take(state, start.Add(45*time.Second)); d.Allowed || d.RetryAfter != 30*time.Second || d.Reset != 75*time.Second {
+		t.Errorf("Expected a full window to deny until a request slid out, got %+v", d)
+	}
+
+	// A quarter into the next window 3 of the 4 requests still count.
+	next := start.Add(75 * time.Second)
+	if d, state = rate.take(state, next); !d.Allowed || d.Remaining != 0 {
+		t.Errorf("Expected one request to be allowed, got %+v", d)
+	}
+	if d, _ = rate.take(state, next); d.Allowed || d.RetryAfter != 15*time.Second {
+		t.Errorf("Expected the next one to wait for another request to slide out, got %+v", d)
+	}
+	if d, _ = rate.take(state, start.Add(3*time.Minute)); !d.Allowed || d.Remaining != 3 {
+		t.Errorf("Expected old windows to be forgotten, got %+v", d)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), KeyByIP, Rate{Requests: 100, Window: time.Minute}).
+		Route("POST /api/v1/login", Rate{Requests: 2, Window: time.Minute, Algorithm: SlidingWindow}).
+		Route("/health", Rate{})
+	now := time.Unix(1700000000, 0)
+	limiter.now = func() time.Time { return now }
+
+	handler := ChainMiddlewares(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), RateLimit(limiter))
+	call := func(method, path, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Limits per route and client", func(t *testing.T) {
+		for range 2 {
+			if rec := call(http.MethodPost, "/api/v1/login", "192.0.2.1"); rec.Code != http.StatusOK {
+				t.Fatalf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+			}
+		}
+
+		rec := call(http.MethodPost, "/api/v1/login", "192.0.2.1")
+		if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Content-Type") != "application/problem+json" {
+			t.Fatalf("Expected a 429 problem, got %d", rec.Code)
+		}
+		h := rec.Header()
+		if h.Get("RateLimit-Limit") != "2" || h.Get("RateLimit-Remaining") != "0" || h.Get("RateLimit-Policy") != "2;w=60" || h.Get("Retry-After") == "" {
+			t.Errorf("Unexpected headers %v", h)
+		}
+
+		if rec := call(http.MethodPost, "/api/v1/login", "192.0.2.2"); rec.Code != http.StatusOK {
+			t.Errorf("Expected other clients to have their own limit, got %d", rec.Code)
+		}
+		if rec := call(http.MethodGet, "/api/v1/tasks", "192.0.2.1"); rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Remaining") != "99" {
+			t.Errorf("Expected other routes to count against the default rate, got %d %v", rec.Code, rec.Header())
+		}
+	})
+
+	t.Run("Exempts routes with a zero rate", func(t *testing.T) {
+		rec := call(http.MethodGet, "/health", "192.0.2.1")
+		if rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "" {
+			t.Errorf("Expected no limit, got %d %v", rec.Code, rec.Header())
+		}
+	})
+}
+
+func TestKeys(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "[2001:db8::1]:443"
+	if key := KeyByIP(req); key != "ip:2001:db8::1" {
+		t.Errorf("Unexpected key %q", key)
+	}
+
+	byKey := KeyByAPIKey("X-API-Key")
+	if byKey(req) != "ip:2001:db8::1" {
+		t.Error("Expected requests without an API key to be counted per IP")
+	}
+	req.Header.Set("X-API-Key", "secret")
+	if key := byKey(req); key == "key:secret" || len(key) != len("key:")+32 {
+		t.Errorf("Expected a hashed key, got %q", key)
+	}
+
+	req = req.WithContext(context.WithValue(req.Context(), authenticatedUserKey, &User{ID: 7}))
+	if key := KeyByUser(req); key != "user:7" {
+		t.Errorf("Unexpected key %q", key)
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	increment := func(state []byte) ([]byte, error) {
+		return append(state, 'x'), nil
+	}
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.Update(context.Background(), "k", time.Minute, increment)
+		}()
+	}
+	wg.Wait()
+
+	var got []byte
+	store.Update(context.Background(), "k", time.Minute, func(state []byte) ([]byte, error) {
+		got = state
+		return state, nil
+	})
+	if len(got) != 50 {
+		t.Errorf("Expected every update to see the previous one, got %d", len(got))
+	}
+
+	store.Update(context.Background(), "gone", -time.Second, increment)
+	store.Update(context.Background(), "gone", time.Minute, func(state []byte) ([]byte, error) {
+		if state != nil {
+			t.Errorf("Expected the expired state to be dropped, got %q", state)
+		}
+		return state, nil
+	})
+}
@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/ZiadMansourM/middleware/logging"
+	"github.com/ZiadMansourM/middleware/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Recover turns a panicking handler into a 500 problem response, logs the
+// panic with its stack, route and trace ID, and counts it in reg.
+//
+// Once the response has started its status can't be changed anymore, so
+// the connection is aborted instead, telling the client the response is
+// incomplete. Put Recover inside Logger, Metrics and Tracing so they record
+// the 500.
+func Recover(reg prometheus.Registerer) Middleware {
+	panics := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_panics_total",
+		Help: "Panics recovered from HTTP handlers.",
+	}, []string{"route"})
+	reg.MustRegister(panics)
+
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w, rec := WrapResponseWriter(w)
+			r = WithRoute(r)
+
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				stack := debug.Stack()
+				// Panics of handlers run on their own goroutine, by Limit,
+				// come with the stack where they happened.
+				if p, ok := v.(*PanicError); ok {
+					v, stack = p.Value, p.Stack
+				}
+				// net/http's way to abort a response, it isn't a bug.
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+
+				route := Route(r)
+				if route == "" {
+					route = unmatchedRoute
+				}
+				panics.WithLabelValues(route).Inc()
+
+				logPanic(r, "panic serving request", route, v, stack)
+
+				if rec.Written() {
+					panic(http.ErrAbortHandler)
+				}
+				// Headers meant for the response that was never sent.
+				for _, h := range []string{"Content-Length", "Content-Encoding", "Content-Disposition", "Etag", "Last-Modified"} {
+					w.Header().Del(h)
+				}
+				utils.WriteProblem(w, http.StatusInternalServerError, "")
+			}()
+
+			handler.ServeHTTP(w, r)
+		}
+	}
+}
+
+// PanicError carries a panic from the goroutine a handler runs on to the
+// server's, where it's panicked again for Recover and net/http. Stack is
+// where the handler panicked.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func newPanicError(v any) *PanicError {
+	return &PanicError{Value: v, Stack: debug.Stack()}
+}
+
+// Error includes the stack, net/http logs nothing else without Recover.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%v\n\n%s", e.Value, e.Stack)
+}
+
+// Unwrap returns the panic value if it's an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// logPanic logs a handler's panic with its stack, route and trace ID.
+func logPanic(r *http.Request, msg, route string, v any, stack []byte) {
+	attrs := []slog.Attr{
+		slog.String("panic", fmt.Sprint(v)),
+		slog.String(logging.KeyMethod, r.Method),
+		slog.String(logging.KeyRoute, route),
+		slog.String("stack", string(stack)),
+	}
+	if traceID := requestTraceID(r.Context()); traceID != "" {
+		attrs = append(attrs, slog.String(logging.KeyTraceID, traceID))
+	}
+	slog.Default().LogAttrs(r.Context(), slog.LevelError, msg, attrs...)
+}
+
+// requestTraceID returns the trace ID stored by Tracer or Tracing, or of
+// an OpenTelemetry span started elsewhere.
+func requestTraceID(ctx context.Context) string {
+	if tc, ok := TraceFromContext(ctx); ok {
+		return tc.TraceIDString()
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
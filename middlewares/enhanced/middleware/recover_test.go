@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ZiadMansourM/middleware/logging"
+	"github.com/ZiadMansourM/middleware/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecover(t *testing.T) {
+	var out bytes.Buffer
+	logger, _ := logging.New(&out, logging.FormatJSON, slog.LevelInfo)
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+
+	registry := prometheus.NewRegistry()
+	router := http.NewServeMux()
+	router.HandleFunc("GET /tasks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		var claims map[string]any
+		_ = claims["userID"].(string)
+	})
+	router.HandleFunc("GET /stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: 1\n\n"))
+		panic("stream broke")
+	})
+	router.HandleFunc("GET /abort", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	v1 := http.NewServeMux()
+	v1.Handle("/api/v1/", http.StripPrefix("/api/v1", Routes("/api/v1", router)))
+	handler := ChainMiddlewares(v1, Recover(registry), Tracer)
+
+	t.Run("Answers with a 500 problem", func(t *testing.T) {
+		out.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/tasks/1", nil)
+		req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		var problem utils.Problem
+		json.NewDecoder(rec.Body).Decode(&problem)
+		if rec.Code != http.StatusInternalServerError || rec.Header().Get("Content-Type") != "application/problem+json" || problem.Status != http.StatusInternalServerError {
+			t.Errorf("Expected a 500 problem, got %d %v %+v", rec.Code, rec.Header(), problem)
+		}
+		if rec.Header().Get("Content-Length") != "" {
+			t.Error("Expected the handler's Content-Length to be dropped")
+		}
+
+		var line map[string]any
+		if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+			t.Fatalf("Expected one JSON line, got %q", out.String())
+		}
+		if line["level"] != "ERROR" || line["route"] != "GET /api/v1/tasks/{id}" || line["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("Unexpected log line %v", line)
+		}
+		if stack, _ := line["stack"].(string); !strings.Contains(stack, "recover_test.go") || !strings.Contains(line["panic"].(string), "interface conversion") {
+			t.Errorf("Expected the panic and its stack, got %v", line)
+		}
+	})
+
+	t.Run("Aborts responses that already started", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Errorf("Expected the response to be aborted, got %v", v)
+			}
+			if rec.Code != http.StatusOK || rec.Body.String() != "data: 1\n\n" {
+				t.Errorf("Expected no second header or body, got %d %q", rec.Code, rec.Body)
+			}
+		}()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/stream", nil))
+	})
+
+	t.Run("Logs the stack of panics passed on by Limit", func(t *testing.T) {
+		out.Reset()
+		handler := ChainMiddlewares(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}), Recover(prometheus.NewRegistry()), Limit(NewRouteLimits(Limits{Timeout: time.Minute})))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		var line map[string]any
+		json.Unmarshal(out.Bytes(), &line)
+		if stack, _ := line["stack"].(string); rec.Code != http.StatusInternalServerError || line["panic"] != "boom" || !strings.Contains(stack, "recover_test.go") {
+			t.Errorf("Expected a 500 and the handler's stack, got %d %v", rec.Code, line)
+		}
+	})
+
+	t.Run("Lets aborts through", func(t *testing.T) {
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Errorf("Expected the abort to go through, got %v", v)
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/abort", nil))
+	})
+
+	expected := `
+# HELP http_panics_total Panics recovered from HTTP handlers.
+# TYPE http_panics_total counter
+http_panics_total{route="GET /api/v1/stream"} 1
+http_panics_total{route="GET /api/v1/tasks/{id}"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "http_panics_total"); err != nil {
+		t.Error(err)
+	}
+}
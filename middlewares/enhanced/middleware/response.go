@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ResponseRecorder records what a handler wrote through the ResponseWriter
+// returned by WrapResponseWriter.
+type ResponseRecorder struct {
+	w         http.ResponseWriter
+	start     time.Time
+	status    int
+	bytes     int64
+	firstByte time.Duration
+	hijacked  bool
+}
+
+// WrapResponseWriter returns a ResponseWriter recording the status, size
+// and time to first byte of the response into the returned recorder.
+//
+// The wrapper implements http.Flusher, http.Hijacker and io.ReaderFrom
+// exactly when w does, so handlers checking for them, SSE and WebSocket
+// upgrades included, behave as if unwrapped. It also implements Unwrap
+// for http.ResponseController.
+func WrapResponseWriter(w http.ResponseWriter) (http.ResponseWriter, *ResponseRecorder) {
+	rec := &ResponseRecorder{w: w, start: time.Now()}
+
+	f, isFlusher := w.(http.Flusher)
+	h, isHijacker := w.(http.Hijacker)
+	rf, isReaderFrom := w.(io.ReaderFrom)
+	fl, hj, rd := flusher{rec, f}, hijacker{rec, h}, readerFrom{rec, rf}
+
+	switch {
+	case isFlusher && isHijacker && isReaderFrom:
+		return struct {
+			*ResponseRecorder
+			flusher
+			hijacker
+			readerFrom
+		}{rec, fl, hj, rd}, rec
+	case isFlusher && isHijacker:
+		return struct {
+			*ResponseRecorder
+			flusher
+			hijacker
+		}{rec, fl, hj}, rec
+	case isFlusher && isReaderFrom:
+		return struct {
+			*ResponseRecorder
+			flusher
+			readerFrom
+		}{rec, fl, rd}, rec
+	case isHijacker && isReaderFrom:
+		return struct {
+			*ResponseRecorder
+			hijacker
+			readerFrom
+		}{rec, hj, rd}, rec
+	case isFlusher:
+		return struct {
+			*ResponseRecorder
+			flusher
+		}{rec, fl}, rec
+	case isHijacker:
+		return struct {
+			*ResponseRecorder
+			hijacker
+		}{rec, hj}, rec
+	case isReaderFrom:
+		return struct {
+			*ResponseRecorder
+			readerFrom
+		}{rec, rd}, rec
+	}
+	return rec, rec
+}
+
+func (rec *ResponseRecorder) Header() http.Header {
+	return rec.w.Header()
+}
+
+func (rec *ResponseRecorder) WriteHeader(status int) {
+	// 1xx responses other than 101 are informational, the final status
+	// follows them.
+	if rec.status == 0 && (status >= 200 || status == http.StatusSwitchingProtocols) {
+		rec.status = status
+		rec.firstByte = time.Since(rec.start)
+	}
+	rec.w.WriteHeader(status)
+}
+
+func (rec *ResponseRecorder) Write(b []byte) (int, error) {
+	rec.implicitHeader()
+	n, err := rec.w.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap returns the wrapped ResponseWriter, for http.ResponseController.
+func (rec *ResponseRecorder) Unwrap() http.ResponseWriter {
+	return rec.w
+}
+
+// implicitHeader records the 200 net/http sends when the body is written
+// before the header.
+func (rec *ResponseRecorder) implicitHeader() {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+		rec.firstByte = time.Since(rec.start)
+	}
+}
+
+// Status returns the status sent, 200 when the handler didn't write yet
+// as that's what net/http will send, and 101 after a hijack.
+func (rec *ResponseRecorder) Status() int {
+	switch {
+	case rec.status != 0:
+		return rec.status
+	case rec.hijacked:
+		return http.StatusSwitchingProtocols
+	}
+	return http.StatusOK
+}
+
+// Written reports whether the response header was sent, after which the
+// status can't change anymore.
+func (rec *ResponseRecorder) Written() bool {
+	return rec.status != 0 || rec.hijacked
+}
+
+// Bytes returns the size of the response body written so far.
+func (rec *ResponseRecorder) Bytes() int64 {
+	return rec.bytes
+}
+
+// TimeToFirstByte returns how long the handler took to send the header,
+// zero while it hasn't.
+func (rec *ResponseRecorder) TimeToFirstByte() time.Duration {
+	return rec.firstByte
+}
+
+type flusher struct {
+	rec *ResponseRecorder
+	f   http.Flusher
+}
+
+func (f flusher) Flush() {
+	f.rec.implicitHeader()
+	f.f.Flush()
+}
+
+type hijacker struct {
+	rec *ResponseRecorder
+	h   http.Hijacker
+}
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := h.h.Hijack()
+	if err == nil {
+		h.rec.hijacked = true
+	}
+	return conn, rw, err
+}
+
+type readerFrom struct {
+	rec *ResponseRecorder
+	rf  io.ReaderFrom
+}
+
+func (rf readerFrom) ReadFrom(src io.Reader) (int64, error) {
+	rf.rec.implicitHeader()
+	n, err := rf.rf.ReadFrom(src)
+	rf.rec.bytes += n
+	return n, err
+}
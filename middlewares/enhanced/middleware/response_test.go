@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// hijackableRecorder is a ResponseRecorder over a connection that can be
+// taken over, like the ResponseWriter of an HTTP/1 server.
+type hijackableRecorder struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (h hijackableRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.conn, bufio.NewReadWriter(bufio.NewReader(h.conn), bufio.NewWriter(h.conn)), nil
+}
+
+// plainWriter only has the ResponseWriter methods.
+type plainWriter struct {
+	http.ResponseWriter
+}
+
+// readerFromRecorder implements io.ReaderFrom, like the ResponseWriter of
+// an HTTP/1 server does to send files with sendfile.
+type readerFromRecorder struct {
+	*httptest.ResponseRecorder
+	readFrom bool
+}
+
+func (r *readerFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.readFrom = true
+	return io.Copy(r.ResponseRecorder, src)
+}
+
+func TestWrapResponseWriter(t *testing.T) {
+	t.Run("Records the status, size and time to first byte", func(t *testing.T) {
+		w, rec := WrapResponseWriter(httptest.NewRecorder())
+		if rec.Written() || rec.Status() != http.StatusOK || rec.TimeToFirstByte() != 0 {
+			t.Errorf("Unexpected recorder before writing %+v", rec)
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "not found")
+		if rec.Status() != http.StatusNotFound || rec.Bytes() != 9 || !rec.Written() || rec.TimeToFirstByte() <= 0 {
+			t.Errorf("Unexpected recorder %+v", rec)
+		}
+	})
+
+	t.Run("Informational responses aren't the status", func(t *testing.T) {
+		w, rec := WrapResponseWriter(httptest.NewRecorder())
+		w.WriteHeader(http.StatusEarlyHints)
+		if rec.Written() || rec.Status() != http.StatusOK {
+			t.Errorf("Unexpected recorder %+v", rec)
+		}
+	})
+
+	t.Run("A body without header is a 200", func(t *testing.T) {
+		w, rec := WrapResponseWriter(httptest.NewRecorder())
+		io.WriteString(w, "ok")
+		if rec.Status() != http.StatusOK || !rec.Written() || rec.Bytes() != 2 {
+			t.Errorf("Unexpected recorder %+v", rec)
+		}
+	})
+
+	t.Run("Keeps exactly the optional interfaces of the wrapped writer", func(t *testing.T) {
+		for _, test := range []struct {
+			name                          string
+			w                             http.ResponseWriter
+			flusher, hijacker, readerFrom bool
+		}{
+			{"plain", plainWriter{httptest.NewRecorder()}, false, false, false},
+			{"flusher", httptest.NewRecorder(), true, false, false},
+			{"hijacker", hijackableRecorder{ResponseRecorder: httptest.NewRecorder()}, true, true, false},
+			{"reader from", &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}, true, false, true},
+		} {
+			w, _ := WrapResponseWriter(test.w)
+			_, flusher := w.(http.Flusher)
+			_, hijacker := w.(http.Hijacker)
+			_, readerFrom := w.(io.ReaderFrom)
+			if flusher != test.flusher || hijacker != test.hijacker || readerFrom != test.readerFrom {
+				t.Errorf("%s: got Flusher %v, Hijacker %v, ReaderFrom %v", test.name, flusher, hijacker, readerFrom)
+			}
+			if _, ok := w.(interface{ Unwrap() http.ResponseWriter }); !ok {
+				t.Errorf("%s: expected Unwrap", test.name)
+			}
+		}
+	})
+
+	t.Run("Flushes", func(t *testing.T) {
+		underlying := httptest.NewRecorder()
+		w, rec := WrapResponseWriter(underlying)
+		w.(http.Flusher).Flush()
+		if !underlying.Flushed || rec.Status() != http.StatusOK || !rec.Written() {
+			t.Errorf("Expected the flush to go through and send the header, got %+v", rec)
+		}
+
+		w, _ = WrapResponseWriter(plainWriter{underlying})
+		if err := http.NewResponseController(w).Flush(); err == nil {
+			t.Error("Expected writers that can't flush not to pretend they can")
+		}
+	})
+
+	t.Run("Hijacks", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer client.Close()
+		w, rec := WrapResponseWriter(hijackableRecorder{httptest.NewRecorder(), server})
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil || conn != server {
+			t.Fatalf("Expected the connection, got %v %v", conn, err)
+		}
+		if rec.Status() != http.StatusSwitchingProtocols || !rec.Written() {
+			t.Errorf("Expected a hijacked response to count as switching protocols, got %d", rec.Status())
+		}
+	})
+
+	t.Run("Counts bytes sent with ReadFrom", func(t *testing.T) {
+		underlying := &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+		w, rec := WrapResponseWriter(underlying)
+		// Hide WriterTo, io.Copy would prefer it.
+		io.Copy(w, struct{ io.Reader }{strings.NewReader("a file")})
+		if !underlying.readFrom || rec.Bytes() != 6 || rec.Status() != http.StatusOK {
+			t.Errorf("Expected the copy to use ReadFrom and be recorded, got %+v", rec)
+		}
+	})
+}
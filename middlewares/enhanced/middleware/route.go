@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// routeKey holds an *atomic.Value the matched route is written to.
+// Middleware wrapping the server sees the request before any ServeMux
+// does, and muxes mounted with http.StripPrefix route a copy of it, so the
+// route has to be reported back through the context. It's atomic as a
+// handler that timed out (see Limit) may still be routing while the
+// request is logged.
+var routeKey = &contextKey{"route"}
+
+// WithRoute returns r with a place for Routes to record the route in,
+// unless an outer middleware already made one. Middleware reading Route
+// after serving the request calls it first.
+func WithRoute(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(routeKey).(*atomic.Value); ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), routeKey, new(atomic.Value)))
+}
+
+// Route returns the pattern r was routed to, as recorded by Routes, or else
+// the pattern of the ServeMux that served r. It is empty before routing
+// and for requests no pattern matched.
+func Route(r *http.Request) string {
+	if holder, ok := r.Context().Value(routeKey).(*atomic.Value); ok {
+		if route, _ := holder.Load().(string); route != "" {
+			return route
+		}
+	}
+	return r.Pattern
+}
+
+// Routes serves mux, mounted below prefix with http.StripPrefix, and
+// records the matched pattern for Route with the prefix put back:
+// "GET /users/me" below "/api/v1" is recorded as "GET /api/v1/users/me".
+func Routes(prefix string, mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if holder, ok := r.Context().Value(routeKey).(*atomic.Value); ok {
+			if _, pattern := mux.Handler(r); pattern != "" {
+				holder.Store(joinPattern(prefix, pattern))
+			}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func joinPattern(prefix, pattern string) string {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		return prefix + pattern
+	}
+	return method + " " + prefix + path
+}
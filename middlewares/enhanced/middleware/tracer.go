@@ -1,24 +1,160 @@
 package middleware
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"net/http"
+	"strings"
+)
 
-	"github.com/google/uuid"
+// W3C Trace Context headers, see https://www.w3.org/TR/trace-context/.
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
 )
 
+// FlagSampled is the trace-flags bit telling downstream services the
+// caller records this trace.
+const FlagSampled byte = 0x01
+
+// maxTracestateLen is the length vendors must propagate at least; longer
+// values may be dropped.
+const maxTracestateLen = 512
+
+// TraceContext identifies the span serving a request within its trace.
+// ParentID is the caller's span, zero when the trace started here.
+type TraceContext struct {
+	TraceID  [16]byte
+	SpanID   [8]byte
+	ParentID [8]byte
+	Flags    byte
+	State    string
+}
+
+var traceContextKey = &contextKey{"trace-context"}
+
+func (tc TraceContext) TraceIDString() string {
+	return hex.EncodeToString(tc.TraceID[:])
+}
+
+func (tc TraceContext) SpanIDString() string {
+	return hex.EncodeToString(tc.SpanID[:])
+}
+
+func (tc TraceContext) Sampled() bool {
+	return tc.Flags&FlagSampled != 0
+}
+
+// Traceparent formats the span as a version 00 traceparent header value.
+func (tc TraceContext) Traceparent() string {
+	return "00-" + tc.TraceIDString() + "-" + tc.SpanIDString() + "-" + hex.EncodeToString([]byte{tc.Flags})
+}
+
+// Inject sets the headers that continue the trace in a request to another
+// service, with this span as the parent.
+func (tc TraceContext) Inject(h http.Header) {
+	h.Set(TraceparentHeader, tc.Traceparent())
+	if tc.State != "" {
+		h.Set(TracestateHeader, tc.State)
+	} else {
+		h.Del(TracestateHeader)
+	}
+}
+
+// ParseTraceparent parses a traceparent header value. Versions above 00
+// are parsed as 00, ignoring what they append, as the spec asks.
+func ParseTraceparent(s string) (TraceContext, bool) {
+	var tc TraceContext
+	s = strings.TrimSpace(s)
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return tc, false
+	}
+
+	version, ok := decodeHex(s[:2], 1)
+	if !ok || version[0] == 0xff || (version[0] == 0 && len(s) != 55) || (len(s) > 55 && s[55] != '-') {
+		return tc, false
+	}
+	traceID, ok := decodeHex(s[3:35], 16)
+	if !ok || isZero(traceID) {
+		return tc, false
+	}
+	parentID, ok := decodeHex(s[36:52], 8)
+	if !ok || isZero(parentID) {
+		return tc, false
+	}
+	flags, ok := decodeHex(s[53:55], 1)
+	if !ok {
+		return tc, false
+	}
+
+	copy(tc.TraceID[:], traceID)
+	copy(tc.SpanID[:], parentID)
+	tc.Flags = flags[0]
+	return tc, true
+}
+
+// decodeHex decodes exactly n bytes of lowercase hex.
+func decodeHex(s string, n int) ([]byte, bool) {
+	if len(s) != 2*n || strings.ToLower(s) != s {
+		return nil, false
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// newTraceContext continues the trace of the incoming request, or starts a
+// sampled one when the request has no valid traceparent. Either way the
+// request gets a span ID of its own.
+func newTraceContext(r *http.Request) TraceContext {
+	tc, ok := ParseTraceparent(r.Header.Get(TraceparentHeader))
+	if ok {
+		tc.ParentID = tc.SpanID
+		// tracestate is only meaningful with the traceparent it came with.
+		if state := strings.TrimSpace(strings.Join(r.Header.Values(TracestateHeader), ",")); len(state) <= maxTracestateLen {
+			tc.State = state
+		}
+	} else {
+		rand.Read(tc.TraceID[:])
+		tc.Flags = FlagSampled
+	}
+	rand.Read(tc.SpanID[:])
+	return tc
+}
+
+// ContextWithTrace returns a copy of ctx carrying tc.
+func ContextWithTrace(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey, tc)
+}
+
+// TraceFromContext returns the trace context stored by Tracer.
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey).(TraceContext)
+	return tc, ok
+}
+
 // Return trace ID and span ID in the following format "[" + traceID + " : " + spanID + "] "
 func FormatTracing(r *http.Request) string {
-	traceID := r.Header.Get("X-Trace-ID")
-	spanID := r.Header.Get("X-Span-ID")
-	return "[" + traceID + " : " + spanID + "] "
+	tc, _ := TraceFromContext(r.Context())
+	return "[" + tc.TraceIDString() + " : " + tc.SpanIDString() + "] "
 }
 
+// Tracer continues the caller's W3C trace, or starts one, and stores the
+// trace context of the request in its context. The response carries the
+// traceparent of the span that served it.
 func Tracer(handler http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		traceID := uuid.NewString()
-		r.Header.Set("X-Trace-ID", traceID)
-		spanID := uuid.NewString()
-		r.Header.Set("X-Span-ID", spanID)
-		handler.ServeHTTP(w, r)
+		tc := newTraceContext(r)
+		tc.Inject(w.Header())
+		handler.ServeHTTP(w, r.WithContext(ContextWithTrace(r.Context(), tc)))
 	}
 }
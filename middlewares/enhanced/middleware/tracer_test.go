@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tc, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok {
+		t.Fatal("Expected a valid traceparent")
+	}
+	if tc.TraceIDString() != "4bf92f3577b34da6a3ce929d0e0e4736" || tc.SpanIDString() != "00f067aa0ba902b7" || !tc.Sampled() {
+		t.Errorf("Unexpected trace context %+v", tc)
+	}
+
+	if _, ok := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future"); !ok {
+		t.Error("Expected a later version to be parsed as version 00")
+	}
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-600f067aa0ba902b7-01",
+	} {
+		if _, ok := ParseTraceparent(invalid); ok {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestTracer(t *testing.T) {
+	var got TraceContext
+	handler := Tracer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = TraceFromContext(r.Context())
+	}))
+
+	t.Run("Continues the caller's trace", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+		req.Header.Set(TracestateHeader, "congo=t61rcWkgMzE")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if got.TraceIDString() != "4bf92f3577b34da6a3ce929d0e0e4736" || got.Sampled() {
+			t.Errorf("Expected the trace ID and flags to be kept, got %+v", got)
+		}
+		if got.SpanIDString() == "00f067aa0ba902b7" || got.ParentID != [8]byte{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7} {
+			t.Errorf("Expected a child span of the caller's, got %+v", got)
+		}
+		if rec.Header().Get(TraceparentHeader) != got.Traceparent() || rec.Header().Get(TracestateHeader) != "congo=t61rcWkgMzE" {
+			t.Errorf("Expected the response to carry the trace context, got %v", rec.Header())
+		}
+		if req.Header.Get(TraceparentHeader) != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00" {
+			t.Error("Expected the request headers to be left alone")
+		}
+	})
+
+	t.Run("Starts a trace without a valid traceparent", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(TraceparentHeader, "garbage")
+		req.Header.Set(TracestateHeader, "congo=t61rcWkgMzE")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if got.ParentID != [8]byte{} || !got.Sampled() || got.State != "" {
+			t.Errorf("Expected a new sampled trace, got %+v", got)
+		}
+		if !strings.HasPrefix(rec.Header().Get(TraceparentHeader), "00-"+got.TraceIDString()) {
+			t.Errorf("Unexpected traceparent %q", rec.Header().Get(TraceparentHeader))
+		}
+	})
+}
@@ -0,0 +1,109 @@
+// Package telemetry sets up the OpenTelemetry SDK for the services.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Config of the span pipeline. Endpoint is the host:port of an OTLP/HTTP
+// receiver such as Tempo; without it spans are sampled but not exported.
+type Config struct {
+	ServiceName string
+	Endpoint    string
+	Insecure    bool
+	// SampleRatio of new traces to record, 0 to 1. Traces started by a
+	// caller follow the caller's decision.
+	SampleRatio float64
+	// Spans are exported in batches of up to MaxBatchSize, at least every
+	// BatchTimeout.
+	BatchTimeout time.Duration
+	MaxBatchSize int
+}
+
+// ConfigFromEnv reads the config from OTEL_* variables, with defaults for
+// the Tempo container in monitoring/compose.yaml.
+func ConfigFromEnv(serviceName string) (Config, error) {
+	c := Config{
+		ServiceName:  getEnv("OTEL_SERVICE_NAME", serviceName),
+		Endpoint:     getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "127.0.0.1:4318"),
+		Insecure:     getEnv("OTEL_EXPORTER_OTLP_INSECURE", "true") == "true",
+		SampleRatio:  1,
+		BatchTimeout: 5 * time.Second,
+		MaxBatchSize: 512,
+	}
+
+	var err error
+	if v, ok := os.LookupEnv("OTEL_TRACES_SAMPLER_ARG"); ok {
+		if c.SampleRatio, err = strconv.ParseFloat(v, 64); err != nil || c.SampleRatio < 0 || c.SampleRatio > 1 {
+			return c, fmt.Errorf("OTEL_TRACES_SAMPLER_ARG must be a ratio between 0 and 1: %q", v)
+		}
+	}
+	if v, ok := os.LookupEnv("OTEL_BSP_SCHEDULE_DELAY"); ok {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms <= 0 {
+			return c, fmt.Errorf("OTEL_BSP_SCHEDULE_DELAY must be milliseconds: %q", v)
+		}
+		c.BatchTimeout = time.Duration(ms) * time.Millisecond
+	}
+	if v, ok := os.LookupEnv("OTEL_BSP_MAX_EXPORT_BATCH_SIZE"); ok {
+		if c.MaxBatchSize, err = strconv.Atoi(v); err != nil || c.MaxBatchSize <= 0 {
+			return c, fmt.Errorf("OTEL_BSP_MAX_EXPORT_BATCH_SIZE must be a positive integer: %q", v)
+		}
+	}
+	return c, nil
+}
+
+// NewTracerProvider returns a provider exporting to c.Endpoint over OTLP,
+// and installs it and the W3C propagators globally. Shut it down to flush
+// the last batch.
+func NewTracerProvider(ctx context.Context, c Config) (*sdktrace.TracerProvider, error) {
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(c.ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.SampleRatio))),
+	}
+	if c.Endpoint != "" {
+		exporterOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(c.Endpoint)}
+		if c.Insecure {
+			exporterOpts = append(exporterOpts, otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(ctx, exporterOpts...)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter,
+			sdktrace.WithBatchTimeout(c.BatchTimeout),
+			sdktrace.WithMaxExportBatchSize(c.MaxBatchSize),
+		))
+	}
+
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return tp, nil
+}
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
@@ -5,22 +5,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
-)
-
-type statusRecorder struct {
-	http.ResponseWriter
-	status int
-}
 
-func (rec *statusRecorder) WriteHeader(status int) {
-	rec.status = status
-	rec.ResponseWriter.WriteHeader(status)
-}
+	"github.com/ZiadMansourM/middleware/logging"
+	"github.com/ZiadMansourM/middleware/middleware"
+)
 
 func WriteJson(w http.ResponseWriter, status int, data any) {
 	w.Header().Set("Content-Type", "application/json")
@@ -34,12 +28,16 @@ type Logger struct {
 
 func (l *Logger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	rec := &statusRecorder{
-		ResponseWriter: w,
-		status:         http.StatusOK,
-	}
-	l.handler.ServeHTTP(rec, r)
-	log.Printf("%s %s %d %v", r.Method, r.URL.Path, rec.status, time.Since(start))
+	w, rec := middleware.WrapResponseWriter(w)
+	r = middleware.WithRoute(r)
+	l.handler.ServeHTTP(w, r)
+	logging.LogRequest(r.Context(), slog.Default(), logging.Request{
+		Method:   r.Method,
+		Route:    middleware.Route(r),
+		Status:   rec.Status(),
+		Duration: time.Since(start),
+		Bytes:    rec.Bytes(),
+	})
 }
 
 func NewLogger(handlerToWrap http.Handler) *Logger {
@@ -57,6 +55,12 @@ func main() {
 		cancel()
 	}()
 
+	logger, err := logging.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid logging config: %v\n", err)
+	}
+	slog.SetDefault(logger)
+
 	router := http.NewServeMux()
 
 	router.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
@@ -64,7 +68,7 @@ func main() {
 	})
 
 	v1 := http.NewServeMux()
-	v1.Handle("/api/v1/", http.StripPrefix("/api/v1", router))
+	v1.Handle("/api/v1/", http.StripPrefix("/api/v1", middleware.Routes("/api/v1", router)))
 
 	const addr = "127.0.0.1:8080"
 
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scopes of personal access tokens. Sessions are not scoped.
+const (
+	ScopeTasksRead     = "tasks:read"
+	ScopeTasksWrite    = "tasks:write"
+	ScopeProjectsAdmin = "projects:admin"
+)
+
+var accessTokenScopes = []string{ScopeTasksRead, ScopeTasksWrite, ScopeProjectsAdmin}
+
+// accessTokenPrefix tells personal access tokens apart from JWTs, and makes
+// them easy to find with secret scanners.
+const accessTokenPrefix = "pm_"
+
+const (
+	defaultAccessTokenDays = 30
+	maxAccessTokenDays     = 365
+	// lastUsedPrecision limits LastUsedAt writes to one a minute per token.
+	lastUsedPrecision = time.Minute
+)
+
+// routeScopes is the scope a personal access token needs for each API route,
+// by the pattern the route is registered with. "" lets any token through.
+// Routes that aren't listed, like managing tokens, need a session.
+var routeScopes = map[string]string{
+	"GET /health":               "",
+	"GET /users/me":             "",
+	"GET /projects":             ScopeTasksRead,
+	"GET /projects/{id}":        ScopeTasksRead,
+	"GET /projects/{id}/tasks":  ScopeTasksRead,
+	"GET /projects/{id}/events": ScopeTasksRead,
+	"GET /tasks/{id}":           ScopeTasksRead,
+	"POST /tasks":               ScopeTasksWrite,
+	"PATCH /tasks/{id}":         ScopeTasksWrite,
+	"POST /projects":            ScopeProjectsAdmin,
+}
+
+// scopeRouter matches requests to the patterns in routeScopes the same way
+// the API routers do.
+var scopeRouter = func() *http.ServeMux {
+	mux := http.NewServeMux()
+	for pattern := range routeScopes {
+		mux.Handle(pattern, http.NotFoundHandler())
+	}
+	return mux
+}()
+
+// AccessToken is a personal access token. Like UserToken only its SHA-256
+// is stored, the token itself is shown once when it's created.
+type AccessToken struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"-"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	Hash       string     `json:"-"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// HasScope reports whether the token grants scope. Writing tasks implies
+// reading them.
+func (t *AccessToken) HasScope(scope string) bool {
+	if scope == ScopeTasksRead && slices.Contains(t.Scopes, ScopeTasksWrite) {
+		return true
+	}
+	return slices.Contains(t.Scopes, scope)
+}
+
+// requiredScope returns the scope needed for an API request, route being
+// the path below the version prefix. ok is false when tokens can't be used.
+func requiredScope(r *http.Request, route string) (scope string, ok bool) {
+	probe := r.Clone(r.Context())
+	probe.URL.Path = "/" + route
+	_, pattern := scopeRouter.Handler(probe)
+	scope, ok = routeScopes[pattern]
+	return scope, ok
+}
+
+func newAccessToken(userID int64, name string, scopes []string, ttl time.Duration) (string, *AccessToken, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	raw := accessTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	return raw, &AccessToken{
+		UserID:    userID,
+		Name:      name,
+		Scopes:    scopes,
+		Hash:      hashUserToken(raw),
+		ExpiresAt: time.Now().Add(ttl).Truncate(time.Second),
+	}, nil
+}
+
+var errInvalidAccessToken = errors.New("invalid or expired access token")
+
+// authenticateAccessToken looks up a personal access token and records its
+// use. Tokens created before the user's sessions were revoked stop working
+// too, so a password reset also locks out tokens made by an intruder.
+func authenticateAccessToken(store Store, raw string) (*AccessToken, *User, error) {
+	t, err := store.GetAccessToken(hashUserToken(raw))
+	if err != nil {
+		return nil, nil, errInvalidAccessToken
+	}
+	now := time.Now()
+	if !now.Before(t.ExpiresAt) {
+		return nil, nil, errInvalidAccessToken
+	}
+
+	user, err := store.GetUserByID(strconv.FormatInt(t.UserID, 10))
+	if err != nil {
+		return nil, nil, errInvalidAccessToken
+	}
+	if user.SessionsRevokedAt != nil && t.CreatedAt.Before(*user.SessionsRevokedAt) {
+		return nil, nil, errInvalidAccessToken
+	}
+
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) >= lastUsedPrecision {
+		if err := store.TouchAccessToken(t.ID, now); err != nil {
+			return nil, nil, err
+		}
+	}
+	return t, user, nil
+}
+
+type AccessTokenService struct {
+	store Store
+}
+
+type createAccessTokenPayload struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"`
+}
+
+// createdAccessToken is the only response that includes the token.
+type createdAccessToken struct {
+	*AccessToken
+	Token string `json:"token"`
+}
+
+func NewAccessTokenService(store Store) *AccessTokenService {
+	return &AccessTokenService{store: store}
+}
+
+func (s *AccessTokenService) RegisterRoutes(r *http.ServeMux) {
+	r.HandleFunc("POST /users/me/tokens", s.handleCreateAccessToken)
+	r.HandleFunc("GET /users/me/tokens", s.handleListAccessTokens)
+	r.HandleFunc("DELETE /users/me/tokens/{id}", s.handleRevokeAccessToken)
+}
+
+func (p *createAccessTokenPayload) validate() error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(p.Name) > 100 {
+		return errors.New("name must be at most 100 characters")
+	}
+	if len(p.Scopes) == 0 {
+		return fmt.Errorf("at least one scope is required, one of %s", strings.Join(accessTokenScopes, ", "))
+	}
+	for _, scope := range p.Scopes {
+		if !slices.Contains(accessTokenScopes, scope) {
+			return fmt.Errorf("unknown scope %q, expected one of %s", scope, strings.Join(accessTokenScopes, ", "))
+		}
+	}
+	if p.ExpiresInDays == 0 {
+		p.ExpiresInDays = defaultAccessTokenDays
+	}
+	if p.ExpiresInDays < 0 || p.ExpiresInDays > maxAccessTokenDays {
+		return fmt.Errorf("expiresInDays must be between 1 and %d", maxAccessTokenDays)
+	}
+	return nil
+}
+
+func (s *AccessTokenService) handleCreateAccessToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var payload createAccessTokenPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid Request Payload: " + err.Error(),
+		})
+		return
+	}
+	if err := payload.validate(); err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid Request Payload: " + err.Error(),
+		})
+		return
+	}
+
+	slices.Sort(payload.Scopes)
+	raw, token, err := newAccessToken(userID, payload.Name, slices.Compact(payload.Scopes), time.Duration(payload.ExpiresInDays)*24*time.Hour)
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error creating token: " + err.Error(),
+		})
+		return
+	}
+	if err := s.store.CreateAccessToken(token); err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error creating token: " + err.Error(),
+		})
+		return
+	}
+
+	WriteJson(w, http.StatusCreated, createdAccessToken{AccessToken: token, Token: raw})
+}
+
+func (s *AccessTokenService) handleListAccessTokens(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	tokens, err := s.store.ListAccessTokens(userID)
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error listing tokens: " + err.Error(),
+		})
+		return
+	}
+	if tokens == nil {
+		tokens = []*AccessToken{}
+	}
+
+	WriteJson(w, http.StatusOK, tokens)
+}
+
+func (s *AccessTokenService) handleRevokeAccessToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid token ID",
+		})
+		return
+	}
+
+	err = s.store.DeleteAccessToken(userID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		WriteJson(w, http.StatusNotFound, ErrorResponse{
+			Error: "Token not found",
+		})
+		return
+	}
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error revoking token: " + err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// currentUserID returns the ID of the user authenticated by
+// RequireAuthMiddleware.
+func currentUserID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	info := authInfoFromContext(r.Context())
+	if info == nil {
+		WriteJson(w, http.StatusUnauthorized, ErrorResponse{
+			Error: "Unauthorized",
+		})
+		return 0, false
+	}
+
+	id, err := strconv.ParseInt(info.UserID, 10, 64)
+	if err != nil {
+		WriteJson(w, http.StatusUnauthorized, ErrorResponse{
+			Error: "Unauthorized: invalid user",
+		})
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPersonalAccessTokens(t *testing.T) {
+	store := newMemoryStore()
+	hashed, _ := HashPassword("a long enough passphrase")
+	ada, _ := store.CreateUser(&User{Email: "ada@example.com", Password: hashed})
+	grace, _ := store.CreateUser(&User{Email: "grace@example.com", Password: hashed})
+
+	api, _, err := NewAPIServer("", store).Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(method, path, token string, body any) *httptest.ResponseRecorder {
+		var b []byte
+		if body != nil {
+			b, _ = json.Marshal(body)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec
+	}
+	session, _ := CreateJWT(ada.ID, []byte(Envs.JWTSecret))
+	create := func(scopes ...string) createdAccessToken {
+		t.Helper()
+		rec := call(http.MethodPost, "/api/v1/users/me/tokens", session, createAccessTokenPayload{Name: "ci", Scopes: scopes})
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body)
+		}
+		var created createdAccessToken
+		json.NewDecoder(rec.Body).Decode(&created)
+		return created
+	}
+
+	t.Run("Rejects unknown scopes", func(t *testing.T) {
+		rec := call(http.MethodPost, "/api/v1/users/me/tokens", session, createAccessTokenPayload{Name: "ci", Scopes: []string{"admin"}})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rec.Code)
+		}
+	})
+
+	reader := create(ScopeTasksRead)
+	writer := create(ScopeTasksWrite)
+
+	t.Run("Shows the token once and stores its hash", func(t *testing.T) {
+		if !strings.HasPrefix(reader.Token, accessTokenPrefix) {
+			t.Errorf("Expected a %s token, got %q", accessTokenPrefix, reader.Token)
+		}
+		if stored := store.accessTokens[0]; stored.Hash == reader.Token || stored.Hash != hashUserToken(reader.Token) {
+			t.Error("Expected only the hash to be stored")
+		}
+		if d := time.Until(reader.ExpiresAt); d < 29*24*time.Hour || d > 31*24*time.Hour {
+			t.Errorf("Expected the default 30 day expiry, got %v", reader.ExpiresAt)
+		}
+	})
+
+	for name, tc := range map[string]struct {
+		method, path string
+		token        string
+		body         any
+		status       int
+	}{
+		"read token lists projects":         {http.MethodGet, "/api/v1/projects", reader.Token, nil, http.StatusOK},
+		"read token works on v2":            {http.MethodGet, "/api/v2/projects", reader.Token, nil, http.StatusOK},
+		"read token can't move tasks":       {http.MethodPatch, "/api/v1/tasks/1", reader.Token, map[string]string{"status": "DONE"}, http.StatusForbidden},
+		"write token creates tasks":         {http.MethodPost, "/api/v1/tasks", writer.Token, Task{Name: "Write docs", ProjectID: 1, AssignedToID: 1}, http.StatusCreated},
+		"write token implies read":          {http.MethodGet, "/api/v1/tasks/1", writer.Token, nil, http.StatusOK},
+		"write token can't create projects": {http.MethodPost, "/api/v1/projects", writer.Token, Project{Name: "Ops"}, http.StatusForbidden},
+		"tokens can't mint tokens":          {http.MethodPost, "/api/v1/users/me/tokens", writer.Token, createAccessTokenPayload{Name: "x", Scopes: []string{ScopeProjectsAdmin}}, http.StatusForbidden},
+		"tokens can read the user":          {http.MethodGet, "/api/v1/users/me", reader.Token, nil, http.StatusOK},
+		"unknown tokens are rejected":       {http.MethodGet, "/api/v1/projects", accessTokenPrefix + "nope", nil, http.StatusUnauthorized},
+	} {
+		t.Run(strings.ToUpper(name[:1])+name[1:], func(t *testing.T) {
+			rec := call(tc.method, tc.path, tc.token, tc.body)
+			if rec.Code != tc.status {
+				t.Errorf("Expected status code %d, got %d: %s", tc.status, rec.Code, rec.Body)
+			}
+		})
+	}
+
+	t.Run("Lists tokens with their last use", func(t *testing.T) {
+		rec := call(http.MethodGet, "/api/v1/users/me/tokens", session, nil)
+		if strings.Contains(rec.Body.String(), reader.Token) || strings.Contains(rec.Body.String(), "Hash") {
+			t.Errorf("Expected the list not to reveal tokens, got %s", rec.Body)
+		}
+		var tokens []*AccessToken
+		json.NewDecoder(rec.Body).Decode(&tokens)
+		if len(tokens) != 2 || tokens[0].LastUsedAt == nil || tokens[0].Name != "ci" {
+			t.Errorf("Unexpected tokens %+v", tokens)
+		}
+	})
+
+	t.Run("Expired tokens are rejected", func(t *testing.T) {
+		raw, expired, _ := newAccessToken(ada.ID, "old", []string{ScopeTasksRead}, -time.Minute)
+		store.CreateAccessToken(expired)
+		if rec := call(http.MethodGet, "/api/v1/projects", raw, nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+	})
+
+	t.Run("Only the owner can revoke a token", func(t *testing.T) {
+		other, _ := CreateJWT(grace.ID, []byte(Envs.JWTSecret))
+		path := "/api/v1/users/me/tokens/" + strconv.FormatInt(reader.ID, 10)
+		if rec := call(http.MethodDelete, path, other, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status code %d, got %d", http.StatusNotFound, rec.Code)
+		}
+		if rec := call(http.MethodDelete, path, session, nil); rec.Code != http.StatusNoContent {
+			t.Fatalf("Expected status code %d, got %d", http.StatusNoContent, rec.Code)
+		}
+		if rec := call(http.MethodGet, "/api/v1/projects", reader.Token, nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected a revoked token to be rejected, got %d", rec.Code)
+		}
+	})
+
+	t.Run("A password reset revokes older tokens", func(t *testing.T) {
+		store.ResetPassword(ada.ID, hashed, time.Now().Add(time.Second).Truncate(time.Second))
+		if rec := call(http.MethodGet, "/api/v1/projects", writer.Token, nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+	})
+}
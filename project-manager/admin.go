@@ -0,0 +1,124 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Audit log actions.
+const (
+	AuditAccountLocked   = "account.locked"
+	AuditAccountUnlocked = "account.unlocked"
+	AuditIPLocked        = "ip.locked"
+)
+
+// AuditEvent is an entry in the audit log. UserID is the account the event
+// is about, ActorID the admin who caused it, if any.
+type AuditEvent struct {
+	ID        int64     `json:"id"`
+	Action    string    `json:"action"`
+	UserID    *int64    `json:"userId,omitempty"`
+	ActorID   *int64    `json:"actorId,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// AdminService serves the routes reserved to users with RoleAdmin.
+type AdminService struct {
+	store Store
+}
+
+func NewAdminService(store Store) *AdminService {
+	return &AdminService{store: store}
+}
+
+func (s *AdminService) RegisterRoutes(r *http.ServeMux) {
+	r.HandleFunc("POST /admin/users/{id}/unlock", s.requireAdmin(s.handleUnlockUser))
+	r.HandleFunc("GET /admin/audit-log", s.requireAdmin(s.handleListAuditLog))
+}
+
+// requireAdmin answers 403 unless the authenticated user is an admin.
+func (s *AdminService) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := authInfoFromContext(r.Context())
+		if info == nil {
+			WriteJson(w, http.StatusUnauthorized, ErrorResponse{
+				Error: "Unauthorized",
+			})
+			return
+		}
+
+		user, err := s.store.GetUserByID(info.UserID)
+		if err != nil || user.Role != RoleAdmin {
+			WriteJson(w, http.StatusForbidden, ErrorResponse{
+				Error: "Forbidden: admins only",
+			})
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func (s *AdminService) handleUnlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid user ID",
+		})
+		return
+	}
+
+	err = s.store.UnlockUser(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		WriteJson(w, http.StatusNotFound, ErrorResponse{
+			Error: "User not found",
+		})
+		return
+	}
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error unlocking user: " + err.Error(),
+		})
+		return
+	}
+
+	actorID, _ := strconv.ParseInt(authInfoFromContext(r.Context()).UserID, 10, 64)
+	if err := s.store.RecordAudit(&AuditEvent{
+		Action:  AuditAccountUnlocked,
+		UserID:  &userID,
+		ActorID: &actorID,
+		IP:      clientIP(r),
+	}); err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error recording audit event: " + err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *AdminService) handleListAuditLog(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid pagination: " + err.Error(),
+		})
+		return
+	}
+
+	events, err := s.store.ListAuditEvents(opts)
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error listing audit log: " + err.Error(),
+		})
+		return
+	}
+
+	WriteJson(w, http.StatusOK, newListResponse(events, opts, func(e *AuditEvent) int64 { return e.ID }))
+}
@@ -2,68 +2,153 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/ZiadMansourM/middleware/logging"
+	"github.com/ZiadMansourM/middleware/middleware"
 	"github.com/golang-jwt/jwt"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type APIServer struct {
-	addr  string
-	store Store
+	addr     string
+	store    Store
+	events   *EventBroker
+	registry *prometheus.Registry
 }
 
 func NewAPIServer(addr string, store Store) *APIServer {
 	return &APIServer{
-		addr:  addr,
-		store: store,
+		addr:     addr,
+		store:    store,
+		events:   NewEventBroker(),
+		registry: NewMetricsRegistry(),
 	}
 }
 
-func (s *APIServer) Run() {
-	done := make(chan os.Signal, 1)
-	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+// Handler mounts every API version and the web UI on a single mux. Each
+// version gets its own router but they share the same services.
+func (s *APIServer) Handler() (http.Handler, *EventsService, error) {
+	v1Deprecation, err := NewDeprecation(Envs.APIV1DeprecatedAt, Envs.APIV1Sunset, Envs.APIV1DeprecationLink)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid API v1 deprecation config: %w", err)
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer func() {
-		// extra handling here e.g.:
-		// Close database, redis, truncate message queues, etc.
-		cancel()
-	}()
+	if Envs.CORSAllowCredentials && slices.Contains(Envs.CORSAllowedOrigins, "*") {
+		return nil, nil, fmt.Errorf("CORS_ALLOW_CREDENTIALS can't be used with every origin, list them in CORS_ALLOWED_ORIGINS")
+	}
 
-	router := http.NewServeMux()
+	mailer, err := NewMailer(Envs)
+	if err != nil {
+		return nil, nil, err
+	}
+	passwordPolicy, err := LoadPasswordPolicy(Envs.PasswordMinLength, Envs.BreachedPasswordsFile)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	v1 := http.NewServeMux()
-	v1.Handle("/api/v1/", http.StripPrefix("/api/v1", router))
+	// START Registering Services
+	tasksService := NewTasksService(s.store, s.events)
+	projectsService := NewProjectsService(s.store)
+	eventsService := NewEventsService(s.store, s.events)
+	usersService := NewUserService(s.store, mailer, passwordPolicy)
+	accessTokenService := NewAccessTokenService(s.store)
+	adminService := NewAdminService(s.store)
+	webService := NewWebService(s.store, usersService, tasksService)
+	v2Service := NewV2Service(s.store, tasksService)
+	// END Registering Services
 
-	// Health Check
-	router.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+	v1 := http.NewServeMux()
+	v1.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
 		WriteJson(w, http.StatusOK, map[string]string{"message": "API is healthy"})
 	})
+	tasksService.RegisterRoutes(v1)
+	projectsService.RegisterRoutes(v1)
+	eventsService.RegisterRoutes(v1)
+	usersService.RegisterRoutes(v1)
+	accessTokenService.RegisterRoutes(v1)
+	adminService.RegisterRoutes(v1)
 
-	// START Registering Services
-	tasksService := NewTasksService(s.store)
-	tasksService.RegisterRoutes(router)
+	v2 := http.NewServeMux()
+	v2Service.RegisterRoutes(v2)
 
-	usersService := NewUserService(s.store)
-	usersService.RegisterRoutes(router)
-	// END Registering Services
+	root := http.NewServeMux()
+	mountAPIVersion(root, "v1", v1, VersionDeprecated(v1Deprecation, "/api/v2", v2))
+	mountAPIVersion(root, "v2", v2)
+	root.HandleFunc("GET /.well-known/jwks.json", handleJWKS)
+
+	// The web UI lives outside /api and authenticates with the session
+	// cookie instead of the Authorization header.
+	webService.RegisterRoutes(root)
+
+	if Envs.OIDCIssuer != "" {
+		NewOIDCService(s.store, NewOIDCProvider(OIDCConfig{
+			Issuer:       Envs.OIDCIssuer,
+			ClientID:     Envs.OIDCClientID,
+			ClientSecret: Envs.OIDCClientSecret,
+			RedirectURL:  Envs.OIDCRedirectURL,
+			Scopes:       Envs.OIDCScopes,
+		})).RegisterRoutes(root)
+		webService.sso = true
+	}
 
 	middlewareChain := MiddlewareChain(
+		TracingMiddleware(otel.GetTracerProvider()),
+		MetricsMiddleware(s.registry),
 		RequestLoggerMiddleware,
-		RequireAuthMiddleware,
+		Middleware(middleware.Compress(middleware.CompressOptions{MinSize: Envs.CompressMinSize})),
+		Middleware(middleware.Recover(s.registry)),
+		CORSMiddleware(Envs),
+		Middleware(middleware.Limit(NewRouteLimits(Envs))),
+		RequireAuthMiddleware(s.store),
+		Middleware(middleware.RateLimit(NewRateLimiter(Envs))),
 	)
+	return middlewareChain(middleware.Routes("", root)), eventsService, nil
+}
+
+// DebugHandler serves the Prometheus metrics and expvar variables. They
+// describe the server's internals, so Run serves them on DebugAddress
+// rather than next to the API.
+func (s *APIServer) DebugHandler() http.Handler {
+	debug := http.NewServeMux()
+	debug.Handle("GET /debug/vars", expvar.Handler())
+	debug.Handle("GET /metrics", metricsHandler(s.registry))
+	return debug
+}
+
+func (s *APIServer) Run() {
+	done := make(chan os.Signal, 1)
+	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+
+	handler, eventsService, err := s.Handler()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	server := http.Server{
-		Addr:    s.addr,
-		Handler: middlewareChain(v1),
+		Addr:              s.addr,
+		Handler:           handler,
+		ReadHeaderTimeout: Envs.ReadHeaderTimeout,
+		ReadTimeout:       Envs.ReadTimeout,
+		WriteTimeout:      Envs.WriteTimeout,
+		IdleTimeout:       Envs.IdleTimeout,
 	}
+	// Tell open event streams to say goodbye so Shutdown isn't left waiting
+	// on them until the context deadline.
+	server.RegisterOnShutdown(s.events.Close)
 
 	go func() {
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -72,27 +157,85 @@ func (s *APIServer) Run() {
 	}()
 	log.Printf("Server Listening on %s\n", s.addr)
 
+	debugServer := http.Server{
+		Addr:              Envs.DebugAddress,
+		Handler:           s.DebugHandler(),
+		ReadHeaderTimeout: Envs.ReadHeaderTimeout,
+	}
+	if Envs.DebugAddress != "" {
+		go func() {
+			if err := debugServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Could not listen on %s: %v\n", Envs.DebugAddress, err)
+			}
+		}()
+		log.Printf("Metrics Listening on %s\n", Envs.DebugAddress)
+	}
+
+	stopReload := make(chan struct{})
+	defer close(stopReload)
+	go watchJWTSecret(stopReload)
+
 	<-done
 	fmt.Println("")
 	log.Println("Gracefully shutting down server...")
 
+	// The shutdown deadline starts now, not when the server started.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer func() {
+		// extra handling here e.g.:
+		// Close database, redis, truncate message queues, etc.
+		cancel()
+	}()
+
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Could not shutdown server: %v\n", err)
 	}
+	if err := debugServer.Shutdown(ctx); err != nil {
+		log.Printf("Could not shutdown metrics server: %v\n", err)
+	}
+	if err := eventsService.Wait(ctx); err != nil {
+		log.Printf("Event streams did not drain: %v\n", err)
+	}
 	log.Println("Server Exited Properly")
 }
 
+// RequestLoggerMiddleware logs a structured line per request with slog's
+// default logger, see the logging package of the middleware module.
 func RequestLoggerMiddleware(next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("%s %s", r.Method, r.URL.Path)
+		start := time.Now()
+		r = middleware.WithRoute(r.WithContext(logging.WithUser(r.Context())))
+		w, rec := middleware.WrapResponseWriter(w)
 		next.ServeHTTP(w, r)
+
+		req := logging.Request{
+			Method:   r.Method,
+			Route:    middleware.Route(r),
+			Status:   rec.Status(),
+			Duration: time.Since(start),
+			Bytes:    rec.Bytes(),
+			UserID:   logging.UserID(r.Context()),
+		}
+		if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+			req.TraceID = sc.TraceID().String()
+		}
+		logging.LogRequest(r.Context(), slog.Default(), req)
 	}
 }
 
-func RequireAuthMiddleware(next http.Handler) http.HandlerFunc {
+// RequireAuthMiddleware authenticates API requests. The store is used to
+// reject tokens of deleted users and sessions revoked by a password reset.
+func RequireAuthMiddleware(store Store) Middleware {
+	return func(next http.Handler) http.HandlerFunc {
+		return requireAuth(store, next)
+	}
+}
+
+func requireAuth(store Store, next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Exclude user registration route from authentication
-		if r.URL.Path == "/api/v1/users/register" {
+		// Exclude user registration and login routes, and everything outside
+		// the API (web UI, debug vars), from authentication
+		if isPublicRoute(r.URL.Path) {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -100,6 +243,28 @@ func RequireAuthMiddleware(next http.Handler) http.HandlerFunc {
 		// Read JWT from header
 		tokenString := r.Header.Get("Authorization")
 
+		// Browser EventSource and WebSocket clients cannot set headers, so
+		// event streams also accept the token as a query parameter.
+		if tokenString == "" && strings.HasSuffix(r.URL.Path, "/events") {
+			if t := r.URL.Query().Get("access_token"); t != "" {
+				tokenString = "Bearer " + t
+			}
+		}
+
+		// Browsers send the session cookie instead, which they also attach
+		// to cross-site requests, so unsafe methods need a CSRF token.
+		if tokenString == "" {
+			if session := sessionToken(r); session != "" {
+				if !validCSRF(r, session) {
+					WriteJson(w, http.StatusForbidden, ErrorResponse{
+						Error: "Forbidden: missing or invalid CSRF token",
+					})
+					return
+				}
+				tokenString = "Bearer " + session
+			}
+		}
+
 		// validate token
 		if !strings.HasPrefix(tokenString, "Bearer ") {
 			WriteJson(w, http.StatusUnauthorized, ErrorResponse{
@@ -111,6 +276,11 @@ func RequireAuthMiddleware(next http.Handler) http.HandlerFunc {
 		// strip "Bearer " from token
 		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
 
+		if strings.HasPrefix(tokenString, accessTokenPrefix) {
+			serveWithAccessToken(store, next, w, r, tokenString)
+			return
+		}
+
 		token, err := validateToken(tokenString)
 		if err != nil {
 			WriteJson(w, http.StatusUnauthorized, ErrorResponse{
@@ -127,20 +297,97 @@ func RequireAuthMiddleware(next http.Handler) http.HandlerFunc {
 		}
 
 		claims, _ := token.Claims.(jwt.MapClaims)
-		userID := claims["userID"].(string)
+		// Reject tokens without a userID claim rather than panic.
+		userID, ok := claims["userID"].(string)
+		if !ok {
+			WriteJson(w, http.StatusUnauthorized, ErrorResponse{
+				Error: "Unauthorized: invalid token",
+			})
+			return
+		}
+
+		info := &AuthInfo{UserID: userID}
+		info.ExpiresAt, _ = tokenExpiry(claims)
+
+		user, err := store.GetUserByID(userID)
+		if err != nil {
+			WriteJson(w, http.StatusUnauthorized, ErrorResponse{
+				Error: "Unauthorized: invalid user",
+			})
+			return
+		}
+		if sessionRevoked(user, claims) {
+			WriteJson(w, http.StatusUnauthorized, ErrorResponse{
+				Error: "Unauthorized: session was revoked, sign in again",
+			})
+			return
+		}
+
+		setSpanUser(r.Context(), userID)
+		logging.SetUserID(r.Context(), userID)
+		next.ServeHTTP(w, r.WithContext(withAuthInfo(r.Context(), info)))
+	}
+}
 
-		log.Printf("User ID: %s\n", userID)
+// serveWithAccessToken authenticates a personal access token. Unlike
+// sessions, tokens only open the routes their scopes allow.
+func serveWithAccessToken(store Store, next http.Handler, w http.ResponseWriter, r *http.Request, raw string) {
+	token, _, err := authenticateAccessToken(store, raw)
+	if err != nil {
+		WriteJson(w, http.StatusUnauthorized, ErrorResponse{
+			Error: "Unauthorized: " + err.Error(),
+		})
+		return
+	}
 
-		// _, err = store.GetUserByID(userID)
-		// if err != nil {
-		// 	WriteJson(w, http.StatusUnauthorized, ErrorResponse{
-		// 		Error: "Unauthorized: invalid user. " + err.Error(),
-		// 	})
-		// 	return
-		// }
+	route, _ := apiRoute(r.URL.Path)
+	scope, ok := requiredScope(r, route)
+	if !ok {
+		WriteJson(w, http.StatusForbidden, ErrorResponse{
+			Error: "Forbidden: personal access tokens can't be used here, sign in instead",
+		})
+		return
+	}
+	if scope != "" && !token.HasScope(scope) {
+		WriteJson(w, http.StatusForbidden, ErrorResponse{
+			Error: "Forbidden: token is missing the " + scope + " scope",
+		})
+		return
+	}
 
-		next.ServeHTTP(w, r)
+	info := &AuthInfo{
+		UserID:    strconv.FormatInt(token.UserID, 10),
+		ExpiresAt: token.ExpiresAt,
+		Scopes:    token.Scopes,
+	}
+	setSpanUser(r.Context(), info.UserID)
+	logging.SetUserID(r.Context(), info.UserID)
+	next.ServeHTTP(w, r.WithContext(withAuthInfo(r.Context(), info)))
+}
+
+// apiRoute returns path without the /api/{version} prefix, e.g.
+// "tasks/42". ok is false outside the API.
+func apiRoute(path string) (route string, ok bool) {
+	rest, ok := strings.CutPrefix(path, "/api/")
+	if !ok {
+		return "", false
+	}
+	_, route, _ = strings.Cut(rest, "/")
+	return route, true
+}
+
+// isPublicRoute reports whether path can be served without a bearer token.
+// The web UI checks its own session cookie.
+func isPublicRoute(path string) bool {
+	route, ok := apiRoute(path)
+	if !ok {
+		return true
+	}
+	switch route {
+	case "users/register", "users/login", "users/login/mfa", "users/login/passkey/begin", "users/login/passkey", "users/verify", "users/password/forgot", "users/password/reset":
+		return true
 	}
+	return false
 }
 
 type Middleware func(http.Handler) http.HandlerFunc
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ZiadMansourM/middleware/logging"
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRequestLoggerMiddleware(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := logging.New(&out, logging.FormatJSON, slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+
+	store := newMemoryStore()
+	hashed, _ := HashPassword("a long enough passphrase")
+	user, _ := store.CreateUser(&User{Email: "ada@example.com", Password: hashed})
+	token, _ := CreateJWT(user.ID, []byte(Envs.JWTSecret))
+
+	api, _, err := NewAPIServer("", store).Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+
+	var line map[string]any
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("Expected one JSON line, got %q", out.String())
+	}
+	expected := map[string]any{
+		"level":    "INFO",
+		"msg":      "request",
+		"method":   "GET",
+		"route":    "GET /api/v1/users/me",
+		"status":   float64(http.StatusOK),
+		"bytes":    float64(rec.Body.Len()),
+		"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736",
+		"user_id":  strconv.FormatInt(user.ID, 10),
+	}
+	for key, value := range expected {
+		if line[key] != value {
+			t.Errorf("Expected %s to be %v, got %v", key, value, line[key])
+		}
+	}
+	if _, ok := line["duration"].(float64); !ok {
+		t.Errorf("Expected a duration, got %v", line["duration"])
+	}
+}
+
+func TestRequireAuthRejectsTokensWithoutUser(t *testing.T) {
+	api, _, err := NewAPIServer("", newMemoryStore()).Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, claims := range []jwt.MapClaims{{}, {"userID": 42}} {
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(Envs.JWTSecret))
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status code %d for claims %v, got %d", http.StatusUnauthorized, claims, rec.Code)
+		}
+	}
+}
+
+func TestRequireAuthRejectsExpiredTokens(t *testing.T) {
+	store := newMemoryStore()
+	hashed, _ := HashPassword("a long enough passphrase")
+	user, _ := store.CreateUser(&User{Email: "ada@example.com", Password: hashed})
+	api, _, err := NewAPIServer("", store).Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID := strconv.FormatInt(user.ID, 10)
+	past := time.Now().Add(-time.Minute).Unix()
+	// Tokens signed before exp was added only carry expiresAt.
+	for _, claims := range []jwt.MapClaims{{"userID": userID, "exp": past}, {"userID": userID, "expiresAt": past}} {
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(Envs.JWTSecret))
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status code %d for claims %v, got %d", http.StatusUnauthorized, claims, rec.Code)
+		}
+	}
+
+	token, _ := CreateJWT(user.ID, []byte(Envs.JWTSecret))
+	parsed, err := validateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := parsed.Claims.(jwt.MapClaims)["exp"]; !ok {
+		t.Error("Expected session tokens to carry exp")
+	}
+}
+
+func TestDummyPasswordHash(t *testing.T) {
+	// Unknown emails must cost as much as a wrong password for a real user.
+	if cost, err := bcrypt.Cost([]byte(dummyPasswordHash)); err != nil || cost != bcrypt.DefaultCost {
+		t.Errorf("Expected a bcrypt hash of cost %d, got %d %v", bcrypt.DefaultCost, cost, err)
+	}
+}
+
+func TestResponsesAreCompressed(t *testing.T) {
+	api, _, err := NewAPIServer("", newMemoryStore()).Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/login", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("Expected a gzip response varying on Accept-Encoding, got %v", rec.Header())
+	}
+
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := io.ReadAll(zr)
+	if err != nil || !strings.Contains(string(page), `action="/login"`) {
+		t.Errorf("Expected the login page to decompress, got %v", err)
+	}
+}
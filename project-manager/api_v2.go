@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ZiadMansourM/middleware/utils"
+)
+
+// API v2 shares the store and services with v1 but speaks its own DTOs:
+// snake_case fields, a {"data": ...} envelope, no password hashes in user
+// payloads, strict request decoding and RFC 9457 problem details for errors.
+
+type TaskV2 struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	Status     string    `json:"status"`
+	ProjectID  int64     `json:"project_id"`
+	AssigneeID int64     `json:"assignee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type ProjectV2 struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type UserV2 struct {
+	ID        int64     `json:"id"`
+	Email     string    `json:"email"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type createTaskV2 struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	ProjectID  int64  `json:"project_id"`
+	AssigneeID int64  `json:"assignee_id"`
+}
+
+type updateTaskV2 struct {
+	Name       *string `json:"name"`
+	Status     *string `json:"status"`
+	AssigneeID *int64  `json:"assignee_id"`
+}
+
+type createProjectV2 struct {
+	Name string `json:"name"`
+}
+
+type dataV2[T any] struct {
+	Data T `json:"data"`
+}
+
+type listV2[T any] struct {
+	Data       []T    `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type V2Service struct {
+	store Store
+	tasks *TasksService
+}
+
+func NewV2Service(store Store, tasks *TasksService) *V2Service {
+	return &V2Service{store: store, tasks: tasks}
+}
+
+func (s *V2Service) RegisterRoutes(r *http.ServeMux) {
+	r.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		WriteJson(w, http.StatusOK, dataV2[map[string]string]{Data: map[string]string{"status": "ok"}})
+	})
+	r.HandleFunc("GET /users/me", s.handleGetCurrentUser)
+	r.HandleFunc("GET /projects", s.handleListProjects)
+	r.HandleFunc("POST /projects", s.handleCreateProject)
+	r.HandleFunc("GET /projects/{id}", s.handleGetProject)
+	r.HandleFunc("GET /projects/{id}/tasks", s.handleListProjectTasks)
+	r.HandleFunc("POST /tasks", s.handleCreateTask)
+	r.HandleFunc("GET /tasks/{id}", s.handleGetTask)
+	r.HandleFunc("PATCH /tasks/{id}", s.handleUpdateTask)
+}
+
+func decodeV2(r *http.Request, v any) error {
+	defer r.Body.Close()
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+func toTaskV2(t *Task) TaskV2 {
+	return TaskV2{
+		ID:         t.ID,
+		Name:       t.Name,
+		Status:     t.Status,
+		ProjectID:  t.ProjectID,
+		AssigneeID: t.AssignedToID,
+		CreatedAt:  t.CreatedAt,
+	}
+}
+
+func toProjectV2(p *Project) ProjectV2 {
+	return ProjectV2{ID: p.ID, Name: p.Name, CreatedAt: p.CreatedAt}
+}
+
+func mapV2[T, V any](items []T, convert func(T) V) []V {
+	out := make([]V, len(items))
+	for i, item := range items {
+		out[i] = convert(item)
+	}
+	return out
+}
+
+func (s *V2Service) handleGetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	info := authInfoFromContext(r.Context())
+	if info == nil {
+		utils.WriteProblem(w, http.StatusUnauthorized, "")
+		return
+	}
+
+	u, err := s.store.GetUserByID(info.UserID)
+	if err != nil {
+		utils.WriteProblem(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	WriteJson(w, http.StatusOK, dataV2[UserV2]{Data: UserV2{
+		ID:        u.ID,
+		Email:     u.Email,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		CreatedAt: u.CreatedAt,
+	}})
+}
+
+func (s *V2Service) handleListProjects(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		utils.WriteProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	projects, err := s.store.ListProjects(opts)
+	if err != nil {
+		utils.WriteProblem(w, http.StatusInternalServerError, "error listing projects")
+		return
+	}
+
+	page := newListResponse(projects, opts, func(p *Project) int64 { return p.ID })
+	WriteJson(w, http.StatusOK, listV2[ProjectV2]{
+		Data:       mapV2(page.Items, toProjectV2),
+		NextCursor: page.NextCursor,
+	})
+}
+
+func (s *V2Service) handleCreateProject(w http.ResponseWriter, r *http.Request) {
+	var payload createProjectV2
+	if err := decodeV2(r, &payload); err != nil {
+		utils.WriteProblem(w, http.StatusBadRequest, "invalid JSON payload: "+err.Error())
+		return
+	}
+
+	ownerID, _ := strconv.ParseInt(authInfoFromContext(r.Context()).UserID, 10, 64)
+	project := &Project{Name: payload.Name, OwnerID: ownerID}
+	if err := project.validate(); err != nil {
+		utils.WriteProblem(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	p, err := s.store.CreateProject(project)
+	if err != nil {
+		utils.WriteProblem(w, http.StatusInternalServerError, "error creating project")
+		return
+	}
+
+	WriteJson(w, http.StatusCreated, dataV2[ProjectV2]{Data: toProjectV2(p)})
+}
+
+func (s *V2Service) handleGetProject(w http.ResponseWriter, r *http.Request) {
+	p, err := s.store.GetProject(r.PathValue("id"))
+	if err != nil {
+		utils.WriteProblem(w, http.StatusNotFound, "project not found")
+		return
+	}
+
+	WriteJson(w, http.StatusOK, dataV2[ProjectV2]{Data: toProjectV2(p)})
+}
+
+func (s *V2Service) handleListProjectTasks(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		utils.WriteProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tasks, err := s.store.ListProjectTasks(r.PathValue("id"), opts)
+	if err != nil {
+		utils.WriteProblem(w, http.StatusInternalServerError, "error listing tasks")
+		return
+	}
+
+	page := newListResponse(tasks, opts, func(t *Task) int64 { return t.ID })
+	WriteJson(w, http.StatusOK, listV2[TaskV2]{
+		Data:       mapV2(page.Items, toTaskV2),
+		NextCursor: page.NextCursor,
+	})
+}
+
+func (s *V2Service) handleCreateTask(w http.ResponseWriter, r *http.Request) {
+	var payload createTaskV2
+	if err := decodeV2(r, &payload); err != nil {
+		utils.WriteProblem(w, http.StatusBadRequest, "invalid JSON payload: "+err.Error())
+		return
+	}
+
+	task := &Task{
+		Name:         payload.Name,
+		Status:       payload.Status,
+		ProjectID:    payload.ProjectID,
+		AssignedToID: payload.AssigneeID,
+	}
+	if err := task.validate(); err != nil {
+		utils.WriteProblem(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	t, err := s.tasks.createTask(task)
+	if err != nil {
+		utils.WriteProblem(w, http.StatusInternalServerError, "error creating task")
+		return
+	}
+
+	WriteJson(w, http.StatusCreated, dataV2[TaskV2]{Data: toTaskV2(t)})
+}
+
+func (s *V2Service) handleGetTask(w http.ResponseWriter, r *http.Request) {
+	t, err := s.store.GetTask(r.PathValue("id"))
+	if err != nil {
+		utils.WriteProblem(w, http.StatusNotFound, "task not found")
+		return
+	}
+
+	WriteJson(w, http.StatusOK, dataV2[TaskV2]{Data: toTaskV2(t)})
+}
+
+func (s *V2Service) handleUpdateTask(w http.ResponseWriter, r *http.Request) {
+	var payload updateTaskV2
+	if err := decodeV2(r, &payload); err != nil {
+		utils.WriteProblem(w, http.StatusBadRequest, "invalid JSON payload: "+err.Error())
+		return
+	}
+
+	t, err := s.store.GetTask(r.PathValue("id"))
+	if err != nil {
+		utils.WriteProblem(w, http.StatusNotFound, "task not found")
+		return
+	}
+
+	previousStatus := t.Status
+	if payload.Name != nil {
+		t.Name = *payload.Name
+	}
+	if payload.Status != nil {
+		t.Status = *payload.Status
+	}
+	if payload.AssigneeID != nil {
+		t.AssignedToID = *payload.AssigneeID
+	}
+
+	if err := t.validate(); err != nil {
+		utils.WriteProblem(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	t, err = s.tasks.updateTask(t, previousStatus)
+	if err != nil {
+		utils.WriteProblem(w, http.StatusInternalServerError, "error updating task")
+		return
+	}
+
+	WriteJson(w, http.StatusOK, dataV2[TaskV2]{Data: toTaskV2(t)})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,6 +13,33 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+type contextKey struct {
+	name string
+}
+
+func (k *contextKey) String() string {
+	return "project-manager context key " + k.name
+}
+
+var authInfoKey = &contextKey{"auth-info"}
+
+// AuthInfo describes the caller authenticated by RequireAuthMiddleware.
+type AuthInfo struct {
+	UserID    string
+	ExpiresAt time.Time
+	// Scopes of the personal access token, nil for sessions.
+	Scopes []string
+}
+
+func withAuthInfo(ctx context.Context, info *AuthInfo) context.Context {
+	return context.WithValue(ctx, authInfoKey, info)
+}
+
+func authInfoFromContext(ctx context.Context) *AuthInfo {
+	info, _ := ctx.Value(authInfoKey).(*AuthInfo)
+	return info
+}
+
 func HashPassword(password string) (string, error) {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -21,13 +49,34 @@ func HashPassword(password string) (string, error) {
 	return string(hash), nil
 }
 
+// dummyPasswordHash is compared against when there's no account to check,
+// so unknown emails take as long as wrong passwords. It's a bcrypt hash at
+// bcrypt.DefaultCost, like HashPassword's.
+const dummyPasswordHash = "$2a$10$DbVpkjzdecpxtIEJEEIi0eBQPLOVEyE/y/y6bABAn1ToWVJknw76."
+
+func ComparePassword(hashed, plain string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(plain)) == nil
+}
+
+// CreateJWT signs a session token with the active key of jwtKeys. The
+// secret is only used, with HS256, when no asymmetric keys are configured.
 func CreateJWT(userID int64, secret []byte) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userID":    strconv.Itoa(int(userID)),
-		"expiresAt": time.Now().Add(time.Hour * 24 * 120).Unix(),
-	})
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"userID": strconv.Itoa(int(userID)),
+		"iat":    now.Unix(),
+		"exp":    now.Add(sessionTTL).Unix(),
+		// Kept for clients reading it, tokenExpiry prefers exp.
+		"expiresAt": now.Add(sessionTTL).Unix(),
+	}
 
-	tokenString, err := token.SignedString(secret)
+	var tokenString string
+	var err error
+	if jwtKeys != nil {
+		tokenString, err = jwtKeys.Sign(claims)
+	} else {
+		tokenString, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	}
 	if err != nil {
 		return "", fmt.Errorf("error signing token: %w", err)
 	}
@@ -68,7 +117,13 @@ func WithJWTAuth(handlerFunc http.HandlerFunc, store Store) http.HandlerFunc {
 		}
 
 		claims, _ := token.Claims.(jwt.MapClaims)
-		userID := claims["userID"].(string)
+		userID, ok := claims["userID"].(string)
+		if !ok {
+			WriteJson(w, http.StatusUnauthorized, ErrorResponse{
+				Error: "Unauthorized: invalid token",
+			})
+			return
+		}
 
 		log.Printf("User ID: %s\n", userID)
 
@@ -84,15 +139,57 @@ func WithJWTAuth(handlerFunc http.HandlerFunc, store Store) http.HandlerFunc {
 	}
 }
 
+// sessionRevoked reports whether the token was issued before the user's
+// sessions were revoked, e.g. by a password reset. Tokens from before iat
+// was added count as issued at the epoch.
+func sessionRevoked(user *User, claims jwt.MapClaims) bool {
+	if user.SessionsRevokedAt == nil {
+		return false
+	}
+	iat, _ := claims["iat"].(float64)
+	return int64(iat) < user.SessionsRevokedAt.Unix()
+}
+
 func validateToken(token string) (*jwt.Token, error) {
+	keys := jwtKeys
 	// get secret key
-	secret := Envs.JWTSecret
+	secret := currentJWTSecret()
 	// parse token
-	return jwt.Parse(token, func(t *jwt.Token) (any, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+	parsed, err := jwt.Parse(token, func(t *jwt.Token) (any, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+			// HS256 tokens are only trusted while there are no asymmetric
+			// keys, or during the migration to them.
+			if keys != nil && !Envs.JWTAcceptHS256 {
+				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+			}
+			return []byte(secret), nil
 		}
 
-		return []byte(secret), nil
+		if keys == nil {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return keys.Key(t)
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	// jwt only checks exp, tokens signed before it was added carry
+	// expiresAt alone.
+	claims, _ := parsed.Claims.(jwt.MapClaims)
+	if exp, ok := tokenExpiry(claims); ok && !time.Now().Before(exp) {
+		return nil, jwt.NewValidationError("token is expired", jwt.ValidationErrorExpired)
+	}
+	return parsed, nil
+}
+
+// tokenExpiry returns when a session token expires, from exp or, for older
+// tokens, expiresAt.
+func tokenExpiry(claims jwt.MapClaims) (time.Time, bool) {
+	for _, name := range []string{"exp", "expiresAt"} {
+		if exp, ok := claims[name].(float64); ok {
+			return time.Unix(int64(exp), 0), true
+		}
+	}
+	return time.Time{}, false
 }
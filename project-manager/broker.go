@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	EventTaskCreated       = "task.created"
+	EventTaskUpdated       = "task.updated"
+	EventTaskStatusChanged = "task.status_changed"
+
+	// EventStreamReset tells a resuming client that the events it missed are
+	// no longer retained and it should refetch the board before continuing.
+	EventStreamReset = "stream.reset"
+)
+
+const (
+	defaultEventHistory = 256
+	subscriberBuffer    = 64
+)
+
+var errBrokerClosed = errors.New("event broker is closed")
+
+// Event is a change notification pushed to clients watching a project.
+// IDs are sequential per project so clients can resume with Last-Event-ID.
+type Event struct {
+	ID        uint64    `json:"id"`
+	Type      string    `json:"type"`
+	ProjectID int64     `json:"projectID"`
+	Data      any       `json:"data"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type TaskStatusChange struct {
+	Task *Task  `json:"task"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// EventBroker fans out project events to subscribers and keeps a short
+// per-project history for resuming clients. A nil *EventBroker drops events.
+type EventBroker struct {
+	mu          sync.Mutex
+	projects    map[int64]*projectStream
+	historySize int
+	closed      bool
+}
+
+type projectStream struct {
+	seq     uint64
+	history []Event
+	subs    map[*Subscription]struct{}
+}
+
+type Subscription struct {
+	broker    *EventBroker
+	projectID int64
+	events    chan Event
+	closed    bool
+}
+
+func NewEventBroker() *EventBroker {
+	return &EventBroker{
+		projects:    make(map[int64]*projectStream),
+		historySize: defaultEventHistory,
+	}
+}
+
+func (b *EventBroker) stream(projectID int64) *projectStream {
+	ps, ok := b.projects[projectID]
+	if !ok {
+		ps = &projectStream{subs: make(map[*Subscription]struct{})}
+		b.projects[projectID] = ps
+	}
+	return ps
+}
+
+func (b *EventBroker) Publish(projectID int64, eventType string, data any) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	ps := b.stream(projectID)
+	ps.seq++
+	e := Event{
+		ID:        ps.seq,
+		Type:      eventType,
+		ProjectID: projectID,
+		Data:      data,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	ps.history = append(ps.history, e)
+	if len(ps.history) > b.historySize {
+		ps.history = ps.history[len(ps.history)-b.historySize:]
+	}
+
+	for sub := range ps.subs {
+		select {
+		case sub.events <- e:
+		default:
+			// Slow consumer: drop it, it will reconnect with Last-Event-ID
+			// and catch up from the history.
+			b.unsubscribe(sub)
+		}
+	}
+}
+
+// Subscribe registers a subscriber for projectID. When resume is true the
+// events after lastEventID are returned as backlog; if they have already been
+// evicted (or the server restarted) a single EventStreamReset is returned.
+func (b *EventBroker) Subscribe(projectID int64, lastEventID uint64, resume bool) (*Subscription, []Event, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, nil, errBrokerClosed
+	}
+
+	ps := b.stream(projectID)
+	sub := &Subscription{
+		broker:    b,
+		projectID: projectID,
+		events:    make(chan Event, subscriberBuffer),
+	}
+	ps.subs[sub] = struct{}{}
+
+	if !resume {
+		return sub, nil, nil
+	}
+
+	oldest := ps.seq + 1
+	if len(ps.history) > 0 {
+		oldest = ps.history[0].ID
+	}
+	if lastEventID > ps.seq || lastEventID+1 < oldest {
+		return sub, []Event{{
+			ID:        ps.seq,
+			Type:      EventStreamReset,
+			ProjectID: projectID,
+			CreatedAt: time.Now().UTC(),
+		}}, nil
+	}
+
+	var backlog []Event
+	for _, e := range ps.history {
+		if e.ID > lastEventID {
+			backlog = append(backlog, e)
+		}
+	}
+	return sub, backlog, nil
+}
+
+// Events is closed when the subscription ends, either because the broker is
+// shutting down or because the subscriber fell too far behind.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.unsubscribe(s)
+}
+
+// unsubscribe must be called with b.mu held.
+func (b *EventBroker) unsubscribe(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.events)
+	if ps, ok := b.projects[sub.projectID]; ok {
+		delete(ps.subs, sub)
+	}
+}
+
+// Close ends every subscription so streaming handlers can say goodbye and
+// return before the server finishes shutting down.
+func (b *EventBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.closed = true
+
+	for _, ps := range b.projects {
+		for sub := range ps.subs {
+			b.unsubscribe(sub)
+		}
+	}
+}
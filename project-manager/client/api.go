@@ -0,0 +1,198 @@
+package client
+
+import (
+	"context"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Register creates an account. It does not authenticate the client.
+func (c *Client) Register(ctx context.Context, r RegisterRequest) (*User, error) {
+	var u User
+	if err := c.do(ctx, http.MethodPost, "/users/register", nil, r, &u, false); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// Login exchanges an email and password for a bearer token. For accounts
+// with two-factor authentication it returns a *MFARequiredError, pass its
+// Token to LoginMFA with a code from the authenticator app.
+func (c *Client) Login(ctx context.Context, email, password string) (string, error) {
+	in := map[string]string{"email": email, "password": password}
+	var out struct {
+		Token       string `json:"token"`
+		MFARequired bool   `json:"mfaRequired"`
+		MFAToken    string `json:"mfaToken"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/users/login", nil, in, &out, false); err != nil {
+		return "", err
+	}
+	if out.MFARequired {
+		return "", &MFARequiredError{Token: out.MFAToken}
+	}
+	return out.Token, nil
+}
+
+// LoginMFA finishes a login with a TOTP or recovery code.
+func (c *Client) LoginMFA(ctx context.Context, mfaToken, code string) (string, error) {
+	in := map[string]string{"mfaToken": mfaToken, "code": code}
+	var out struct {
+		Token string `json:"token"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/users/login/mfa", nil, in, &out, false); err != nil {
+		return "", err
+	}
+	return out.Token, nil
+}
+
+// Me returns the authenticated user.
+func (c *Client) Me(ctx context.Context) (*User, error) {
+	var u User
+	if err := c.do(ctx, http.MethodGet, "/users/me", nil, nil, &u, true); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// CreateAccessToken creates a personal access token for automation. It
+// needs a session, tokens can't create other tokens.
+func (c *Client) CreateAccessToken(ctx context.Context, r CreateAccessTokenRequest) (*AccessToken, error) {
+	var t AccessToken
+	if err := c.do(ctx, http.MethodPost, "/users/me/tokens", nil, r, &t, true); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (c *Client) ListAccessTokens(ctx context.Context) ([]*AccessToken, error) {
+	var tokens []*AccessToken
+	if err := c.do(ctx, http.MethodGet, "/users/me/tokens", nil, nil, &tokens, true); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (c *Client) RevokeAccessToken(ctx context.Context, id int64) error {
+	return c.do(ctx, http.MethodDelete, "/users/me/tokens/"+itoa(id), nil, nil, nil, true)
+}
+
+func (c *Client) CreateProject(ctx context.Context, name string) (*Project, error) {
+	var p Project
+	if err := c.do(ctx, http.MethodPost, "/projects", nil, Project{Name: name}, &p, true); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (c *Client) GetProject(ctx context.Context, id int64) (*Project, error) {
+	var p Project
+	if err := c.do(ctx, http.MethodGet, "/projects/"+itoa(id), nil, nil, &p, true); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ListProjects returns a single page of projects, see Projects to iterate
+// over all of them.
+func (c *Client) ListProjects(ctx context.Context, opts ListOptions) (*Page[*Project], error) {
+	var page Page[*Project]
+	if err := c.do(ctx, http.MethodGet, "/projects", opts.query(), nil, &page, true); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// Projects iterates over every project, fetching pages as needed. Iteration
+// stops after the first error, which is yielded with a nil project.
+func (c *Client) Projects(ctx context.Context, opts ListOptions) iter.Seq2[*Project, error] {
+	return paginate(ctx, opts, c.ListProjects)
+}
+
+func (c *Client) CreateTask(ctx context.Context, t *Task) (*Task, error) {
+	var out Task
+	if err := c.do(ctx, http.MethodPost, "/tasks", nil, t, &out, true); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) GetTask(ctx context.Context, id int64) (*Task, error) {
+	var t Task
+	if err := c.do(ctx, http.MethodGet, "/tasks/"+itoa(id), nil, nil, &t, true); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (c *Client) UpdateTask(ctx context.Context, id int64, u TaskUpdate) (*Task, error) {
+	var t Task
+	if err := c.do(ctx, http.MethodPatch, "/tasks/"+itoa(id), nil, u, &t, true); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// MoveTask changes a task's status, e.g. to StatusInProgress.
+func (c *Client) MoveTask(ctx context.Context, id int64, status string) (*Task, error) {
+	return c.UpdateTask(ctx, id, TaskUpdate{Status: &status})
+}
+
+// ListProjectTasks returns a single page of a project's tasks, see
+// ProjectTasks to iterate over all of them.
+func (c *Client) ListProjectTasks(ctx context.Context, projectID int64, opts ListOptions) (*Page[*Task], error) {
+	var page Page[*Task]
+	if err := c.do(ctx, http.MethodGet, "/projects/"+itoa(projectID)+"/tasks", opts.query(), nil, &page, true); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// ProjectTasks iterates over every task in a project, fetching pages as
+// needed. Iteration stops after the first error.
+func (c *Client) ProjectTasks(ctx context.Context, projectID int64, opts ListOptions) iter.Seq2[*Task, error] {
+	return paginate(ctx, opts, func(ctx context.Context, opts ListOptions) (*Page[*Task], error) {
+		return c.ListProjectTasks(ctx, projectID, opts)
+	})
+}
+
+func paginate[T any](ctx context.Context, opts ListOptions, fetch func(context.Context, ListOptions) (*Page[T], error)) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for {
+			page, err := fetch(ctx, opts)
+			if err != nil {
+				var zero T
+				yield(zero, err)
+				return
+			}
+
+			for _, item := range page.Items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+
+			if page.NextCursor == "" {
+				return
+			}
+			opts.Cursor = page.NextCursor
+		}
+	}
+}
+
+func (o ListOptions) query() url.Values {
+	q := url.Values{}
+	if o.PageSize > 0 {
+		q.Set("limit", strconv.Itoa(o.PageSize))
+	}
+	if o.Cursor != "" {
+		q.Set("cursor", o.Cursor)
+	}
+	return q
+}
+
+func itoa(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
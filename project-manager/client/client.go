@@ -0,0 +1,242 @@
+// Package client is a typed Go client for the project-manager API.
+//
+//	c, err := client.New("http://127.0.0.1:3000", client.WithCredentials("me@example.com", "secret"))
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	for task, err := range c.ProjectTasks(ctx, 1) {
+//		...
+//	}
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultUserAgent = "project-manager-go-client"
+
+// Client talks to a project-manager server. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	tokens     TokenSource
+	retry      RetryPolicy
+	userAgent  string
+}
+
+// RetryPolicy controls how idempotent requests are retried after network
+// errors and 429/502/503/504 responses. Backoff is exponential with full
+// jitter, capped at MaxBackoff; a Retry-After header takes precedence.
+type RetryPolicy struct {
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	MinBackoff:  200 * time.Millisecond,
+	MaxBackoff:  5 * time.Second,
+}
+
+type Option func(*Client)
+
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithTokenSource authenticates requests with tokens from ts.
+func WithTokenSource(ts TokenSource) Option {
+	return func(c *Client) { c.tokens = ts }
+}
+
+// WithToken authenticates requests with a fixed bearer token.
+func WithToken(token string) Option {
+	return WithTokenSource(StaticToken(token))
+}
+
+// WithCredentials logs in with email and password on first use and again
+// whenever the token is about to expire or is rejected.
+func WithCredentials(email, password string) Option {
+	return func(c *Client) { c.tokens = &PasswordTokenSource{client: c, email: email, password: password} }
+}
+
+// WithRetry replaces DefaultRetryPolicy. MaxAttempts of 1 disables retries.
+func WithRetry(p RetryPolicy) Option {
+	return func(c *Client) { c.retry = p }
+}
+
+func WithUserAgent(ua string) Option {
+	return func(c *Client) { c.userAgent = ua }
+}
+
+// New returns a client for the server at baseURL, e.g. "http://127.0.0.1:3000".
+// The /api/v1 prefix is added by the client.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q: scheme and host are required", baseURL)
+	}
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: http.DefaultClient,
+		retry:      DefaultRetryPolicy,
+		userAgent:  defaultUserAgent,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// do sends a JSON request to the API and decodes a JSON response into out.
+// Unauthenticated requests (login, register) pass auth=false.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out any, auth bool) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+	}
+
+	u := c.baseURL.JoinPath("/api/v1", path)
+	u.RawQuery = query.Encode()
+
+	refreshed := false
+	for attempt := 1; ; attempt++ {
+		res, err := c.send(ctx, method, u.String(), body, auth)
+		if err == nil && res.StatusCode == http.StatusUnauthorized && auth && !refreshed {
+			// The token may have been revoked or expired early, refresh
+			// once and try again before giving up.
+			if r, ok := c.tokens.(Refresher); ok {
+				res.Body.Close()
+				if _, err := r.Refresh(ctx); err != nil {
+					return err
+				}
+				refreshed = true
+				attempt--
+				continue
+			}
+		}
+
+		if !c.shouldRetry(method, res, err, attempt) {
+			if err != nil {
+				return err
+			}
+			return decodeResponse(res, out)
+		}
+
+		wait := c.backoff(attempt, res)
+		if res != nil {
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, url string, body []byte, auth bool) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if auth && c.tokens != nil {
+		token, err := c.tokens.Token(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("getting token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return c.httpClient.Do(req)
+}
+
+func (c *Client) shouldRetry(method string, res *http.Response, err error, attempt int) bool {
+	if attempt >= c.retry.MaxAttempts {
+		return false
+	}
+
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+	default:
+		return false
+	}
+
+	if err != nil {
+		// Context errors are final, anything else is a transport failure.
+		return !isContextError(err)
+	}
+
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (c *Client) backoff(attempt int, res *http.Response) time.Duration {
+	if res != nil {
+		if s, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && s >= 0 {
+			return min(time.Duration(s)*time.Second, c.retry.MaxBackoff)
+		}
+	}
+
+	ceiling := c.retry.MinBackoff << (attempt - 1)
+	if ceiling <= 0 || ceiling > c.retry.MaxBackoff {
+		ceiling = c.retry.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling) + 1
+}
+
+func decodeResponse(res *http.Response, out any) error {
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return newAPIError(res)
+	}
+
+	if out == nil {
+		io.Copy(io.Discard, res.Body)
+		return nil
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
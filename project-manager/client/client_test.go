@@ -0,0 +1,148 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var fastRetry = RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+
+func newTestClient(t *testing.T, handler http.Handler, opts ...Option) *Client {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	c, err := New(server.URL, append([]Option{WithRetry(fastRetry)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func TestProjectsIterator(t *testing.T) {
+	pages := map[string]Page[*Project]{
+		"":  {Items: []*Project{{ID: 1}, {ID: 2}}, NextCursor: "2"},
+		"2": {Items: []*Project{{ID: 3}}},
+	}
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/projects" || r.URL.Query().Get("limit") != "2" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+		writeJSON(w, http.StatusOK, pages[r.URL.Query().Get("cursor")])
+	}), WithToken("token"))
+
+	var ids []int64
+	for p, err := range c.Projects(context.Background(), ListOptions{PageSize: 2}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, p.ID)
+	}
+
+	if len(ids) != 3 || ids[0] != 1 || ids[2] != 3 {
+		t.Errorf("Expected projects 1, 2, 3, got %v", ids)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	t.Run("Idempotent requests are retried", func(t *testing.T) {
+		var calls atomic.Int32
+		c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) < 3 {
+				writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "busy"})
+				return
+			}
+			writeJSON(w, http.StatusOK, Task{ID: 42})
+		}), WithToken("token"))
+
+		task, err := c.GetTask(context.Background(), 42)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.ID != 42 || calls.Load() != 3 {
+			t.Errorf("Expected task 42 after 3 calls, got %d after %d", task.ID, calls.Load())
+		}
+	})
+
+	t.Run("Non-idempotent requests are not retried", func(t *testing.T) {
+		var calls atomic.Int32
+		c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "busy"})
+		}), WithToken("token"))
+
+		_, err := c.CreateTask(context.Background(), &Task{Name: "x"})
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || !apiErr.Temporary() {
+			t.Errorf("Expected a temporary APIError, got %v", err)
+		}
+		if calls.Load() != 1 {
+			t.Errorf("Expected 1 call, got %d", calls.Load())
+		}
+	})
+}
+
+func TestTokenRefresh(t *testing.T) {
+	var logins atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/users/login", func(w http.ResponseWriter, r *http.Request) {
+		n := logins.Add(1)
+		writeJSON(w, http.StatusOK, map[string]string{"token": fmt.Sprintf("token-%d", n)})
+	})
+	mux.HandleFunc("GET /api/v1/users/me", func(w http.ResponseWriter, r *http.Request) {
+		// Pretend the first token was revoked.
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+			return
+		}
+		writeJSON(w, http.StatusOK, User{ID: 7})
+	})
+
+	c := newTestClient(t, mux, WithCredentials("me@example.com", "secret"))
+
+	u, err := c.Me(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != 7 || logins.Load() != 2 {
+		t.Errorf("Expected user 7 after 2 logins, got %d after %d", u.ID, logins.Load())
+	}
+}
+
+func TestErrorDecoding(t *testing.T) {
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Error getting task: sql: no rows in result set"})
+	}), WithToken("token"))
+
+	_, err := c.GetTask(context.Background(), 1)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Message != "Error getting task: sql: no rows in result set" {
+		t.Errorf("Expected the server message, got %v", err)
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	// {"expiresAt":1700000000,"userID":"1"}
+	token := "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJleHBpcmVzQXQiOjE3MDAwMDAwMDAsInVzZXJJRCI6IjEifQ.sig"
+	if got := tokenExpiry(token); got.Unix() != 1700000000 {
+		t.Errorf("Expected 1700000000, got %d", got.Unix())
+	}
+}
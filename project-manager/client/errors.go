@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// APIError is returned for any non-2xx response. Compare it against the
+// sentinel errors with errors.Is, or unwrap it with errors.As for details.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+var (
+	ErrBadRequest      = &APIError{StatusCode: http.StatusBadRequest}
+	ErrUnauthorized    = &APIError{StatusCode: http.StatusUnauthorized}
+	ErrForbidden       = &APIError{StatusCode: http.StatusForbidden}
+	ErrNotFound        = &APIError{StatusCode: http.StatusNotFound}
+	ErrConflict        = &APIError{StatusCode: http.StatusConflict}
+	ErrTooManyRequests = &APIError{StatusCode: http.StatusTooManyRequests}
+)
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("project-manager: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("project-manager: %d %s", e.StatusCode, e.Message)
+}
+
+// Is matches on status code so errors.Is(err, ErrNotFound) works for any
+// 404 whatever its message.
+func (e *APIError) Is(target error) bool {
+	t, ok := target.(*APIError)
+	return ok && t.StatusCode == e.StatusCode
+}
+
+// Temporary reports whether retrying the request later may succeed.
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// MFARequiredError is returned by Login when the password was right but the
+// account also needs a second factor. Token expires after a few minutes.
+type MFARequiredError struct {
+	Token string
+}
+
+func (e *MFARequiredError) Error() string {
+	return "project-manager: two-factor authentication code required"
+}
+
+func newAPIError(res *http.Response) error {
+	e := &APIError{StatusCode: res.StatusCode}
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	var payload struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &payload) == nil && payload.Error != "" {
+		e.Message = payload.Error
+	}
+	return e
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
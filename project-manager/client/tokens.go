@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// refreshSkew refreshes tokens slightly before they expire so a request
+// doesn't race the expiry on the server.
+const refreshSkew = time.Minute
+
+// TokenSource supplies bearer tokens for authenticated requests.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// Refresher is implemented by token sources that can obtain a new token
+// after the server rejected the current one with 401.
+type Refresher interface {
+	Refresh(ctx context.Context) (string, error)
+}
+
+// StaticToken is a token source that always returns the same token.
+type StaticToken string
+
+func (t StaticToken) Token(context.Context) (string, error) {
+	return string(t), nil
+}
+
+// PasswordTokenSource logs in with an email and password and caches the
+// token until it is about to expire. Create it with WithCredentials.
+type PasswordTokenSource struct {
+	client   *Client
+	email    string
+	password string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (s *PasswordTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && (s.expires.IsZero() || time.Until(s.expires) > refreshSkew) {
+		return s.token, nil
+	}
+	return s.login(ctx)
+}
+
+func (s *PasswordTokenSource) Refresh(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.login(ctx)
+}
+
+// login must be called with s.mu held.
+func (s *PasswordTokenSource) login(ctx context.Context) (string, error) {
+	token, err := s.client.Login(ctx, s.email, s.password)
+	if err != nil {
+		return "", err
+	}
+
+	s.token = token
+	s.expires = tokenExpiry(token)
+	return token, nil
+}
+
+// tokenExpiry reads the expiry from a JWT without verifying it, the server
+// does that. It returns the zero time when the token has no usable expiry.
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+
+	var claims struct {
+		ExpiresAt int64 `json:"expiresAt"`
+		Exp       int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}
+	}
+
+	switch {
+	case claims.Exp != 0:
+		return time.Unix(claims.Exp, 0)
+	case claims.ExpiresAt != 0:
+		return time.Unix(claims.ExpiresAt, 0)
+	}
+	return time.Time{}
+}
@@ -0,0 +1,89 @@
+package client
+
+import "time"
+
+// Task statuses, in board order.
+const (
+	StatusTodo       = "TODO"
+	StatusInProgress = "IN_PROGRESS"
+	StatusInTesting  = "IN_TESTING"
+	StatusDone       = "DONE"
+)
+
+type User struct {
+	ID        int64  `json:"id"`
+	Email     string `json:"email"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	// Status is "pending" until the email address is verified.
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type RegisterRequest struct {
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+}
+
+type Project struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type Task struct {
+	ID           int64     `json:"id"`
+	Name         string    `json:"name"`
+	Status       string    `json:"status"`
+	ProjectID    int64     `json:"projectID"`
+	AssignedToID int64     `json:"assignedTo"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// TaskUpdate changes only the fields that are set.
+type TaskUpdate struct {
+	Name         *string `json:"name,omitempty"`
+	Status       *string `json:"status,omitempty"`
+	AssignedToID *int64  `json:"assignedTo,omitempty"`
+}
+
+// ListOptions selects a page. The zero value asks for the first page with
+// the server's default size.
+type ListOptions struct {
+	PageSize int
+	Cursor   string
+}
+
+// Page is one page of results. NextCursor is empty on the last page.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"nextCursor"`
+}
+
+// Scopes of personal access tokens.
+const (
+	ScopeTasksRead     = "tasks:read"
+	ScopeTasksWrite    = "tasks:write"
+	ScopeProjectsAdmin = "projects:admin"
+)
+
+// AccessToken is a personal access token. Token is only set in the response
+// to CreateAccessToken.
+type AccessToken struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	Token      string     `json:"token,omitempty"`
+}
+
+type CreateAccessTokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresInDays defaults to 30 on the server, at most 365.
+	ExpiresInDays int `json:"expiresInDays,omitempty"`
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ZiadMansourM/project-manager/client"
+	"golang.org/x/term"
+)
+
+func runLogin(ctx context.Context, a *app, args []string) error {
+	fs := a.newFlagSet("login")
+	server := fs.String("server", "", "server URL, e.g. "+defaultServer)
+	email := fs.String("email", "", "account email")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from stdin")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	p := a.config.CreateProfile(a.profile)
+	if *server != "" {
+		p.Server = *server
+	}
+	if *email != "" {
+		p.Email = *email
+	}
+	if p.Email == "" {
+		var err error
+		if p.Email, err = a.prompt("Email: "); err != nil {
+			return err
+		}
+	}
+
+	password, err := a.readPassword(*passwordStdin)
+	if err != nil {
+		return err
+	}
+
+	c, err := client.New(p.Server, client.WithUserAgent("pm"))
+	if err != nil {
+		return err
+	}
+	token, err := c.Login(ctx, p.Email, password)
+	var mfa *client.MFARequiredError
+	if errors.As(err, &mfa) {
+		code, perr := a.prompt("Authentication code: ")
+		if perr != nil {
+			return perr
+		}
+		token, err = c.LoginMFA(ctx, mfa.Token, code)
+	}
+	if err != nil {
+		return err
+	}
+
+	p.Token = token
+	if err := a.config.Save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stderr, "Logged in to %s as %s\n", p.Server, p.Email)
+	return nil
+}
+
+func runLogout(ctx context.Context, a *app, args []string) error {
+	fs := a.newFlagSet("logout")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	p, err := a.currentProfile()
+	if err != nil {
+		return err
+	}
+	p.Token = ""
+	return a.config.Save()
+}
+
+func runRegister(ctx context.Context, a *app, args []string) error {
+	fs := a.newFlagSet("register")
+	server := fs.String("server", "", "server URL, e.g. "+defaultServer)
+	req := client.RegisterRequest{}
+	fs.StringVar(&req.Email, "email", "", "account email")
+	fs.StringVar(&req.FirstName, "first-name", "", "first name")
+	fs.StringVar(&req.LastName, "last-name", "", "last name")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from stdin")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if req.Email == "" {
+		return errors.New("--email is required")
+	}
+
+	p, err := a.currentProfile()
+	if err != nil {
+		return err
+	}
+	if *server != "" {
+		p.Server = *server
+	}
+
+	if req.Password, err = a.readPassword(*passwordStdin); err != nil {
+		return err
+	}
+
+	c, err := client.New(p.Server, client.WithUserAgent("pm"))
+	if err != nil {
+		return err
+	}
+	u, err := c.Register(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	p.Email = u.Email
+	if err := a.config.Save(); err != nil {
+		return err
+	}
+	if u.Status == "pending" {
+		fmt.Fprintf(a.stderr, "Check %s for a link to verify your account, then run: pm login\n", u.Email)
+	}
+	return a.print(u, []string{"ID", "EMAIL", "NAME"}, [][]string{userRow(u)})
+}
+
+func runWhoami(ctx context.Context, a *app, args []string) error {
+	fs := a.newFlagSet("whoami")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	u, err := c.Me(ctx)
+	if err != nil {
+		return explain(err)
+	}
+	return a.print(u, []string{"ID", "EMAIL", "NAME"}, [][]string{userRow(u)})
+}
+
+func runProjectsList(ctx context.Context, a *app, args []string) error {
+	fs := a.newFlagSet("projects ls")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+
+	projects := []*client.Project{}
+	var rows [][]string
+	for p, err := range c.Projects(ctx, client.ListOptions{PageSize: 200}) {
+		if err != nil {
+			return explain(err)
+		}
+		projects = append(projects, p)
+		rows = append(rows, []string{itoa(p.ID), p.Name, p.CreatedAt.Format("2006-01-02")})
+	}
+	return a.print(projects, []string{"ID", "NAME", "CREATED"}, rows)
+}
+
+func runProjectsCreate(ctx context.Context, a *app, args []string) error {
+	fs := a.newFlagSet("projects create")
+	rest, err := a.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	p, err := c.CreateProject(ctx, rest[0])
+	if err != nil {
+		return explain(err)
+	}
+	return a.print(p, []string{"ID", "NAME"}, [][]string{{itoa(p.ID), p.Name}})
+}
+
+func runTasksList(ctx context.Context, a *app, args []string) error {
+	fs := a.newFlagSet("tasks ls")
+	projectID := fs.Int64("project", 0, "project ID")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *projectID == 0 {
+		return errors.New("--project is required")
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+
+	tasks := []*client.Task{}
+	var rows [][]string
+	for t, err := range c.ProjectTasks(ctx, *projectID, client.ListOptions{PageSize: 200}) {
+		if err != nil {
+			return explain(err)
+		}
+		tasks = append(tasks, t)
+		rows = append(rows, taskRow(t))
+	}
+	return a.print(tasks, taskColumns, rows)
+}
+
+func runTasksCreate(ctx context.Context, a *app, args []string) error {
+	fs := a.newFlagSet("tasks create")
+	t := &client.Task{}
+	fs.Int64Var(&t.ProjectID, "project", 0, "project ID")
+	fs.StringVar(&t.Name, "name", "", "task name")
+	fs.StringVar(&t.Status, "status", "", "initial status (default TODO)")
+	fs.Int64Var(&t.AssignedToID, "assignee", 0, "assignee user ID (default: you)")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if t.ProjectID == 0 || t.Name == "" {
+		return errors.New("--project and --name are required")
+	}
+	if t.Status != "" {
+		status, err := parseStatus(t.Status)
+		if err != nil {
+			return err
+		}
+		t.Status = status
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+
+	if t.AssignedToID == 0 {
+		me, err := c.Me(ctx)
+		if err != nil {
+			return explain(err)
+		}
+		t.AssignedToID = me.ID
+	}
+
+	created, err := c.CreateTask(ctx, t)
+	if err != nil {
+		return explain(err)
+	}
+	return a.print(created, taskColumns, [][]string{taskRow(created)})
+}
+
+func runTasksShow(ctx context.Context, a *app, args []string) error {
+	fs := a.newFlagSet("tasks show")
+	rest, err := a.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	id, err := parseID(rest[0])
+	if err != nil {
+		return err
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	t, err := c.GetTask(ctx, id)
+	if err != nil {
+		return explain(err)
+	}
+	return a.print(t, taskColumns, [][]string{taskRow(t)})
+}
+
+func runTasksMove(ctx context.Context, a *app, args []string) error {
+	fs := a.newFlagSet("tasks move")
+	rest, err := a.parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+	id, err := parseID(rest[0])
+	if err != nil {
+		return err
+	}
+	status, err := parseStatus(rest[1])
+	if err != nil {
+		return err
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	t, err := c.MoveTask(ctx, id, status)
+	if err != nil {
+		return explain(err)
+	}
+	return a.print(t, taskColumns, [][]string{taskRow(t)})
+}
+
+var tokenColumns = []string{"ID", "NAME", "SCOPES", "EXPIRES", "LAST USED"}
+
+func tokenRow(t *client.AccessToken) []string {
+	lastUsed := "never"
+	if t.LastUsedAt != nil {
+		lastUsed = t.LastUsedAt.Format("2006-01-02 15:04")
+	}
+	return []string{itoa(t.ID), t.Name, strings.Join(t.Scopes, ","), t.ExpiresAt.Format("2006-01-02"), lastUsed}
+}
+
+func runTokensList(ctx context.Context, a *app, args []string) error {
+	fs := a.newFlagSet("tokens ls")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	tokens, err := c.ListAccessTokens(ctx)
+	if err != nil {
+		return explain(err)
+	}
+
+	var rows [][]string
+	for _, t := range tokens {
+		rows = append(rows, tokenRow(t))
+	}
+	return a.print(tokens, tokenColumns, rows)
+}
+
+func runTokensCreate(ctx context.Context, a *app, args []string) error {
+	fs := a.newFlagSet("tokens create")
+	scopes := fs.String("scopes", "", "comma separated scopes: tasks:read, tasks:write, projects:admin")
+	days := fs.Int("expires-in-days", 0, "expiry in days (default 30, at most 365)")
+	rest, err := a.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if *scopes == "" {
+		return errors.New("--scopes is required")
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	t, err := c.CreateAccessToken(ctx, client.CreateAccessTokenRequest{
+		Name:          rest[0],
+		Scopes:        strings.Split(*scopes, ","),
+		ExpiresInDays: *days,
+	})
+	if err != nil {
+		return explain(err)
+	}
+
+	if a.output == "json" {
+		return a.print(t, nil, nil)
+	}
+	// The token is only shown once, print it on its own for scripts.
+	fmt.Fprintln(a.stderr, "Copy the token now, it won't be shown again:")
+	fmt.Fprintln(a.stdout, t.Token)
+	return nil
+}
+
+func runTokensRevoke(ctx context.Context, a *app, args []string) error {
+	fs := a.newFlagSet("tokens revoke")
+	rest, err := a.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	id, err := strconv.ParseInt(rest[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid token ID %q", rest[0])
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	return explain(c.RevokeAccessToken(ctx, id))
+}
+
+func runProfilesList(ctx context.Context, a *app, args []string) error {
+	fs := a.newFlagSet("profiles ls")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	type profileView struct {
+		Name     string `json:"name"`
+		Server   string `json:"server"`
+		Email    string `json:"email,omitempty"`
+		LoggedIn bool   `json:"loggedIn"`
+		Current  bool   `json:"current"`
+	}
+
+	views := []profileView{}
+	var rows [][]string
+	for _, name := range a.config.ProfileNames() {
+		p := a.config.Profiles[name]
+		v := profileView{name, p.Server, p.Email, p.Token != "", name == a.config.CurrentProfile}
+		views = append(views, v)
+
+		marker := ""
+		if v.Current {
+			marker = "*"
+		}
+		rows = append(rows, []string{marker, name, p.Server, p.Email, strconv.FormatBool(v.LoggedIn)})
+	}
+	return a.print(views, []string{"", "NAME", "SERVER", "EMAIL", "LOGGED IN"}, rows)
+}
+
+func runProfilesUse(ctx context.Context, a *app, args []string) error {
+	fs := a.newFlagSet("profiles use")
+	rest, err := a.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	if _, err := a.config.Profile(rest[0]); err != nil {
+		return err
+	}
+	a.config.CurrentProfile = rest[0]
+	return a.config.Save()
+}
+
+// runConfigSet sets a setting of the profile, creating it if needed, e.g.
+// to point a new profile at its server before registering.
+func runConfigSet(ctx context.Context, a *app, args []string) error {
+	fs := a.newFlagSet("config set")
+	rest, err := a.parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	key, value := rest[0], rest[1]
+	if key != "server" && key != "email" {
+		return fmt.Errorf("unknown setting %q: must be server or email", key)
+	}
+	p := a.config.CreateProfile(a.profile)
+	if key == "server" {
+		p.Server = value
+	} else {
+		p.Email = value
+	}
+	return a.config.Save()
+}
+
+var statuses = []string{client.StatusTodo, client.StatusInProgress, client.StatusInTesting, client.StatusDone}
+
+// parseStatus accepts statuses in any case and with dashes, e.g. in-progress.
+func parseStatus(s string) (string, error) {
+	status := strings.ToUpper(strings.ReplaceAll(s, "-", "_"))
+	for _, valid := range statuses {
+		if status == valid {
+			return status, nil
+		}
+	}
+	return "", fmt.Errorf("invalid status %q: must be one of %s", s, strings.Join(statuses, ", "))
+}
+
+func parseID(s string) (int64, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid ID %q", s)
+	}
+	return id, nil
+}
+
+func (a *app) prompt(label string) (string, error) {
+	fmt.Fprint(a.stderr, label)
+	if a.lines == nil {
+		a.lines = bufio.NewReader(a.stdin)
+	}
+	line, err := a.lines.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// readPassword reads from stdin when asked to (for scripts), otherwise it
+// prompts on the terminal without echoing.
+func (a *app) readPassword(fromStdin bool) (string, error) {
+	if fromStdin {
+		return a.prompt("")
+	}
+
+	f, ok := a.stdin.(*os.File)
+	if !ok || !term.IsTerminal(int(f.Fd())) {
+		return "", errors.New("no terminal to prompt for a password, use --password-stdin")
+	}
+
+	fmt.Fprint(a.stderr, "Password: ")
+	b, err := term.ReadPassword(int(f.Fd()))
+	fmt.Fprintln(a.stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
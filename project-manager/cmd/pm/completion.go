@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+func runCompletion(ctx context.Context, a *app, args []string) error {
+	fs := a.newFlagSet("completion")
+	rest, err := a.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	switch rest[0] {
+	case "bash":
+		fmt.Fprint(a.stdout, bashCompletion())
+	case "zsh":
+		fmt.Fprint(a.stdout, "autoload -U +X bashcompinit && bashcompinit\n"+bashCompletion())
+	default:
+		return fmt.Errorf("unsupported shell %q: must be bash or zsh", rest[0])
+	}
+	return nil
+}
+
+// bashCompletion completes command names from the command table, plus
+// statuses for "tasks move" and profile names for "profiles use".
+func bashCompletion() string {
+	var top []string
+	var cases strings.Builder
+	for _, c := range commands {
+		top = append(top, c.name)
+		if len(c.sub) == 0 {
+			continue
+		}
+		var sub []string
+		for _, s := range c.sub {
+			sub = append(sub, s.name)
+		}
+		fmt.Fprintf(&cases, "        %s) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", c.name, strings.Join(sub, " "))
+	}
+
+	return fmt.Sprintf(`# pm completion, load with: source <(pm completion bash)
+_pm() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    if [[ $cur == -* ]]; then
+        COMPREPLY=($(compgen -W "--output --profile --help" -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[COMP_CWORD-1]} == --output || ${COMP_WORDS[COMP_CWORD-1]} == -o ]]; then
+        COMPREPLY=($(compgen -W "json table" -- "$cur"))
+        return
+    fi
+    case "$COMP_CWORD" in
+    1) COMPREPLY=($(compgen -W %q -- "$cur")) ;;
+    2)
+        case "${COMP_WORDS[1]}" in
+%s        esac ;;
+    *)
+        if [[ ${COMP_WORDS[1]} == tasks && ${COMP_WORDS[2]} == move && $COMP_CWORD -eq 4 ]]; then
+            COMPREPLY=($(compgen -W %q -- "$cur"))
+        elif [[ ${COMP_WORDS[1]} == profiles && ${COMP_WORDS[2]} == use ]]; then
+            COMPREPLY=($(compgen -W "$(pm profiles ls -o json 2>/dev/null | sed -n 's/.*"name": "\(.*\)".*/\1/p')" -- "$cur"))
+        fi ;;
+    esac
+}
+complete -F _pm pm
+`, strings.Join(top, " "), cases.String(), strings.Join(statuses, " "))
+}
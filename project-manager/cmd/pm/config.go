@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const defaultServer = "http://127.0.0.1:3000"
+
+// Config is stored per user, by default in $XDG_CONFIG_HOME/pm/config.json.
+// It holds bearer tokens so it is written with 0600 permissions.
+type Config struct {
+	CurrentProfile string              `json:"currentProfile"`
+	Profiles       map[string]*Profile `json:"profiles"`
+
+	path string
+}
+
+type Profile struct {
+	Server string `json:"server"`
+	Email  string `json:"email,omitempty"`
+	Token  string `json:"token,omitempty"`
+}
+
+func configPath() (string, error) {
+	if p := os.Getenv("PM_CONFIG"); p != "" {
+		return p, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pm", "config.json"), nil
+}
+
+func loadConfig(path string) (*Config, error) {
+	cfg := &Config{
+		CurrentProfile: "default",
+		Profiles:       map[string]*Profile{},
+		path:           path,
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = map[string]*Profile{}
+	}
+	return cfg, nil
+}
+
+func (c *Config) Save() error {
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write then rename so a crash never leaves a truncated config.
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// Profile returns the named profile. An empty name means the current
+// profile. A misspelled --profile must not fall back to a fresh profile
+// pointing at the default server, so unknown names are an error.
+func (c *Config) Profile(name string) (*Profile, error) {
+	if name == "" {
+		name = c.CurrentProfile
+	}
+
+	p, ok := c.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q, create it with: pm login --profile %s", name, name)
+	}
+	return p, nil
+}
+
+// CreateProfile returns the named profile, creating it if needed. An empty
+// name means the current profile.
+func (c *Config) CreateProfile(name string) *Profile {
+	if name == "" {
+		name = c.CurrentProfile
+	}
+
+	p, ok := c.Profiles[name]
+	if !ok {
+		p = &Profile{Server: defaultServer}
+		c.Profiles[name] = p
+	}
+	return p
+}
+
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
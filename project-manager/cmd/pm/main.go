@@ -0,0 +1,210 @@
+// Command pm manages project-manager projects and tasks from the terminal.
+//
+//	pm login --server http://127.0.0.1:3000 --email me@example.com
+//	pm projects ls
+//	pm tasks create --project 1 --name "Write docs"
+//	pm tasks move 42 IN_PROGRESS
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/ZiadMansourM/project-manager/client"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, a *app, args []string) error
+	sub   []*command
+}
+
+// commands is filled in by init because completion walks it.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "login", usage: "login [--server URL] [--email EMAIL] [--password-stdin]", run: runLogin},
+		{name: "logout", usage: "logout", run: runLogout},
+		{name: "register", usage: "register --email EMAIL [--first-name NAME] [--last-name NAME] [--password-stdin]", run: runRegister},
+		{name: "whoami", usage: "whoami", run: runWhoami},
+		{name: "projects", sub: []*command{
+			{name: "ls", usage: "projects ls", run: runProjectsList},
+			{name: "create", usage: "projects create NAME", run: runProjectsCreate},
+		}},
+		{name: "tasks", sub: []*command{
+			{name: "ls", usage: "tasks ls --project ID", run: runTasksList},
+			{name: "create", usage: "tasks create --project ID --name NAME [--status STATUS] [--assignee USER_ID]", run: runTasksCreate},
+			{name: "show", usage: "tasks show ID", run: runTasksShow},
+			{name: "move", usage: "tasks move ID STATUS", run: runTasksMove},
+		}},
+		{name: "tokens", sub: []*command{
+			{name: "ls", usage: "tokens ls", run: runTokensList},
+			{name: "create", usage: "tokens create NAME --scopes tasks:read,tasks:write,projects:admin [--expires-in-days N]", run: runTokensCreate},
+			{name: "revoke", usage: "tokens revoke ID", run: runTokensRevoke},
+		}},
+		{name: "profiles", sub: []*command{
+			{name: "ls", usage: "profiles ls", run: runProfilesList},
+			{name: "use", usage: "profiles use NAME", run: runProfilesUse},
+		}},
+		{name: "config", sub: []*command{
+			{name: "set", usage: "config set server|email VALUE", run: runConfigSet},
+		}},
+		{name: "completion", usage: "completion bash|zsh", run: runCompletion},
+	}
+}
+
+// app carries the state shared by every command. Flags common to all
+// commands are registered by newFlagSet so they may appear anywhere.
+type app struct {
+	config  *Config
+	profile string
+	output  string
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+	// lines buffers stdin across prompts, e.g. a password and then a code.
+	lines *bufio.Reader
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	a := &app{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}
+	if err := a.run(ctx, os.Args[1:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintln(os.Stderr, "pm:", err)
+		os.Exit(1)
+	}
+}
+
+func (a *app) run(ctx context.Context, args []string) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	a.config, err = loadConfig(path)
+	if err != nil {
+		return err
+	}
+	a.profile = os.Getenv("PM_PROFILE")
+	a.output = "table"
+
+	cmds := commands
+	var prefix []string
+	for {
+		if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+			a.usage(cmds)
+			return nil
+		}
+
+		cmd := findCommand(cmds, args[0])
+		if cmd == nil {
+			a.usage(cmds)
+			return fmt.Errorf("unknown command %q", strings.Join(append(prefix, args[0]), " "))
+		}
+
+		prefix = append(prefix, args[0])
+		args = args[1:]
+		if cmd.run != nil {
+			return cmd.run(ctx, a, args)
+		}
+		cmds = cmd.sub
+	}
+}
+
+func findCommand(cmds []*command, name string) *command {
+	for _, c := range cmds {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (a *app) usage(cmds []*command) {
+	fmt.Fprintln(a.stderr, "Usage:")
+	var walk func([]*command)
+	walk = func(cmds []*command) {
+		for _, c := range cmds {
+			if c.run != nil {
+				fmt.Fprintf(a.stderr, "  pm %s\n", c.usage)
+			}
+			walk(c.sub)
+		}
+	}
+	walk(cmds)
+	fmt.Fprintln(a.stderr, "\nGlobal flags:\n  -o, --output json|table\n  --profile NAME   (default: current profile, or $PM_PROFILE)")
+}
+
+func (a *app) newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("pm "+name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	fs.StringVar(&a.output, "output", a.output, "output format: json or table")
+	fs.StringVar(&a.output, "o", a.output, "shorthand for --output")
+	fs.StringVar(&a.profile, "profile", a.profile, "server profile to use")
+	return fs
+}
+
+// parse parses flags, which may be mixed with positional arguments, and
+// checks the number of positional arguments left.
+func (a *app) parse(fs *flag.FlagSet, args []string, positional int) ([]string, error) {
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if a.output != "json" && a.output != "table" {
+		return nil, fmt.Errorf("invalid --output %q: must be json or table", a.output)
+	}
+	if len(rest) != positional {
+		fs.Usage()
+		return nil, fmt.Errorf("expected %d argument(s), got %d", positional, len(rest))
+	}
+	return rest, nil
+}
+
+func (a *app) currentProfile() (*Profile, error) {
+	return a.config.Profile(a.profile)
+}
+
+// client returns an API client for the current profile, failing early when
+// the profile has never been logged in. $PM_TOKEN, e.g. a personal access
+// token in CI, takes precedence over the profile's session.
+func (a *app) client() (*client.Client, error) {
+	p, err := a.currentProfile()
+	if err != nil {
+		return nil, err
+	}
+	token := p.Token
+	if t := os.Getenv("PM_TOKEN"); t != "" {
+		token = t
+	}
+	if token == "" {
+		return nil, errors.New("not logged in, run: pm login")
+	}
+	return client.New(p.Server, client.WithToken(token), client.WithUserAgent("pm"))
+}
+
+// explain turns an expired session into an actionable message.
+func explain(err error) error {
+	if errors.Is(err, client.ErrUnauthorized) {
+		return fmt.Errorf("%w (session expired? run: pm login)", err)
+	}
+	return err
+}
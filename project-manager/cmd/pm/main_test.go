@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newFakeAPI(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/users/login", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"token": "secret-token"})
+	})
+	mux.HandleFunc("PATCH /api/v1/tasks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		json.NewEncoder(w).Encode(map[string]any{"id": 42, "name": "Write docs", "status": body["status"], "projectID": 1})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func runPM(t *testing.T, stdin string, args ...string) string {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	a := &app{stdin: strings.NewReader(stdin), stdout: &stdout, stderr: &stderr}
+	if err := a.run(context.Background(), args); err != nil {
+		t.Fatalf("pm %s: %v\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.String()
+}
+
+func TestLoginAndMoveTask(t *testing.T) {
+	server := newFakeAPI(t)
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	t.Setenv("PM_CONFIG", configFile)
+
+	runPM(t, "hunter2\n", "login", "--profile", "staging", "--server", server.URL, "--email", "me@example.com", "--password-stdin")
+
+	info, err := os.Stat(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected config to be private, got %v", info.Mode().Perm())
+	}
+
+	out := runPM(t, "", "tasks", "move", "42", "in-progress", "--profile", "staging", "-o", "json")
+
+	var task map[string]any
+	if err := json.Unmarshal([]byte(out), &task); err != nil {
+		t.Fatalf("Expected JSON output, got %q", out)
+	}
+	if task["status"] != "IN_PROGRESS" {
+		t.Errorf("Expected IN_PROGRESS, got %v", task["status"])
+	}
+
+	out = runPM(t, "", "tasks", "move", "42", "DONE", "--profile", "staging")
+	if !strings.HasPrefix(out, "ID") || !strings.Contains(out, "DONE") {
+		t.Errorf("Expected a table, got %q", out)
+	}
+}
+
+func TestNotLoggedIn(t *testing.T) {
+	t.Setenv("PM_CONFIG", filepath.Join(t.TempDir(), "config.json"))
+
+	a := &app{stdin: strings.NewReader(""), stdout: &bytes.Buffer{}, stderr: &bytes.Buffer{}}
+	err := a.run(context.Background(), []string{"projects", "ls"})
+	if err == nil || !strings.Contains(err.Error(), "pm login") {
+		t.Errorf("Expected a hint to log in, got %v", err)
+	}
+}
+
+func TestLoginWithMFA(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/users/login", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"mfaRequired": true, "mfaToken": "challenge"})
+	})
+	mux.HandleFunc("POST /api/v1/users/login/mfa", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["mfaToken"] != "challenge" || body["code"] != "123456" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "secret-token"})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	t.Setenv("PM_CONFIG", configFile)
+
+	runPM(t, "hunter2\n123456\n", "login", "--server", server.URL, "--email", "me@example.com", "--password-stdin")
+
+	b, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "secret-token") {
+		t.Errorf("Expected the session token to be saved, got %s", b)
+	}
+}
+
+func TestUnknownProfile(t *testing.T) {
+	server := newFakeAPI(t)
+	t.Setenv("PM_CONFIG", filepath.Join(t.TempDir(), "config.json"))
+	runPM(t, "hunter2\n", "login", "--profile", "staging", "--server", server.URL, "--email", "me@example.com", "--password-stdin")
+
+	for _, args := range [][]string{
+		{"tasks", "move", "42", "DONE", "--profile", "stagign"},
+		{"logout", "--profile", "stagign"},
+		{"profiles", "use", "stagign"},
+	} {
+		a := &app{stdin: strings.NewReader(""), stdout: &bytes.Buffer{}, stderr: &bytes.Buffer{}}
+		err := a.run(context.Background(), args)
+		if err == nil || !strings.Contains(err.Error(), `unknown profile "stagign"`) {
+			t.Errorf("Expected pm %s to fail, got %v", strings.Join(args, " "), err)
+		}
+	}
+
+	runPM(t, "", "config", "set", "server", server.URL, "--profile", "prod")
+	out := runPM(t, "", "profiles", "ls", "-o", "json")
+	if !strings.Contains(out, `"prod"`) || strings.Contains(out, "stagign") {
+		t.Errorf("Expected only config set to create a profile, got %s", out)
+	}
+}
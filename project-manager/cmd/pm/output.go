@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ZiadMansourM/project-manager/client"
+)
+
+var taskColumns = []string{"ID", "NAME", "STATUS", "PROJECT", "ASSIGNEE"}
+
+// print writes v as indented JSON, or headers and rows as an aligned table,
+// depending on --output.
+func (a *app) print(v any, headers []string, rows [][]string) error {
+	if a.output == "json" {
+		enc := json.NewEncoder(a.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(a.stdout, 0, 0, 2, ' ', 0)
+	tw.Write([]byte(strings.Join(headers, "\t") + "\n"))
+	for _, row := range rows {
+		tw.Write([]byte(strings.Join(row, "\t") + "\n"))
+	}
+	return tw.Flush()
+}
+
+func taskRow(t *client.Task) []string {
+	return []string{itoa(t.ID), t.Name, t.Status, itoa(t.ProjectID), itoa(t.AssignedToID)}
+}
+
+func userRow(u *client.User) []string {
+	return []string{itoa(u.ID), u.Email, strings.TrimSpace(u.FirstName + " " + u.LastName)}
+}
+
+func itoa(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
@@ -2,30 +2,180 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
+	// AppEnv is "development" or "production". Production refuses to start
+	// with the default or weak secrets, see checkSecrets.
+	AppEnv        string
 	ListenAddress string
 	Port          string
 	DBUser        string
 	DBPassword    string
 	DBAddress     string
 	DBName        string
-	JWTSecret     string
+	// JWTSecret is the secret at startup, it's replaced on SIGHUP. Use
+	// currentJWTSecret.
+	JWTSecret string
+	// Asymmetric token signing, see KeySet. Only while JWTAcceptHS256 is
+	// set, tokens signed with JWTSecret before the switch keep working.
+	JWTKeysDir      string
+	JWTSigningKeyID string
+	JWTAcceptHS256  bool
+	// Set SESSION_COOKIE_SECURE=false only when serving plain HTTP on a
+	// host browsers don't treat as secure.
+	SessionCookieSecure bool
+	// PublicURL is where users reach the server, used in mailed links.
+	PublicURL string
+	// Registration password policy. BreachedPasswordsFile is optional.
+	PasswordMinLength     int
+	BreachedPasswordsFile string
+	// Mailer is "log", "file" (one .eml per message in MailDir) or "smtp".
+	Mailer       string
+	MailFrom     string
+	MailDir      string
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	// API v1 deprecation policy, dates are YYYY-MM-DD. The deprecation is
+	// only announced once API_V1_DEPRECATED_AT is set.
+	APIV1DeprecatedAt    string
+	APIV1Sunset          string
+	APIV1DeprecationLink string
+	// OpenID Connect single sign-on, enabled when OIDC_ISSUER is set.
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCScopes       []string
+	// Brute-force protection, see LoginGuard. The lockout doubles with every
+	// failure past the limit. LoginFailureDelay slows down failed attempts
+	// before the limit is reached, 0 turns it off.
+	LoginMaxFailures      int
+	LoginMaxFailuresPerIP int
+	LoginLockout          time.Duration
+	LoginFailureDelay     time.Duration
+	// TrustProxyHeaders takes the client IP from X-Forwarded-For. Only
+	// enable it behind a proxy that sets the header.
+	TrustProxyHeaders bool
+	// OpenTelemetry tracing, see NewTracerProvider. Spans are exported in
+	// batches of up to TraceBatchSize at least every TraceBatchTimeout.
+	OTLPEndpoint      string
+	OTLPInsecure      bool
+	TraceSampleRatio  float64
+	TraceBatchTimeout time.Duration
+	TraceBatchSize    int
+	// Rate limits per user, or per IP before sign in, see NewRateLimiter.
+	// The sign in, registration and password reset routes share the
+	// stricter AuthRateLimit. Limits are kept in memory unless RedisAddr
+	// is set, so replicas share them.
+	RateLimit       int
+	RateLimitWindow time.Duration
+	AuthRateLimit   int
+	RedisAddr       string
+	RedisPassword   string
+	// Origins of browser apps allowed to call the API, exact or with one
+	// "*" like https://*.example.com, see CORSMiddleware. Credentials are
+	// only needed for the session cookie, bearer tokens work without.
+	CORSAllowedOrigins   []string
+	CORSAllowCredentials bool
+	CORSMaxAge           time.Duration
+	// Responses of at least CompressMinSize bytes are compressed when the
+	// client accepts it.
+	CompressMinSize int
+	// Request bodies over MaxBodyBytes are refused, and handlers taking
+	// longer than HandlerTimeout are answered 503, see NewRouteLimits.
+	MaxBodyBytes   int64
+	HandlerTimeout time.Duration
+	// http.Server timeouts. WriteTimeout must leave handlers their
+	// HandlerTimeout; event streams lift it themselves.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// DebugAddress serves /metrics and /debug/vars, away from API clients.
+	// Empty disables it.
+	DebugAddress string
 }
 
 var Envs = initConfig()
 
 func initConfig() Config {
 	return Config{
+		AppEnv:        getEnv("APP_ENV", "development"),
 		ListenAddress: getEnv("LISTEN_ADDRESS", "127.0.0.1"),
 		Port:          getEnv("PORT", "3000"),
 		DBUser:        getEnv("DB_USER", "root"),
-		DBPassword:    getEnv("DB_PASSWORD", "P@ssw0rd"),
+		DBPassword:    getSecret("DB_PASSWORD", defaultDBPassword),
 		DBAddress:     fmt.Sprintf("%s:%s", getEnv("DB_HOST", "127.0.0.1"), getEnv("DB_PORT", "3306")),
 		DBName:        getEnv("DB_NAME", "project-manager"),
-		JWTSecret:     getEnv("JWT_SECRET", "2xFavbztyHyRVFxuWrwtPtSQuwuQ1Y9i"),
+		JWTSecret:     getSecret("JWT_SECRET", defaultJWTSecret),
+
+		JWTKeysDir:      getEnv("JWT_KEYS_DIR", ""),
+		JWTSigningKeyID: getEnv("JWT_SIGNING_KEY_ID", ""),
+		JWTAcceptHS256:  getEnv("JWT_ACCEPT_HS256", "false") == "true",
+
+		SessionCookieSecure: getEnv("SESSION_COOKIE_SECURE", "true") == "true",
+		PublicURL:           getEnv("PUBLIC_URL", "http://127.0.0.1:3000"),
+
+		PasswordMinLength:     getEnvInt("PASSWORD_MIN_LENGTH", 10),
+		BreachedPasswordsFile: getEnv("BREACHED_PASSWORDS_FILE", ""),
+
+		Mailer:       getEnv("MAILER", "log"),
+		MailFrom:     getEnv("MAIL_FROM", "Project Manager <no-reply@localhost>"),
+		MailDir:      getEnv("MAIL_DIR", "mail"),
+		SMTPAddr:     getEnv("SMTP_ADDR", "127.0.0.1:25"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getSecret("SMTP_PASSWORD", ""),
+
+		APIV1DeprecatedAt:    getEnv("API_V1_DEPRECATED_AT", ""),
+		APIV1Sunset:          getEnv("API_V1_SUNSET", ""),
+		APIV1DeprecationLink: getEnv("API_V1_DEPRECATION_LINK", "https://github.com/ZiadMansourM/go-playground/tree/main/project-manager#api-v2"),
+
+		OIDCIssuer:       getEnv("OIDC_ISSUER", ""),
+		OIDCClientID:     getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret: getSecret("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:  getEnv("OIDC_REDIRECT_URL", "http://127.0.0.1:3000/auth/oidc/callback"),
+		OIDCScopes:       strings.Fields(getEnv("OIDC_SCOPES", "openid email profile")),
+
+		LoginMaxFailures:      getEnvInt("LOGIN_MAX_FAILURES", 5),
+		LoginMaxFailuresPerIP: getEnvInt("LOGIN_MAX_FAILURES_PER_IP", 20),
+		LoginLockout:          getEnvDuration("LOGIN_LOCKOUT", time.Minute),
+		LoginFailureDelay:     getEnvDuration("LOGIN_FAILURE_DELAY", 0),
+		TrustProxyHeaders:     getEnv("TRUST_PROXY_HEADERS", "false") == "true",
+
+		OTLPEndpoint:      getEnv("OTLP_ENDPOINT", ""),
+		OTLPInsecure:      getEnv("OTLP_INSECURE", "false") == "true",
+		TraceSampleRatio:  getEnvFloat("TRACE_SAMPLE_RATIO", 1),
+		TraceBatchTimeout: getEnvDuration("TRACE_BATCH_TIMEOUT", 5*time.Second),
+		TraceBatchSize:    getEnvInt("TRACE_BATCH_SIZE", 512),
+
+		RateLimit:       getEnvInt("RATE_LIMIT", 600),
+		RateLimitWindow: getEnvDuration("RATE_LIMIT_WINDOW", time.Minute),
+		AuthRateLimit:   getEnvInt("AUTH_RATE_LIMIT", 30),
+		RedisAddr:       getEnv("REDIS_ADDR", ""),
+		RedisPassword:   getSecret("REDIS_PASSWORD", ""),
+
+		CORSAllowedOrigins:   strings.Fields(getEnv("CORS_ALLOWED_ORIGINS", "")),
+		CORSAllowCredentials: getEnv("CORS_ALLOW_CREDENTIALS", "false") == "true",
+		CORSMaxAge:           getEnvDuration("CORS_MAX_AGE", 10*time.Minute),
+
+		CompressMinSize: getEnvInt("COMPRESS_MIN_SIZE", 1024),
+
+		MaxBodyBytes:   int64(getEnvInt("MAX_BODY_BYTES", 1<<20)),
+		HandlerTimeout: getEnvDuration("HANDLER_TIMEOUT", 15*time.Second),
+
+		ReadHeaderTimeout: getEnvDuration("READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       getEnvDuration("READ_TIMEOUT", 30*time.Second),
+		WriteTimeout:      getEnvDuration("WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:       getEnvDuration("IDLE_TIMEOUT", 2*time.Minute),
+
+		DebugAddress: getEnv("DEBUG_ADDRESS", "127.0.0.1:9091"),
 	}
 }
 
@@ -35,3 +185,55 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// getSecret is getEnv for secrets, which can also be read from the file
+// named by key+"_FILE", see lookupSecret.
+func getSecret(key, fallback string) string {
+	value, ok, err := lookupSecret(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !ok {
+		return fallback
+	}
+	return value
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s must be an integer: %v", key, err)
+	}
+	return n
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Fatalf("%s must be a number: %v", key, err)
+	}
+	return f
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s must be a duration like 30s or 5m: %v", key, err)
+	}
+	return d
+}
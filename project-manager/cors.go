@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/ZiadMansourM/middleware/middleware"
+)
+
+// CORSMiddleware lets browser apps on c.CORSAllowedOrigins call the API.
+// It answers preflights itself, so keep it before RequireAuthMiddleware:
+// browsers never send the Authorization header with a preflight.
+func CORSMiddleware(c Config) Middleware {
+	return Middleware(middleware.CORS(middleware.CORSOptions{
+		AllowedOrigins: c.CORSAllowedOrigins,
+		AllowedMethods: []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+		AllowedHeaders: []string{"Authorization", "Content-Type", "Last-Event-ID", csrfHeader, "traceparent", "tracestate"},
+		ExposedHeaders: []string{
+			"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After",
+			"Deprecation", "Sunset", "Link",
+		},
+		AllowCredentials: c.CORSAllowCredentials,
+		MaxAge:           c.CORSMaxAge,
+	}))
+}
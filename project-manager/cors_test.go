@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSPreflightSkipsAuth(t *testing.T) {
+	origins := Envs.CORSAllowedOrigins
+	t.Cleanup(func() { Envs.CORSAllowedOrigins = origins })
+	Envs.CORSAllowedOrigins = []string{"https://app.example.com"}
+
+	api, _, err := NewAPIServer("", newMemoryStore()).Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/v1/tasks/1", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPatch)
+	req.Header.Set("Access-Control-Request-Headers", "authorization,content-type")
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("Expected the preflight to be allowed, got %d %v", rec.Code, rec.Header())
+	}
+
+	// The SPA must be able to read why a request failed.
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/tasks/1", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rec = httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("Expected a 401 with CORS headers, got %d %v", rec.Code, rec.Header())
+	}
+}
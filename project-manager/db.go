@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 
 	"github.com/go-sql-driver/mysql"
@@ -30,6 +31,32 @@ func (s *MySQLStorage) Init() (*sql.DB, error) {
 	if err := s.createUsersTable(); err != nil {
 		return nil, err
 	}
+	if err := s.createAuditLogTable(); err != nil {
+		return nil, err
+	}
+	if err := s.createUserIdentitiesTable(); err != nil {
+		return nil, err
+	}
+	if err := s.createUserTokensTable(); err != nil {
+		return nil, err
+	}
+	if err := s.createUserTOTPTable(); err != nil {
+		return nil, err
+	}
+	if err := s.createRecoveryCodesTable(); err != nil {
+		return nil, err
+	}
+	if err := s.createAccessTokensTable(); err != nil {
+		return nil, err
+	}
+
+	if err := s.createPasskeysTable(); err != nil {
+		return nil, err
+	}
+
+	if err := s.createWebAuthnChallengesTable(); err != nil {
+		return nil, err
+	}
 	if err := s.createProjectsTable(); err != nil {
 		return nil, err
 	}
@@ -44,13 +71,18 @@ func (s *MySQLStorage) createProjectsTable() error {
 		CREATE TABLE IF NOT EXISTS projects (
 			id INT UNSIGNED NOT NULL AUTO_INCREMENT,
 			name VARCHAR(255) NOT NULL,
+			ownerID INT UNSIGNED NULL,
 			createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			
 			PRIMARY KEY (id)
 		) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 	`)
+	if err != nil {
+		return err
+	}
 
-	return err
+	// Tables created by older versions.
+	return s.addColumnIfMissing("projects", "ownerID", "INT UNSIGNED NULL AFTER name")
 }
 
 func (s *MySQLStorage) createTasksTable() error {
@@ -80,12 +112,206 @@ func (s *MySQLStorage) createUsersTable() error {
 			firstName VARCHAR(255) NOT NULL,
 			lastName VARCHAR(255) NOT NULL,
 			password VARCHAR(255) NOT NULL,
+			status ENUM('pending', 'active') NOT NULL DEFAULT 'active',
+			role ENUM('user', 'admin') NOT NULL DEFAULT 'user',
+			sessionsRevokedAt TIMESTAMP NULL,
+			failedLogins INT UNSIGNED NOT NULL DEFAULT 0,
+			lastFailedLoginAt TIMESTAMP NULL,
+			lockedUntil TIMESTAMP NULL,
 			createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 
 			PRIMARY KEY (id),
 			UNIQUE KEY (email)
 		) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 	`)
+	if err != nil {
+		return err
+	}
+
+	// Tables created by older versions.
+	if err := s.addColumnIfMissing("users", "status", "ENUM('pending', 'active') NOT NULL DEFAULT 'active' AFTER password"); err != nil {
+		return err
+	}
+	for _, c := range []struct{ name, definition string }{
+		{"sessionsRevokedAt", "TIMESTAMP NULL AFTER status"},
+		{"role", "ENUM('user', 'admin') NOT NULL DEFAULT 'user' AFTER status"},
+		{"failedLogins", "INT UNSIGNED NOT NULL DEFAULT 0 AFTER sessionsRevokedAt"},
+		{"lastFailedLoginAt", "TIMESTAMP NULL AFTER failedLogins"},
+		{"lockedUntil", "TIMESTAMP NULL AFTER lastFailedLoginAt"},
+	} {
+		if err := s.addColumnIfMissing("users", c.name, c.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumnIfMissing upgrades tables created by older versions, since
+// MySQL has no ADD COLUMN IF NOT EXISTS.
+func (s *MySQLStorage) addColumnIfMissing(table, column, definition string) error {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?", table, column).Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+
+	_, err = s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+func (s *MySQLStorage) createUserIdentitiesTable() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_identities (
+			id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+			userId INT UNSIGNED NOT NULL,
+			issuer VARCHAR(255) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+			PRIMARY KEY (id),
+			UNIQUE KEY (issuer, subject),
+			FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+	`)
+
+	return err
+}
+
+func (s *MySQLStorage) createUserTokensTable() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_tokens (
+			id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+			userId INT UNSIGNED NOT NULL,
+			purpose VARCHAR(32) NOT NULL,
+			tokenHash CHAR(64) NOT NULL,
+			attempts INT UNSIGNED NOT NULL DEFAULT 0,
+			expiresAt TIMESTAMP NOT NULL,
+			usedAt TIMESTAMP NULL,
+			createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+			PRIMARY KEY (id),
+			UNIQUE KEY (tokenHash),
+			FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Tables created by older versions.
+	return s.addColumnIfMissing("user_tokens", "attempts", "INT UNSIGNED NOT NULL DEFAULT 0 AFTER tokenHash")
+}
+
+func (s *MySQLStorage) createUserTOTPTable() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_totp (
+			userId INT UNSIGNED NOT NULL,
+			secret VARCHAR(64) NOT NULL,
+			confirmedAt TIMESTAMP NULL,
+			lastCounter BIGINT NOT NULL DEFAULT 0,
+			createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+			PRIMARY KEY (userId),
+			FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+	`)
+
+	return err
+}
+
+func (s *MySQLStorage) createRecoveryCodesTable() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+			id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+			userId INT UNSIGNED NOT NULL,
+			codeHash CHAR(64) NOT NULL,
+			usedAt TIMESTAMP NULL,
+			createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+			PRIMARY KEY (id),
+			UNIQUE KEY (userId, codeHash),
+			FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+	`)
+
+	return err
+}
+
+func (s *MySQLStorage) createAccessTokensTable() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS personal_access_tokens (
+			id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+			userId INT UNSIGNED NOT NULL,
+			name VARCHAR(100) NOT NULL,
+			scopes VARCHAR(255) NOT NULL,
+			tokenHash CHAR(64) NOT NULL,
+			expiresAt TIMESTAMP NOT NULL,
+			lastUsedAt TIMESTAMP NULL,
+			createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+			PRIMARY KEY (id),
+			UNIQUE KEY (tokenHash),
+			FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+	`)
+
+	return err
+}
+
+func (s *MySQLStorage) createPasskeysTable() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS passkeys (
+			id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+			userId INT UNSIGNED NOT NULL,
+			name VARCHAR(100) NOT NULL,
+			credentialId VARBINARY(255) NOT NULL,
+			publicKey BLOB NOT NULL,
+			signCount INT UNSIGNED NOT NULL DEFAULT 0,
+			lastUsedAt TIMESTAMP NULL,
+			createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+			PRIMARY KEY (id),
+			UNIQUE KEY (credentialId),
+			FOREIGN KEY (userId) REFERENCES users(id) ON DELETE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+	`)
+
+	return err
+}
+
+// webauthn_challenges has no foreign key: sign-in challenges are issued
+// before the user is known, with userId 0.
+func (s *MySQLStorage) createWebAuthnChallengesTable() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS webauthn_challenges (
+			challengeHash CHAR(64) NOT NULL,
+			userId INT UNSIGNED NOT NULL,
+			ceremony VARCHAR(32) NOT NULL,
+			expiresAt TIMESTAMP NOT NULL,
+
+			PRIMARY KEY (challengeHash),
+			KEY (expiresAt)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+	`)
+
+	return err
+}
+
+func (s *MySQLStorage) createAuditLogTable() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+			action VARCHAR(64) NOT NULL,
+			userId INT UNSIGNED NULL,
+			actorId INT UNSIGNED NULL,
+			ip VARCHAR(45) NOT NULL DEFAULT '',
+			detail VARCHAR(255) NOT NULL DEFAULT '',
+			createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+			PRIMARY KEY (id),
+			KEY (userId)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+	`)
 
 	return err
 }
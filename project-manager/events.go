@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultHeartbeat = 15 * time.Second
+	wsWriteWait      = 10 * time.Second
+	sseRetry         = 2 * time.Second
+)
+
+// EventsService streams project events over Server-Sent Events, or over a
+// WebSocket when the client asks for an upgrade on the same route. Only
+// members of the project and admins may follow it.
+type EventsService struct {
+	store     Store
+	broker    *EventBroker
+	heartbeat time.Duration
+	upgrader  websocket.Upgrader
+	streams   sync.WaitGroup
+}
+
+func NewEventsService(store Store, broker *EventBroker) *EventsService {
+	return &EventsService{
+		store:     store,
+		broker:    broker,
+		heartbeat: defaultHeartbeat,
+	}
+}
+
+func (s *EventsService) RegisterRoutes(r *http.ServeMux) {
+	r.HandleFunc("GET /projects/{id}/events", s.handleProjectEvents)
+}
+
+// Wait blocks until every open stream has said goodbye, or ctx is done.
+// Hijacked WebSocket connections are not tracked by http.Server.Shutdown.
+func (s *EventsService) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.streams.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *EventsService) handleProjectEvents(w http.ResponseWriter, r *http.Request) {
+	projectID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || projectID <= 0 {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid project ID",
+		})
+		return
+	}
+
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+	allowed, err := canAccessProject(s.store, projectID, userID)
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error checking project membership: " + err.Error(),
+		})
+		return
+	}
+	if !allowed {
+		WriteJson(w, http.StatusForbidden, ErrorResponse{
+			Error: "Forbidden: not a member of this project",
+		})
+		return
+	}
+
+	// EventSource sends Last-Event-ID on reconnect; browser WebSockets cannot
+	// set headers so they pass it as a query parameter instead.
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+	var lastEventID uint64
+	if lastID != "" {
+		lastEventID, err = strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			WriteJson(w, http.StatusBadRequest, ErrorResponse{
+				Error: "Invalid Last-Event-ID: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	sub, backlog, err := s.broker.Subscribe(projectID, lastEventID, lastID != "")
+	if err != nil {
+		WriteJson(w, http.StatusServiceUnavailable, ErrorResponse{
+			Error: "Error subscribing to events: " + err.Error(),
+		})
+		return
+	}
+	defer sub.Close()
+
+	s.streams.Add(1)
+	defer s.streams.Done()
+
+	// Close the stream when the token used to open it expires, the client
+	// must reconnect with a fresh one.
+	var expired <-chan time.Time
+	if info := authInfoFromContext(r.Context()); info != nil && !info.ExpiresAt.IsZero() {
+		timer := time.NewTimer(time.Until(info.ExpiresAt))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	if websocket.IsWebSocketUpgrade(r) {
+		s.serveWebSocket(w, r, sub, backlog, expired)
+		return
+	}
+	s.serveSSE(w, r, sub, backlog, expired)
+}
+
+func (s *EventsService) serveSSE(w http.ResponseWriter, r *http.Request, sub *Subscription, backlog []Event, expired <-chan time.Time) {
+	rc := http.NewResponseController(w)
+	// The stream outlives the server's WriteTimeout, heartbeats notice
+	// when the client is gone.
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+	for _, e := range backlog {
+		if err := writeSSEEvent(w, e); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		log.Printf("SSE stream does not support flushing: %v\n", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(s.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				// Broker closed (shutdown) or we fell behind; the client
+				// reconnects after the retry delay and resumes.
+				fmt.Fprint(w, "event: stream.closed\ndata: {}\n\n")
+				rc.Flush()
+				return
+			}
+			if err := writeSSEEvent(w, e); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-expired:
+			fmt.Fprint(w, "event: auth.expired\ndata: {}\n\n")
+			rc.Flush()
+			return
+		case <-r.Context().Done():
+			return
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeSSEEvent(w http.ResponseWriter, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+func (s *EventsService) serveWebSocket(w http.ResponseWriter, r *http.Request, sub *Subscription, backlog []Event, expired <-chan time.Time) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied to the client.
+		log.Printf("WebSocket upgrade failed: %v\n", err)
+		return
+	}
+	defer conn.Close()
+
+	// The client never sends us anything useful, but we must read to
+	// process pongs and notice when it goes away.
+	gone := make(chan struct{})
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(2 * s.heartbeat))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * s.heartbeat))
+	})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, e := range backlog {
+		if err := writeWSEvent(conn, e); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(s.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				closeWebSocket(conn, websocket.CloseGoingAway, "stream closed, reconnect")
+				return
+			}
+			if err := writeWSEvent(conn, e); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		case <-expired:
+			closeWebSocket(conn, websocket.ClosePolicyViolation, "token expired")
+			return
+		case <-gone:
+			return
+		}
+	}
+}
+
+func writeWSEvent(conn *websocket.Conn, e Event) error {
+	conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return conn.WriteJSON(e)
+}
+
+func closeWebSocket(conn *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteWait))
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestEventBroker(t *testing.T) {
+	t.Run("Resume returns missed events", func(t *testing.T) {
+		b := NewEventBroker()
+		for i := 0; i < 3; i++ {
+			b.Publish(1, EventTaskCreated, nil)
+		}
+
+		sub, backlog, err := b.Subscribe(1, 1, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Close()
+
+		if len(backlog) != 2 || backlog[0].ID != 2 || backlog[1].ID != 3 {
+			t.Errorf("Expected events 2 and 3, got %+v", backlog)
+		}
+	})
+
+	t.Run("Resume past history resets", func(t *testing.T) {
+		b := NewEventBroker()
+		b.historySize = 2
+		for i := 0; i < 5; i++ {
+			b.Publish(1, EventTaskCreated, nil)
+		}
+
+		sub, backlog, err := b.Subscribe(1, 1, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Close()
+
+		if len(backlog) != 1 || backlog[0].Type != EventStreamReset {
+			t.Errorf("Expected a single %s event, got %+v", EventStreamReset, backlog)
+		}
+	})
+
+	t.Run("Close ends subscriptions", func(t *testing.T) {
+		b := NewEventBroker()
+		sub, _, err := b.Subscribe(1, 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b.Close()
+		if _, ok := <-sub.Events(); ok {
+			t.Error("Expected subscription channel to be closed")
+		}
+		if _, _, err := b.Subscribe(1, 0, false); err != errBrokerClosed {
+			t.Errorf("Expected %v, got %v", errBrokerClosed, err)
+		}
+	})
+}
+
+func TestCreateTaskPublishesEvent(t *testing.T) {
+	b := NewEventBroker()
+	sub, _, err := b.Subscribe(1, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	payload, err := json.Marshal(&Task{Name: "Test Task", ProjectID: 1, AssignedToID: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	router := http.NewServeMux()
+	NewTasksService(&MockStore{}, b).RegisterRoutes(router)
+	router.ServeHTTP(rec, req)
+
+	select {
+	case e := <-sub.Events():
+		if e.Type != EventTaskCreated {
+			t.Errorf("Expected %s, got %s", EventTaskCreated, e.Type)
+		}
+	default:
+		t.Error("Expected a task.created event")
+	}
+}
+
+// newEventsServer serves the events of user's projects, as if they were
+// authenticated.
+func newEventsServer(t *testing.T, userID string) (*EventBroker, *EventsService, *httptest.Server) {
+	t.Helper()
+
+	b := NewEventBroker()
+	service := NewEventsService(&MockStore{}, b)
+	router := http.NewServeMux()
+	service.RegisterRoutes(router)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.ServeHTTP(w, r.WithContext(withAuthInfo(r.Context(), &AuthInfo{UserID: userID})))
+	}))
+	t.Cleanup(server.Close)
+	return b, service, server
+}
+
+func TestProjectEventsSSE(t *testing.T) {
+	b, service, server := newEventsServer(t, "42")
+	b.Publish(7, EventTaskCreated, nil)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/projects/7/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", "0")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected text/event-stream, got %q", ct)
+	}
+
+	scanner := bufio.NewScanner(res.Body)
+	readUntil := func(prefix string) string {
+		t.Helper()
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), prefix) {
+				return scanner.Text()
+			}
+		}
+		t.Fatalf("Stream ended before %q", prefix)
+		return ""
+	}
+
+	if line := readUntil("id: "); line != "id: 1" {
+		t.Errorf("Expected replayed event 1, got %q", line)
+	}
+
+	b.Close()
+	readUntil("event: stream.closed")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := service.Wait(ctx); err != nil {
+		t.Errorf("Expected streams to drain, got %v", err)
+	}
+}
+
+func TestProjectEventsWebSocket(t *testing.T) {
+	b, _, server := newEventsServer(t, "42")
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/projects/7/events"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Publish once the subscription is in place, it is registered before
+	// the upgrade completes.
+	b.Publish(7, EventTaskUpdated, nil)
+
+	var e Event
+	if err := conn.ReadJSON(&e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != EventTaskUpdated || e.ID != 1 {
+		t.Errorf("Expected %s with ID 1, got %+v", EventTaskUpdated, e)
+	}
+
+	b.Close()
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("Expected going away close, got %v", err)
+	}
+}
+
+func TestProjectEventsNeedMembership(t *testing.T) {
+	_, _, server := newEventsServer(t, "7")
+
+	res, err := http.Get(server.URL + "/projects/7/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status code %d, got %d", http.StatusForbidden, res.StatusCode)
+	}
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/projects/7/events"
+	_, res, err = websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || res == nil || res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected the upgrade to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// KeySet holds the asymmetric keys tokens are signed and verified with.
+// Each key is a PEM file in a directory, named <kid>.pem. Private keys can
+// sign, public keys only verify, which is how a retired key is kept around
+// until the tokens it signed have expired.
+type KeySet struct {
+	signingKID string
+	signing    any
+	verify     map[string]any
+	kids       []string
+}
+
+// jwtKeys is nil unless JWT_KEYS_DIR is set, in which case tokens are signed
+// with the HMAC secret as before.
+var jwtKeys *KeySet
+
+// LoadKeySet reads every *.pem in dir. The signing key is signingKID or,
+// when empty, the private key whose kid sorts last, so naming keys by date
+// (2026-10-01.pem) makes the newest one active.
+func LoadKeySet(dir, signingKID string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	ks := &KeySet{verify: make(map[string]any)}
+	private := make(map[string]any)
+	lastPrivate := ""
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		priv, pub, err := parseKeyPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", path, err)
+		}
+
+		ks.verify[kid] = pub
+		ks.kids = append(ks.kids, kid)
+		if priv != nil {
+			private[kid] = priv
+			lastPrivate = kid
+		}
+	}
+
+	if len(ks.kids) == 0 {
+		return nil, fmt.Errorf("no *.pem keys in %s", dir)
+	}
+	if signingKID == "" {
+		signingKID = lastPrivate
+	}
+
+	priv, ok := private[signingKID]
+	if !ok {
+		return nil, fmt.Errorf("no private key with kid %q in %s", signingKID, dir)
+	}
+	ks.signingKID = signingKID
+	ks.signing = priv
+	return ks, nil
+}
+
+// parseKeyPEM accepts PKCS#8 and PKCS#1 private keys and PKIX public keys,
+// RSA or Ed25519, as written by `openssl genpkey`. priv is nil for public
+// keys.
+func parseKeyPEM(data []byte) (priv, pub any, err error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		priv, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		priv, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch k := priv.(type) {
+	case nil:
+	case *rsa.PrivateKey:
+		pub = &k.PublicKey
+	case ed25519.PrivateKey:
+		pub = k.Public()
+	default:
+		return nil, nil, fmt.Errorf("unsupported private key type %T", priv)
+	}
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return nil, nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+	case ed25519.PublicKey:
+	default:
+		return nil, nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return priv, pub, nil
+}
+
+func signingMethodFor(key any) jwt.SigningMethod {
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// Sign signs claims with the active key and sets the kid header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(signingMethodFor(ks.signing), claims)
+	token.Header["kid"] = ks.signingKID
+	return token.SignedString(ks.signing)
+}
+
+// Key is a jwt.Keyfunc that picks the verification key by kid and checks
+// the algorithm matches the key type.
+func (ks *KeySet) Key(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	key, ok := ks.verify[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey:
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); ok {
+			return key, nil
+		}
+	case ed25519.PublicKey:
+		if _, ok := t.Method.(*jwt.SigningMethodEd25519); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected signing method %v for key %q", t.Header["alg"], kid)
+}
+
+// JWKS returns every verification key, including retired ones, so other
+// services can verify any token that is still valid.
+func (ks *KeySet) JWKS() []jsonWebKey {
+	keys := make([]jsonWebKey, 0, len(ks.kids))
+	for _, kid := range ks.kids {
+		jwk := jsonWebKey{Kid: kid, Use: "sig"}
+		switch k := ks.verify[kid].(type) {
+		case *rsa.PublicKey:
+			jwk.Kty, jwk.Alg = "RSA", "RS256"
+			jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty, jwk.Alg, jwk.Crv = "OKP", "EdDSA", "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(k)
+		}
+		keys = append(keys, jwk)
+	}
+	return keys
+}
+
+// handleJWKS serves GET /.well-known/jwks.json. Without asymmetric keys the
+// set is empty: HMAC secrets are never published.
+func handleJWKS(w http.ResponseWriter, r *http.Request) {
+	keys := []jsonWebKey{}
+	if jwtKeys != nil {
+		keys = jwtKeys.JWKS()
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	WriteJson(w, http.StatusOK, map[string][]jsonWebKey{"keys": keys})
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func writeKey(t *testing.T, dir, kid string, key any, public bool) {
+	t.Helper()
+
+	var block *pem.Block
+	if public {
+		var pub any
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			pub = &k.PublicKey
+		case ed25519.PrivateKey:
+			pub = k.Public()
+		}
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	} else {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// useKeys installs ks as the process key set for the duration of the test.
+func useKeys(t *testing.T, ks *KeySet) {
+	t.Helper()
+
+	previous := jwtKeys
+	jwtKeys = ks
+	t.Cleanup(func() { jwtKeys = previous })
+}
+
+func TestKeySetRotation(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeKey(t, dir, "2026-01-01", rsaKey, false)
+	ks, err := LoadKeySet(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeys(t, ks)
+
+	oldToken, err := CreateJWT(42, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := new(jwt.Parser).ParseUnverified(oldToken, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Method.Alg() != "RS256" || parsed.Header["kid"] != "2026-01-01" {
+		t.Fatalf("Expected RS256 with kid 2026-01-01, got %s %v", parsed.Method.Alg(), parsed.Header["kid"])
+	}
+
+	t.Run("New key signs, retired key still verifies", func(t *testing.T) {
+		// Rotation: add the new key and keep only the public half of the old.
+		writeKey(t, dir, "2026-01-01", rsaKey, true)
+		writeKey(t, dir, "2026-04-01", edKey, false)
+		ks, err := LoadKeySet(dir, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		useKeys(t, ks)
+
+		newToken, err := CreateJWT(42, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, _, _ := new(jwt.Parser).ParseUnverified(newToken, jwt.MapClaims{})
+		if parsed.Method.Alg() != "EdDSA" || parsed.Header["kid"] != "2026-04-01" {
+			t.Errorf("Expected EdDSA with kid 2026-04-01, got %s %v", parsed.Method.Alg(), parsed.Header["kid"])
+		}
+
+		for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+			if _, err := validateToken(token); err != nil {
+				t.Errorf("Expected the %s token to verify: %v", name, err)
+			}
+		}
+	})
+
+	t.Run("Removed key no longer verifies", func(t *testing.T) {
+		os.Remove(filepath.Join(dir, "2026-01-01.pem"))
+		ks, err := LoadKeySet(dir, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		useKeys(t, ks)
+
+		if _, err := validateToken(oldToken); err == nil {
+			t.Error("Expected a token signed with a removed key to be rejected")
+		}
+	})
+
+	t.Run("Public key cannot be the signing key", func(t *testing.T) {
+		writeKey(t, dir, "2026-01-01", rsaKey, true)
+		if _, err := LoadKeySet(dir, "2026-01-01"); err == nil {
+			t.Error("Expected an error when the signing key has no private half")
+		}
+	})
+
+	t.Run("Rejects an algorithm that doesn't match the key", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"userID": "42"})
+		token.Header["kid"] = "2026-04-01"
+		// The HMAC key is the public key bytes, the classic alg confusion.
+		signed, err := token.SignedString([]byte(edKey.Public().(ed25519.PublicKey)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ks, _ := LoadKeySet(dir, "")
+		if _, err := jwt.Parse(signed, ks.Key); err == nil {
+			t.Error("Expected an HS256 token to be rejected by the key set")
+		}
+	})
+}
+
+func TestLegacyHS256Tokens(t *testing.T) {
+	legacy, err := CreateJWT(42, []byte(Envs.JWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	writeKey(t, dir, "current", edKey, false)
+	ks, err := LoadKeySet(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeys(t, ks)
+
+	accept := Envs.JWTAcceptHS256
+	t.Cleanup(func() { Envs.JWTAcceptHS256 = accept })
+
+	Envs.JWTAcceptHS256 = true
+	if _, err := validateToken(legacy); err != nil {
+		t.Errorf("Expected HS256 tokens to verify during the migration: %v", err)
+	}
+
+	Envs.JWTAcceptHS256 = false
+	if _, err := validateToken(legacy); err == nil {
+		t.Error("Expected HS256 tokens to be rejected once the migration is over")
+	}
+}
+
+func TestJWKSEndpoint(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	writeKey(t, dir, "a", rsaKey, true)
+	writeKey(t, dir, "b", edKey, false)
+	ks, err := LoadKeySet(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeys(t, ks)
+
+	rec := httptest.NewRecorder()
+	handleJWKS(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&set); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Keys) != 2 {
+		t.Fatalf("Expected 2 keys, got %d", len(set.Keys))
+	}
+
+	// Whatever we publish, our own OIDC client must be able to consume.
+	published := map[string]any{}
+	for _, jwk := range set.Keys {
+		pub, err := jwk.publicKey()
+		if err != nil {
+			t.Fatalf("Key %s: %v", jwk.Kid, err)
+		}
+		published[jwk.Kid] = pub
+	}
+
+	token, err := ks.Sign(jwt.MapClaims{"userID": "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = jwt.Parse(token, func(t *jwt.Token) (any, error) { return published[t.Header["kid"].(string)], nil })
+	if err != nil {
+		t.Errorf("Expected the published keys to verify tokens: %v", err)
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/ZiadMansourM/middleware/middleware"
+)
+
+// NewRouteLimits bounds request bodies to c.MaxBodyBytes and handlers to
+// c.HandlerTimeout. Event streams last as long as the client listens, and
+// WebSocket upgrades can't be timed out, so their route is exempt.
+func NewRouteLimits(c Config) *middleware.RouteLimits {
+	return middleware.NewRouteLimits(middleware.Limits{
+		MaxBodyBytes: c.MaxBodyBytes,
+		Timeout:      c.HandlerTimeout,
+	}).Route("GET /api/{version}/projects/{id}/events", middleware.Limits{})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestBodiesAreLimited(t *testing.T) {
+	maxBody := Envs.MaxBodyBytes
+	t.Cleanup(func() { Envs.MaxBodyBytes = maxBody })
+	Envs.MaxBodyBytes = 64
+
+	store := newMemoryStore()
+	user, _ := store.CreateUser(&User{Email: "ada@example.com"})
+	token, _ := CreateJWT(user.ID, []byte(Envs.JWTSecret))
+	api, _, err := NewAPIServer("", store).Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := `{"email":"grace@example.com","password":"` + strings.Repeat("x", 64) + `"}`
+	for _, chunked := range []bool{false, true} {
+		var body io.Reader = strings.NewReader(large)
+		if chunked {
+			body = struct{ io.Reader }{body}
+		}
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/users/register", body)
+		if chunked {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected registration to be refused with 413 (chunked: %v), got %d %s", chunked, rec.Code, rec.Body)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/tasks", struct{ io.Reader }{strings.NewReader(large)})
+	req.ContentLength = -1
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected the task to be refused with 413, got %d %s", rec.Code, rec.Body)
+	}
+}
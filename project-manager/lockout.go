@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// failureWindow is how long a failed sign-in counts towards a lockout.
+	failureWindow = time.Hour
+	// The lockout doubles with every failure past the limit, up to maxLockout.
+	maxLockout      = time.Hour
+	maxFailureDelay = 5 * time.Second
+	// ipPruneSize is how many IPs are tracked before forgotten ones are pruned.
+	ipPruneSize = 1024
+)
+
+// lockedError is returned while an account or IP is locked out. Nothing is
+// checked, not even the password, so a locked account costs no bcrypt.
+type lockedError struct {
+	until time.Time
+}
+
+func (e *lockedError) Error() string {
+	return "too many failed attempts, try again later"
+}
+
+// retryAfter is the Retry-After header value, in seconds.
+func (e *lockedError) retryAfter() string {
+	return strconv.Itoa(int(time.Until(e.until).Seconds()) + 1)
+}
+
+// LoginGuard tracks failed sign-ins per account, in the store, and per IP,
+// in memory. Both lock out with an exponential backoff once over their limit.
+type LoginGuard struct {
+	store            Store
+	maxFailures      int
+	maxFailuresPerIP int
+	lockout          time.Duration
+	delay            time.Duration
+
+	mu  sync.Mutex
+	ips map[string]*ipFailures
+}
+
+type ipFailures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+func NewLoginGuard(store Store, c Config) *LoginGuard {
+	return &LoginGuard{
+		store:            store,
+		maxFailures:      c.LoginMaxFailures,
+		maxFailuresPerIP: c.LoginMaxFailuresPerIP,
+		lockout:          c.LoginLockout,
+		delay:            c.LoginFailureDelay,
+		ips:              map[string]*ipFailures{},
+	}
+}
+
+// lockoutFor returns how long to lock out after the given number of
+// failures, 0 while under the limit.
+func lockoutFor(failures, limit int, base time.Duration) time.Duration {
+	if limit <= 0 || failures < limit || base <= 0 {
+		return 0
+	}
+	d := base
+	for i := limit; i < failures && d < maxLockout; i++ {
+		d *= 2
+	}
+	return min(d, maxLockout)
+}
+
+func (g *LoginGuard) checkIP(ip string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if f, ok := g.ips[ip]; ok && time.Now().Before(f.lockedUntil) {
+		return &lockedError{until: f.lockedUntil}
+	}
+	return nil
+}
+
+func (g *LoginGuard) checkUser(user *User) error {
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		return &lockedError{until: *user.LockedUntil}
+	}
+	return nil
+}
+
+// fail records a failed attempt from ip, against user unless the email was
+// unknown, and locks out whichever went over its limit. With a failure
+// delay configured it then waits, longer the more failures there were.
+func (g *LoginGuard) fail(ctx context.Context, ip string, user *User) {
+	now := time.Now()
+	failures := g.failIP(ip, now)
+
+	if user != nil {
+		n, err := g.store.RecordFailedLogin(user.ID, now, now.Add(-failureWindow))
+		if err != nil {
+			log.Printf("Recording failed sign-in of user %d: %v\n", user.ID, err)
+		}
+		if d := lockoutFor(n, g.maxFailures, g.lockout); d > 0 {
+			g.lockUser(user, ip, n, now.Add(d))
+		}
+		failures = max(failures, n)
+	}
+
+	if g.delay > 0 && failures > 0 {
+		d := g.delay << min(failures-1, 16)
+		t := time.NewTimer(min(d, maxFailureDelay))
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+		}
+	}
+}
+
+func (g *LoginGuard) failIP(ip string, now time.Time) int {
+	g.mu.Lock()
+	if len(g.ips) >= ipPruneSize {
+		g.prune(now)
+	}
+
+	f, ok := g.ips[ip]
+	if !ok || now.Sub(f.last) > failureWindow {
+		f = &ipFailures{}
+		g.ips[ip] = f
+	}
+	f.count++
+	f.last = now
+	count := f.count
+	d := lockoutFor(count, g.maxFailuresPerIP, g.lockout)
+	if d > 0 {
+		f.lockedUntil = now.Add(d)
+	}
+	g.mu.Unlock()
+
+	if d > 0 {
+		g.audit(&AuditEvent{
+			Action: AuditIPLocked,
+			IP:     ip,
+			Detail: fmt.Sprintf("%d failed attempts, locked for %s", count, d),
+		})
+	}
+	return count
+}
+
+// prune forgets IPs without recent failures. g.mu must be held.
+func (g *LoginGuard) prune(now time.Time) {
+	for ip, f := range g.ips {
+		if now.Sub(f.last) > failureWindow && now.After(f.lockedUntil) {
+			delete(g.ips, ip)
+		}
+	}
+}
+
+func (g *LoginGuard) lockUser(user *User, ip string, failures int, until time.Time) {
+	if err := g.store.LockUser(user.ID, until); err != nil {
+		log.Printf("Locking user %d: %v\n", user.ID, err)
+		return
+	}
+	g.audit(&AuditEvent{
+		Action: AuditAccountLocked,
+		UserID: &user.ID,
+		IP:     ip,
+		Detail: fmt.Sprintf("%d failed attempts, locked until %s", failures, until.UTC().Format(time.RFC3339)),
+	})
+}
+
+// succeed forgets the failed attempts of an account once it signed in.
+// Failures of the IP are kept, or one valid account would reset them.
+func (g *LoginGuard) succeed(user *User) {
+	if user.FailedLogins == 0 && user.LockedUntil == nil {
+		return
+	}
+	if err := g.store.UnlockUser(user.ID); err != nil {
+		log.Printf("Resetting failed sign-ins of user %d: %v\n", user.ID, err)
+	}
+}
+
+func (g *LoginGuard) audit(e *AuditEvent) {
+	if err := g.store.RecordAudit(e); err != nil {
+		log.Printf("Recording %s: %v\n", e.Action, err)
+	}
+}
+
+// writeLocked answers a request while its account or IP is locked out.
+func writeLocked(w http.ResponseWriter, err *lockedError) {
+	w.Header().Set("Retry-After", err.retryAfter())
+	WriteJson(w, http.StatusTooManyRequests, ErrorResponse{
+		Error: err.Error(),
+	})
+}
+
+// clientIP returns the IP a request came from. Behind a proxy the last
+// X-Forwarded-For entry is the one the proxy added, the others are whatever
+// the client sent.
+func clientIP(r *http.Request) string {
+	if Envs.TrustProxyHeaders {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			hops := strings.Split(xff, ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
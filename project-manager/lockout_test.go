@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLockoutFor(t *testing.T) {
+	for _, tc := range []struct {
+		failures int
+		want     time.Duration
+	}{
+		{4, 0},
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{8, 8 * time.Minute},
+		{20, maxLockout},
+	} {
+		if got := lockoutFor(tc.failures, 5, time.Minute); got != tc.want {
+			t.Errorf("After %d failures expected %v, got %v", tc.failures, tc.want, got)
+		}
+	}
+	if got := lockoutFor(100, 0, time.Minute); got != 0 {
+		t.Errorf("Expected no lockout without a limit, got %v", got)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	store := newMemoryStore()
+	hashed, _ := HashPassword("a long enough passphrase")
+	ada, _ := store.CreateUser(&User{Email: "ada@example.com", Password: hashed})
+	admin, _ := store.CreateUser(&User{Email: "grace@example.com", Password: hashed, Role: RoleAdmin})
+
+	api, _, err := NewAPIServer("", store).Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(method, path, ip, token string, body any) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, "/api/v1"+path, bytes.NewReader(b))
+		req.RemoteAddr = ip + ":1234"
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec
+	}
+	login := func(ip, email, password string) *httptest.ResponseRecorder {
+		return call(http.MethodPost, "/users/login", ip, "", LoginPayload{Email: email, Password: password})
+	}
+
+	t.Run("Locks the account after too many failures", func(t *testing.T) {
+		for i := 1; i < Envs.LoginMaxFailures; i++ {
+			if rec := login("192.0.2.1", ada.Email, "wrong password"); rec.Code != http.StatusUnauthorized {
+				t.Fatalf("Attempt %d: expected status code %d, got %d", i, http.StatusUnauthorized, rec.Code)
+			}
+		}
+		// The attempt that reaches the limit is still answered like the others.
+		login("192.0.2.1", ada.Email, "wrong password")
+
+		rec := login("192.0.2.2", ada.Email, "a long enough passphrase")
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected the right password to be refused with %d, got %d", http.StatusTooManyRequests, rec.Code)
+		}
+		if s, _ := strconv.Atoi(rec.Header().Get("Retry-After")); s <= 0 || s > int(Envs.LoginLockout.Seconds())+1 {
+			t.Errorf("Unexpected Retry-After %q", rec.Header().Get("Retry-After"))
+		}
+		if len(store.audit) != 1 || store.audit[0].Action != AuditAccountLocked || *store.audit[0].UserID != ada.ID {
+			t.Errorf("Expected the lockout to be audited, got %+v", store.audit)
+		}
+	})
+
+	adminSession, _ := CreateJWT(admin.ID, []byte(Envs.JWTSecret))
+	unlock := "/admin/users/" + strconv.FormatInt(ada.ID, 10) + "/unlock"
+
+	t.Run("Only admins can unlock accounts", func(t *testing.T) {
+		session, _ := CreateJWT(ada.ID, []byte(Envs.JWTSecret))
+		if rec := call(http.MethodPost, unlock, "192.0.2.3", session, nil); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected status code %d, got %d", http.StatusForbidden, rec.Code)
+		}
+		if rec := call(http.MethodGet, "/admin/audit-log", "192.0.2.3", session, nil); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected status code %d, got %d", http.StatusForbidden, rec.Code)
+		}
+	})
+
+	t.Run("An admin unlocks the account", func(t *testing.T) {
+		if rec := call(http.MethodPost, unlock, "192.0.2.3", adminSession, nil); rec.Code != http.StatusNoContent {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusNoContent, rec.Code, rec.Body)
+		}
+		if rec := login("192.0.2.2", ada.Email, "a long enough passphrase"); rec.Code != http.StatusOK {
+			t.Errorf("Expected status code %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+		}
+
+		rec := call(http.MethodGet, "/admin/audit-log", "192.0.2.3", adminSession, nil)
+		var page ListResponse[*AuditEvent]
+		json.NewDecoder(rec.Body).Decode(&page)
+		if len(page.Items) != 2 || page.Items[1].Action != AuditAccountUnlocked || *page.Items[1].ActorID != admin.ID {
+			t.Errorf("Unexpected audit log %+v", page.Items)
+		}
+	})
+
+	t.Run("Locks out an IP trying many accounts", func(t *testing.T) {
+		for i := 0; i < Envs.LoginMaxFailuresPerIP; i++ {
+			login("198.51.100.7", "nobody"+strconv.Itoa(i)+"@example.com", "wrong password")
+		}
+		if rec := login("198.51.100.7", ada.Email, "a long enough passphrase"); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("Expected status code %d, got %d", http.StatusTooManyRequests, rec.Code)
+		}
+		if rec := login("198.51.100.8", ada.Email, "a long enough passphrase"); rec.Code != http.StatusOK {
+			t.Errorf("Expected other IPs to sign in, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Wrong second factors count towards the lockout", func(t *testing.T) {
+		secret, _ := newTOTPSecret()
+		now := time.Now()
+		store.SaveTOTP(&TOTPEnrollment{UserID: ada.ID, Secret: secret, ConfirmedAt: &now})
+
+		var res TokenResponse
+		json.NewDecoder(login("192.0.2.4", ada.Email, "a long enough passphrase").Body).Decode(&res)
+		if !res.MFARequired {
+			t.Fatal("Expected a two-factor challenge")
+		}
+		code := loginMFAPayload{MFAToken: res.MFAToken, Code: "000000"}
+		var rec *httptest.ResponseRecorder
+		for i := 0; i <= Envs.LoginMaxFailures; i++ {
+			rec = call(http.MethodPost, "/users/login/mfa", "192.0.2.4", "", code)
+		}
+		if rec.Code != http.StatusTooManyRequests {
+			t.Errorf("Expected status code %d, got %d", http.StatusTooManyRequests, rec.Code)
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional email such as verification links.
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// NewMailer picks the backend from MAILER: "log" (default), "file" or "smtp".
+func NewMailer(c Config) (Mailer, error) {
+	switch c.Mailer {
+	case "", "log":
+		return LogMailer{}, nil
+	case "file":
+		if err := os.MkdirAll(c.MailDir, 0o700); err != nil {
+			return nil, err
+		}
+		return &FileMailer{Dir: c.MailDir, From: c.MailFrom}, nil
+	case "smtp":
+		return &SMTPMailer{Addr: c.SMTPAddr, Username: c.SMTPUsername, Password: c.SMTPPassword, From: c.MailFrom}, nil
+	}
+	return nil, fmt.Errorf("unknown MAILER %q: must be log, file or smtp", c.Mailer)
+}
+
+// LogMailer writes messages to the server log, for local runs.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, m Message) error {
+	log.Printf("Mail to %s: %s\n%s\n", m.To, m.Subject, m.Body)
+	return nil
+}
+
+// FileMailer writes each message as an .eml file, which mail clients open.
+type FileMailer struct {
+	Dir  string
+	From string
+
+	seq atomic.Uint64
+}
+
+func (f *FileMailer) Send(ctx context.Context, m Message) error {
+	name := fmt.Sprintf("%s-%04d.eml", time.Now().UTC().Format("20060102T150405"), f.seq.Add(1))
+	return os.WriteFile(filepath.Join(f.Dir, name), formatMessage(f.From, m), 0o600)
+}
+
+type SMTPMailer struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPMailer) Send(ctx context.Context, m Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, _ := strings.Cut(s.Addr, ":")
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Addr, auth, s.From, []string{m.To}, formatMessage(s.From, m))
+}
+
+func formatMessage(from string, m Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -1,12 +1,43 @@
 package main
 
 import (
+	"context"
 	"log"
+	"log/slog"
+	"time"
 
+	"github.com/ZiadMansourM/middleware/logging"
 	"github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel"
 )
 
 func main() {
+	logger, err := logging.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid logging config: %v", err)
+	}
+	// Also turns the log package's output into structured records.
+	slog.SetDefault(logger)
+
+	if err := Envs.checkSecrets(); err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+
+	if Envs.JWTKeysDir != "" {
+		keys, err := LoadKeySet(Envs.JWTKeysDir, Envs.JWTSigningKeyID)
+		if err != nil {
+			log.Fatalf("Loading JWT keys: %v", err)
+		}
+		jwtKeys = keys
+	}
+
+	tp, err := NewTracerProvider(context.Background(), Envs)
+	if err != nil {
+		log.Fatalf("Setting up tracing: %v", err)
+	}
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagator)
+
 	cfg := mysql.Config{
 		User:                 Envs.DBUser,
 		Passwd:               Envs.DBPassword,
@@ -27,4 +58,11 @@ func main() {
 
 	api := NewAPIServer(Envs.ListenAddress+":"+Envs.Port, store)
 	api.Run()
+
+	// Flush the spans of the last requests.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tp.Shutdown(ctx); err != nil {
+		log.Printf("Could not flush spans: %v\n", err)
+	}
 }
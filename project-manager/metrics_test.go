@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	store := newMemoryStore()
+	hashed, _ := HashPassword("a long enough passphrase")
+	user, _ := store.CreateUser(&User{Email: "ada@example.com", Password: hashed})
+	token, _ := CreateJWT(user.ID, []byte(Envs.JWTSecret))
+
+	server := NewAPIServer("", store)
+	api, _, err := server.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/api/v1/users/me", "/api/v1/users/me", "/api/v1/health", "/wp-login.php"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		api.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	rec := httptest.NewRecorder()
+	server.DebugHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+	}
+	body := rec.Body.String()
+	for _, series := range []string{
+		`http_requests_total{method="GET",route="GET /api/v1/users/me",status="2xx"} 2`,
+		`http_requests_total{method="GET",route="GET /api/v1/health",status="2xx"} 1`,
+		`http_requests_total{method="GET",route="unmatched",status="4xx"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="GET /api/v1/health",status="2xx"} 1`,
+		`http_response_size_bytes_count{method="GET",route="GET /api/v1/health",status="2xx"} 1`,
+		`http_requests_in_flight{method="GET"} 0`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, series) {
+			t.Errorf("Expected %s in the metrics", series)
+		}
+	}
+
+	for _, path := range []string{"/metrics", "/debug/vars"} {
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected %s to be left out of the API, got %d", path, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	// mfaChallengeTTL is how long the second sign-in step may take.
+	mfaChallengeTTL = 5 * time.Minute
+	// mfaChallengeAttempts is how many codes a challenge may be tried with.
+	mfaChallengeAttempts = 5
+)
+
+var errMFANotEnabled = errors.New("two-factor authentication is not enabled")
+var errMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled, disable it first")
+var errInvalidMFACode = errors.New("invalid or already used code")
+var errInvalidMFAChallenge = errors.New("sign-in attempt is invalid or has expired, sign in again")
+
+type mfaCodePayload struct {
+	Code string `json:"code"`
+}
+
+type loginMFAPayload struct {
+	MFAToken string `json:"mfaToken"`
+	Code     string `json:"code"`
+}
+
+type totpEnrollmentResponse struct {
+	Secret string `json:"secret"`
+	// OTPAuthURI is rendered as a QR code for authenticator apps.
+	OTPAuthURI string `json:"otpauthURI"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+func (s *UserService) registerMFARoutes(router *http.ServeMux) {
+	router.HandleFunc("POST /users/login/mfa", s.handleLoginMFA)
+	router.HandleFunc("POST /users/me/mfa/totp", s.handleEnrollTOTP)
+	router.HandleFunc("POST /users/me/mfa/totp/confirm", s.handleConfirmTOTP)
+	router.HandleFunc("DELETE /users/me/mfa/totp", s.handleDisableTOTP)
+	router.HandleFunc("POST /users/me/mfa/recovery-codes", s.handleRegenerateRecoveryCodes)
+}
+
+// mfaKey signs challenge tokens. It differs from the session key so a
+// challenge can never be used as a session.
+func mfaKey() []byte {
+	sum := sha256.Sum256([]byte("mfa:" + currentJWTSecret()))
+	return sum[:]
+}
+
+// newMFAChallenge returns the token that proves the password step passed.
+// Its jti is stored as a single-use token, so a challenge completes one
+// sign-in and can only be tried a few times.
+func (s *UserService) newMFAChallenge(userID int64) (string, error) {
+	jti, token, err := newUserToken(userID, TokenMFAChallenge, mfaChallengeTTL)
+	if err != nil {
+		return "", err
+	}
+	if err := s.store.CreateUserToken(token); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":     strconv.FormatInt(userID, 10),
+		"jti":     jti,
+		"purpose": "mfa",
+		"iat":     now.Unix(),
+		"exp":     now.Add(mfaChallengeTTL).Unix(),
+	}).SignedString(mfaKey())
+}
+
+// parseMFAChallenge returns the user and the jti of a challenge.
+func parseMFAChallenge(raw string) (int64, string, error) {
+	token, err := jwt.Parse(raw, func(t *jwt.Token) (any, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return mfaKey(), nil
+	})
+	if err != nil || !token.Valid {
+		return 0, "", errInvalidMFAChallenge
+	}
+
+	claims, _ := token.Claims.(jwt.MapClaims)
+	sub, _ := claims["sub"].(string)
+	jti, _ := claims["jti"].(string)
+	if claims["purpose"] != "mfa" || jti == "" || !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return 0, "", errInvalidMFAChallenge
+	}
+	userID, err := strconv.ParseInt(sub, 10, 64)
+	if err != nil {
+		return 0, "", errInvalidMFAChallenge
+	}
+	return userID, jti, nil
+}
+
+// attemptMFAChallenge counts a try of the challenge's code, rejecting
+// challenges already used or tried too often.
+func (s *UserService) attemptMFAChallenge(userID int64, jti string) error {
+	token, err := s.store.AttemptUserToken(TokenMFAChallenge, hashUserToken(jti))
+	if errors.Is(err, sql.ErrNoRows) {
+		return errInvalidMFAChallenge
+	}
+	if err != nil {
+		return err
+	}
+	if token.UserID != userID || token.Attempts > mfaChallengeAttempts {
+		return errInvalidMFAChallenge
+	}
+	return nil
+}
+
+// mfaEnabled reports whether the user has a confirmed authenticator.
+func (s *UserService) mfaEnabled(userID int64) (bool, error) {
+	e, err := s.store.GetTOTP(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return e.ConfirmedAt != nil, nil
+}
+
+// verifySecondFactor accepts a current TOTP code or an unused recovery code.
+// Either works only once.
+func (s *UserService) verifySecondFactor(userID int64, code string) error {
+	e, err := s.store.GetTOTP(userID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && e.ConfirmedAt == nil) {
+		return errMFANotEnabled
+	}
+	if err != nil {
+		return err
+	}
+
+	if counter, ok := verifyTOTP(e.Secret, code, time.Now()); ok {
+		err = s.store.UseTOTPCounter(userID, counter)
+	} else {
+		err = s.store.UseRecoveryCode(userID, hashRecoveryCode(code))
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return errInvalidMFACode
+	}
+	return err
+}
+
+// completeMFA exchanges a challenge and a code for the user to sign in.
+// Wrong codes count towards the account's lockout like wrong passwords, and
+// towards the challenge's attempts.
+func (s *UserService) completeMFA(ctx context.Context, ip, challenge, code string) (*User, error) {
+	if err := s.guard.checkIP(ip); err != nil {
+		return nil, err
+	}
+	userID, jti, err := parseMFAChallenge(challenge)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.store.GetUserByID(strconv.FormatInt(userID, 10))
+	if err != nil {
+		return nil, errInvalidMFAChallenge
+	}
+	if err := s.guard.checkUser(user); err != nil {
+		return nil, err
+	}
+	if err := s.attemptMFAChallenge(userID, jti); err != nil {
+		return nil, err
+	}
+
+	err = s.verifySecondFactor(userID, code)
+	if errors.Is(err, errMFANotEnabled) {
+		return nil, errInvalidMFAChallenge
+	}
+	if errors.Is(err, errInvalidMFACode) {
+		s.guard.fail(ctx, ip, user)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A concurrent request may have completed the challenge meanwhile.
+	_, err = s.store.ConsumeUserToken(TokenMFAChallenge, hashUserToken(jti))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errInvalidMFAChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.guard.succeed(user)
+	return user, nil
+}
+
+func (s *UserService) handleLoginMFA(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Error reading Request Body: " + err.Error(),
+		})
+		return
+	}
+
+	defer r.Body.Close()
+
+	var payload loginMFAPayload
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid Request Payload: " + err.Error(),
+		})
+		return
+	}
+
+	user, err := s.completeMFA(r.Context(), clientIP(r), payload.MFAToken, payload.Code)
+	var locked *lockedError
+	if errors.As(err, &locked) {
+		writeLocked(w, locked)
+		return
+	}
+	if err != nil {
+		WriteJson(w, http.StatusUnauthorized, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	token, err := createAndSetAuthCookie(w, user.ID)
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error creating token: " + err.Error(),
+		})
+		return
+	}
+
+	WriteJson(w, http.StatusOK, TokenResponse{Token: token})
+}
+
+// currentUser loads the user authenticated by RequireAuthMiddleware.
+func (s *UserService) currentUser(w http.ResponseWriter, r *http.Request) (*User, bool) {
+	info := authInfoFromContext(r.Context())
+	if info == nil {
+		WriteJson(w, http.StatusUnauthorized, ErrorResponse{
+			Error: "Unauthorized",
+		})
+		return nil, false
+	}
+
+	user, err := s.store.GetUserByID(info.UserID)
+	if err != nil {
+		WriteJson(w, http.StatusNotFound, ErrorResponse{
+			Error: "Error getting user: " + err.Error(),
+		})
+		return nil, false
+	}
+	return user, true
+}
+
+// handleEnrollTOTP starts enrolment with a new secret. Nothing changes at
+// sign-in until the secret is confirmed with a code from the app.
+func (s *UserService) handleEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	enabled, err := s.mfaEnabled(user.ID)
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error reading two-factor settings: " + err.Error(),
+		})
+		return
+	}
+	if enabled {
+		WriteJson(w, http.StatusConflict, ErrorResponse{
+			Error: errMFAAlreadyEnabled.Error(),
+		})
+		return
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error creating secret: " + err.Error(),
+		})
+		return
+	}
+	if err := s.store.SaveTOTP(&TOTPEnrollment{UserID: user.ID, Secret: secret}); err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error saving secret: " + err.Error(),
+		})
+		return
+	}
+
+	WriteJson(w, http.StatusCreated, totpEnrollmentResponse{
+		Secret:     secret,
+		OTPAuthURI: totpURI(secret, user.Email),
+	})
+}
+
+// handleConfirmTOTP turns two-factor authentication on and returns the
+// recovery codes. They are only shown this once.
+func (s *UserService) handleConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Error reading Request Body: " + err.Error(),
+		})
+		return
+	}
+
+	defer r.Body.Close()
+
+	var payload mfaCodePayload
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid Request Payload: " + err.Error(),
+		})
+		return
+	}
+
+	e, err := s.store.GetTOTP(user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		WriteJson(w, http.StatusNotFound, ErrorResponse{
+			Error: "Start enrolment first",
+		})
+		return
+	}
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error reading two-factor settings: " + err.Error(),
+		})
+		return
+	}
+	if e.ConfirmedAt != nil {
+		WriteJson(w, http.StatusConflict, ErrorResponse{
+			Error: errMFAAlreadyEnabled.Error(),
+		})
+		return
+	}
+
+	counter, ok := verifyTOTP(e.Secret, payload.Code, time.Now())
+	if !ok {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: errInvalidMFACode.Error(),
+		})
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error creating recovery codes: " + err.Error(),
+		})
+		return
+	}
+	if err := s.store.ReplaceRecoveryCodes(user.ID, hashes); err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error saving recovery codes: " + err.Error(),
+		})
+		return
+	}
+
+	now := time.Now()
+	e.ConfirmedAt, e.LastCounter = &now, counter
+	if err := s.store.SaveTOTP(e); err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error saving two-factor settings: " + err.Error(),
+		})
+		return
+	}
+
+	WriteJson(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// handleDisableTOTP turns two-factor authentication off. It takes a code
+// so a stolen session alone can't remove the second factor.
+func (s *UserService) handleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Error reading Request Body: " + err.Error(),
+		})
+		return
+	}
+
+	defer r.Body.Close()
+
+	var payload mfaCodePayload
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid Request Payload: " + err.Error(),
+		})
+		return
+	}
+	if !s.checkSecondFactor(w, user.ID, payload.Code) {
+		return
+	}
+
+	if err := s.store.DeleteTOTP(user.ID); err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error disabling two-factor authentication: " + err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRegenerateRecoveryCodes replaces every recovery code, used or not.
+func (s *UserService) handleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Error reading Request Body: " + err.Error(),
+		})
+		return
+	}
+
+	defer r.Body.Close()
+
+	var payload mfaCodePayload
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid Request Payload: " + err.Error(),
+		})
+		return
+	}
+	if !s.checkSecondFactor(w, user.ID, payload.Code) {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error creating recovery codes: " + err.Error(),
+		})
+		return
+	}
+	if err := s.store.ReplaceRecoveryCodes(user.ID, hashes); err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error saving recovery codes: " + err.Error(),
+		})
+		return
+	}
+
+	WriteJson(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// checkSecondFactor writes the error response when the code is not valid.
+func (s *UserService) checkSecondFactor(w http.ResponseWriter, userID int64, code string) bool {
+	err := s.verifySecondFactor(userID, code)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errMFANotEnabled):
+		WriteJson(w, http.StatusNotFound, ErrorResponse{
+			Error: err.Error(),
+		})
+	case errors.Is(err, errInvalidMFACode):
+		WriteJson(w, http.StatusForbidden, ErrorResponse{
+			Error: err.Error(),
+		})
+	default:
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error checking code: " + err.Error(),
+		})
+	}
+	return false
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1 seed, truncated to 6 digits.
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	for _, tc := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{2000000000, "279037"},
+	} {
+		code, err := totpCode(secret, totpCounter(time.Unix(tc.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tc.code {
+			t.Errorf("At %d expected %s, got %s", tc.unix, tc.code, code)
+		}
+	}
+
+	now := time.Unix(1111111109, 0)
+	if _, ok := verifyTOTP(secret, "081804", now.Add(totpPeriod*time.Second)); !ok {
+		t.Error("Expected the previous code to be accepted for clock drift")
+	}
+	if _, ok := verifyTOTP(secret, "081804", now.Add(3*totpPeriod*time.Second)); ok {
+		t.Error("Expected an old code to be rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	u, err := url.Parse(totpURI("JBSWY3DPEHPK3PXP", "ada@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Project Manager:ada@example.com" {
+		t.Errorf("Unexpected URI %s", u)
+	}
+	if q := u.Query(); q.Get("secret") != "JBSWY3DPEHPK3PXP" || q.Get("issuer") != totpIssuer {
+		t.Errorf("Unexpected parameters %v", q)
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	store := newMemoryStore()
+	hashed, _ := HashPassword("a long enough passphrase")
+	store.CreateUser(&User{Email: "ada@example.com", Password: hashed})
+
+	api, _, err := NewAPIServer("", store).Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(method, path, token string, body any) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, "/api/v1"+path, bytes.NewReader(b))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec
+	}
+	login := func() TokenResponse {
+		t.Helper()
+		rec := call(http.MethodPost, "/users/login", "", LoginPayload{Email: "ada@example.com", Password: "a long enough passphrase"})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+		}
+		var res TokenResponse
+		json.NewDecoder(rec.Body).Decode(&res)
+		return res
+	}
+	// A code works once, so each use takes the next step in the window.
+	step := -totpSkew
+	nextCode := func(secret string) string {
+		code, _ := totpCode(secret, totpCounter(time.Now())+int64(step))
+		step++
+		return code
+	}
+
+	session := login().Token
+
+	var enrollment totpEnrollmentResponse
+	t.Run("Enrolment returns a provisioning URI", func(t *testing.T) {
+		rec := call(http.MethodPost, "/users/me/mfa/totp", session, nil)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body)
+		}
+		json.NewDecoder(rec.Body).Decode(&enrollment)
+		if !strings.HasPrefix(enrollment.OTPAuthURI, "otpauth://totp/") || !strings.Contains(enrollment.OTPAuthURI, enrollment.Secret) {
+			t.Errorf("Unexpected enrolment %+v", enrollment)
+		}
+		if res := login(); res.MFARequired {
+			t.Error("Expected login to stay one step until enrolment is confirmed")
+		}
+	})
+
+	var recovery recoveryCodesResponse
+	t.Run("Confirmation needs a valid code", func(t *testing.T) {
+		if rec := call(http.MethodPost, "/users/me/mfa/totp/confirm", session, mfaCodePayload{Code: "000000"}); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rec.Code)
+		}
+
+		rec := call(http.MethodPost, "/users/me/mfa/totp/confirm", session, mfaCodePayload{Code: nextCode(enrollment.Secret)})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+		}
+		json.NewDecoder(rec.Body).Decode(&recovery)
+		if len(recovery.RecoveryCodes) != recoveryCodeCount {
+			t.Fatalf("Expected %d recovery codes, got %v", recoveryCodeCount, recovery.RecoveryCodes)
+		}
+		if _, ok := store.recoveryCodes[1][recovery.RecoveryCodes[0]]; ok {
+			t.Error("Expected recovery codes to be stored hashed")
+		}
+
+		if rec := call(http.MethodPost, "/users/me/mfa/totp", session, nil); rec.Code != http.StatusConflict {
+			t.Errorf("Expected re-enrolment to conflict, got %d", rec.Code)
+		}
+	})
+
+	t.Run("The password alone only returns a challenge", func(t *testing.T) {
+		rec := call(http.MethodPost, "/users/login", "", LoginPayload{Email: "ada@example.com", Password: "a long enough passphrase"})
+		var res TokenResponse
+		json.NewDecoder(rec.Body).Decode(&res)
+		if !res.MFARequired || res.MFAToken == "" || res.Token != "" || len(rec.Result().Cookies()) != 0 {
+			t.Fatalf("Expected only a challenge, got %+v", res)
+		}
+
+		if rec := call(http.MethodGet, "/users/me", res.MFAToken, nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected the challenge not to work as a session, got %d", rec.Code)
+		}
+		if rec := call(http.MethodPost, "/users/login/mfa", "", loginMFAPayload{MFAToken: res.MFAToken, Code: "000000"}); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected a wrong code to be rejected, got %d", rec.Code)
+		}
+	})
+
+	t.Run("A TOTP code completes the login once", func(t *testing.T) {
+		challenge := login().MFAToken
+		code := nextCode(enrollment.Secret)
+
+		rec := call(http.MethodPost, "/users/login/mfa", "", loginMFAPayload{MFAToken: challenge, Code: code})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+		}
+		var res TokenResponse
+		json.NewDecoder(rec.Body).Decode(&res)
+		if rec := call(http.MethodGet, "/users/me", res.Token, nil); rec.Code != http.StatusOK {
+			t.Errorf("Expected the session to work, got %d", rec.Code)
+		}
+
+		if rec := call(http.MethodPost, "/users/login/mfa", "", loginMFAPayload{MFAToken: login().MFAToken, Code: code}); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected a replayed code to be rejected, got %d", rec.Code)
+		}
+		if rec := call(http.MethodPost, "/users/login/mfa", "", loginMFAPayload{MFAToken: challenge, Code: nextCode(enrollment.Secret)}); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected a replayed challenge to be rejected, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Expired challenges are rejected", func(t *testing.T) {
+		expired, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub":     "1",
+			"purpose": "mfa",
+			"exp":     time.Now().Add(-time.Minute).Unix(),
+		}).SignedString(mfaKey())
+		if rec := call(http.MethodPost, "/users/login/mfa", "", loginMFAPayload{MFAToken: expired, Code: recovery.RecoveryCodes[5]}); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected an expired challenge to be rejected, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Recovery codes work once", func(t *testing.T) {
+		code := strings.ToUpper(recovery.RecoveryCodes[0])
+		if rec := call(http.MethodPost, "/users/login/mfa", "", loginMFAPayload{MFAToken: login().MFAToken, Code: code}); rec.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+		}
+		if rec := call(http.MethodPost, "/users/login/mfa", "", loginMFAPayload{MFAToken: login().MFAToken, Code: code}); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected a used recovery code to be rejected, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Regenerating replaces the recovery codes", func(t *testing.T) {
+		rec := call(http.MethodPost, "/users/me/mfa/recovery-codes", session, mfaCodePayload{Code: recovery.RecoveryCodes[1]})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+		}
+		old := recovery.RecoveryCodes[2]
+		json.NewDecoder(rec.Body).Decode(&recovery)
+
+		if rec := call(http.MethodPost, "/users/login/mfa", "", loginMFAPayload{MFAToken: login().MFAToken, Code: old}); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected old recovery codes to stop working, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Disabling needs a code", func(t *testing.T) {
+		if rec := call(http.MethodDelete, "/users/me/mfa/totp", session, mfaCodePayload{Code: "000000"}); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected status code %d, got %d", http.StatusForbidden, rec.Code)
+		}
+		if rec := call(http.MethodDelete, "/users/me/mfa/totp", session, mfaCodePayload{Code: recovery.RecoveryCodes[0]}); rec.Code != http.StatusNoContent {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusNoContent, rec.Code, rec.Body)
+		}
+		if res := login(); res.MFARequired || res.Token == "" {
+			t.Errorf("Expected a one-step login again, got %+v", res)
+		}
+	})
+}
+
+func TestMFAChallengeAttempts(t *testing.T) {
+	maxFailures := Envs.LoginMaxFailures
+	t.Cleanup(func() { Envs.LoginMaxFailures = maxFailures })
+	// High enough that the challenge runs out before the account locks.
+	Envs.LoginMaxFailures = 2 * mfaChallengeAttempts
+
+	store := newMemoryStore()
+	ada, _ := store.CreateUser(&User{Email: "ada@example.com"})
+	secret, _ := newTOTPSecret()
+	now := time.Now()
+	store.SaveTOTP(&TOTPEnrollment{UserID: ada.ID, Secret: secret, ConfirmedAt: &now})
+	users := NewUserService(store, LogMailer{}, &PasswordPolicy{})
+
+	challenge, err := users.mfaChallenge(ada)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < mfaChallengeAttempts; i++ {
+		if _, err := users.completeMFA(context.Background(), "192.0.2.1", challenge, "000000"); !errors.Is(err, errInvalidMFACode) {
+			t.Fatalf("Expected attempt %d to check the code, got %v", i+1, err)
+		}
+	}
+	code, _ := totpCode(secret, totpCounter(time.Now()))
+	if _, err := users.completeMFA(context.Background(), "192.0.2.1", challenge, code); !errors.Is(err, errInvalidMFAChallenge) {
+		t.Errorf("Expected the challenge to run out, got %v", err)
+	}
+
+	challenge, _ = users.mfaChallenge(ada)
+	if _, err := users.completeMFA(context.Background(), "192.0.2.1", challenge, code); err != nil {
+		t.Errorf("Expected a new challenge to work, got %v", err)
+	}
+}
+
+var mfaTokenField = regexp.MustCompile(`name="mfa_token" value="([^"]+)"`)
+
+func TestWebTwoFactorLogin(t *testing.T) {
+	store := newMemoryStore()
+	hashed, _ := HashPassword("a long enough passphrase")
+	ada, _ := store.CreateUser(&User{Email: "ada@example.com", Password: hashed})
+	secret, _ := newTOTPSecret()
+	now := time.Now()
+	store.SaveTOTP(&TOTPEnrollment{UserID: ada.ID, Secret: secret, ConfirmedAt: &now})
+
+	users := NewUserService(store, LogMailer{}, &PasswordPolicy{})
+	router := http.NewServeMux()
+	NewWebService(store, users, NewTasksService(store, nil)).RegisterRoutes(router)
+
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post("/login", url.Values{"email": {"ada@example.com"}, "password": {"a long enough passphrase"}})
+	if rec.Code != http.StatusOK || len(rec.Result().Cookies()) != 0 {
+		t.Fatalf("Expected the code page without a session, got %d", rec.Code)
+	}
+	match := mfaTokenField.FindStringSubmatch(rec.Body.String())
+	if match == nil {
+		t.Fatalf("Expected a challenge in the form, got %s", rec.Body)
+	}
+
+	if rec := post("/login/mfa", url.Values{"mfa_token": {match[1]}, "code": {"000000"}}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a wrong code to be rejected, got %d", rec.Code)
+	}
+
+	code, _ := totpCode(secret, totpCounter(time.Now()))
+	rec = post("/login/mfa", url.Values{"mfa_token": {match[1]}, "code": {code}})
+	if rec.Code != http.StatusSeeOther || sessionCookie(rec) == nil {
+		t.Errorf("Expected a session and a redirect, got %d", rec.Code)
+	}
+}
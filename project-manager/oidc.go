@@ -0,0 +1,521 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	oidcFlowCookie = "oidc_flow"
+	oidcFlowTTL    = 10 * time.Minute
+	// jwksRefreshInterval limits how often an unknown kid triggers a refetch.
+	jwksRefreshInterval = time.Minute
+)
+
+var (
+	errOIDCState  = errors.New("state does not match the login attempt")
+	errOIDCNonce  = errors.New("ID token nonce does not match the login attempt")
+	errOIDCNoKey  = errors.New("no matching key in the issuer JWKS")
+	errOIDCNoSub  = errors.New("ID token has no subject")
+	errOIDCNoMail = errors.New("ID token has no email, request the email scope")
+	// Unverified emails can't be trusted to link or create an account,
+	// otherwise anyone could claim someone else's address.
+	errOIDCUnverified = errors.New("ID token email is not verified by the issuer")
+)
+
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// oidcMetadata is the subset of the discovery document we rely on.
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// jsonWebKey is a public key in a JWKS (RFC 7517), read from issuers and
+// served on /.well-known/jwks.json.
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// OIDCProvider talks to an OpenID Connect issuer. Discovery happens lazily on
+// first use so the API can start while the issuer is unreachable.
+type OIDCProvider struct {
+	config     OIDCConfig
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	metadata    *oidcMetadata
+	keys        map[string]any
+	keysFetched time.Time
+}
+
+func NewOIDCProvider(config OIDCConfig) *OIDCProvider {
+	return &OIDCProvider{
+		config:     config,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var m oidcMetadata
+	wellKnown := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, &m); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+
+	// OpenID Connect Discovery 1.0, section 4.3.
+	if m.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("discovery: issuer %q does not match configured %q", m.Issuer, p.config.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("discovery: document is missing endpoints")
+	}
+
+	p.metadata = &m
+	return p.metadata, nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, res.Status)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
+
+// AuthCodeURL builds the authorization request with PKCE (S256).
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return m.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified ID token
+// claims. The nonce must match the one sent in the authorization request.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (jwt.MapClaims, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	res, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token exchange: %w", err)
+	}
+	defer res.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("token exchange: %w", err)
+	}
+	if res.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, fmt.Errorf("token exchange: %s %s %s", res.Status, tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token exchange: no id_token in response")
+	}
+
+	return p.VerifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+// VerifyIDToken checks the signature against the issuer JWKS and the iss,
+// aud, exp, iat and nonce claims (OpenID Connect Core 1.0, 3.1.3.7).
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, raw, nonce string) (jwt.MapClaims, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &jwt.Parser{ValidMethods: []string{"RS256", "RS384", "RS512", "ES256", "ES384", "EdDSA"}}
+	token, err := parser.Parse(raw, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, m, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ID token: %w", err)
+	}
+
+	claims, _ := token.Claims.(jwt.MapClaims)
+	now := time.Now().Unix()
+	switch {
+	case !claims.VerifyIssuer(m.Issuer, true):
+		return nil, errors.New("ID token: unexpected issuer")
+	case !claims.VerifyAudience(p.config.ClientID, true):
+		return nil, errors.New("ID token: unexpected audience")
+	case !claims.VerifyExpiresAt(now, true):
+		return nil, errors.New("ID token: expired")
+	case !claims.VerifyIssuedAt(now+60, true):
+		return nil, errors.New("ID token: issued in the future")
+	}
+	if got, _ := claims["nonce"].(string); nonce == "" || got != nonce {
+		return nil, errOIDCNonce
+	}
+	return claims, nil
+}
+
+// key returns the verification key for kid, refetching the JWKS when the
+// issuer has rotated to a key we haven't seen yet.
+func (p *OIDCProvider) key(ctx context.Context, m *oidcMetadata, kid string) (any, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	if time.Since(p.keysFetched) < jwksRefreshInterval && p.keys != nil {
+		return nil, errOIDCNoKey
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, m.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+
+	p.keys = make(map[string]any, len(set.Keys))
+	p.keysFetched = time.Now()
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		k, err := jwk.publicKey()
+		if err != nil {
+			log.Printf("Skipping JWKS key %q: %v\n", jwk.Kid, err)
+			continue
+		}
+		p.keys[jwk.Kid] = k
+	}
+
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, errOIDCNoKey
+}
+
+func (k jsonWebKey) publicKey() (any, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// OIDCService adds "Sign in with SSO" to the web UI. Verified identities are
+// linked to users by (issuer, subject), provisioning users just in time.
+type OIDCService struct {
+	store    Store
+	provider *OIDCProvider
+}
+
+func NewOIDCService(store Store, provider *OIDCProvider) *OIDCService {
+	return &OIDCService{store: store, provider: provider}
+}
+
+func (s *OIDCService) RegisterRoutes(router *http.ServeMux) {
+	router.HandleFunc("GET /auth/oidc/login", s.handleLogin)
+	router.HandleFunc("GET /auth/oidc/callback", s.handleCallback)
+}
+
+// flowKey derives the key signing the login-attempt cookie so it can never
+// be mistaken for a session token signed with the JWT secret.
+func flowKey() []byte {
+	key := sha256.Sum256([]byte("oidc-flow:" + currentJWTSecret()))
+	return key[:]
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (s *OIDCService) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var flow [3]string
+	for i := range flow {
+		v, err := randomToken(32)
+		if err != nil {
+			http.Error(w, "Error starting login", http.StatusInternalServerError)
+			return
+		}
+		flow[i] = v
+	}
+	state, nonce, verifier := flow[0], flow[1], flow[2]
+
+	authURL, err := s.provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("OIDC login failed: %v\n", err)
+		http.Error(w, "Single sign-on is unavailable", http.StatusBadGateway)
+		return
+	}
+
+	// Keep the attempt in a signed cookie bound to this browser, so the
+	// callback can check state and nonce without server-side storage.
+	cookie, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"exp":      time.Now().Add(oidcFlowTTL).Unix(),
+	}).SignedString(flowKey())
+	if err != nil {
+		http.Error(w, "Error starting login", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    cookie,
+		Path:     "/auth/oidc",
+		MaxAge:   int(oidcFlowTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(s.provider.config.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (s *OIDCService) handleCallback(w http.ResponseWriter, r *http.Request) {
+	// The attempt is single use whatever the outcome.
+	http.SetCookie(w, &http.Cookie{Name: oidcFlowCookie, Path: "/auth/oidc", MaxAge: -1, HttpOnly: true})
+
+	if e := r.URL.Query().Get("error"); e != "" {
+		log.Printf("OIDC issuer returned an error: %s %s\n", e, r.URL.Query().Get("error_description"))
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	flow, err := readFlowCookie(r)
+	if err != nil || flow["state"] != r.URL.Query().Get("state") {
+		log.Printf("OIDC callback rejected: %v\n", errOIDCState)
+		http.Error(w, "Login attempt expired or was tampered with, please try again", http.StatusBadRequest)
+		return
+	}
+
+	verifier, _ := flow["verifier"].(string)
+	nonce, _ := flow["nonce"].(string)
+	claims, err := s.provider.Exchange(r.Context(), r.URL.Query().Get("code"), verifier, nonce)
+	if err != nil {
+		log.Printf("OIDC callback rejected: %v\n", err)
+		http.Error(w, "Could not verify your identity", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := s.provision(claims)
+	if err != nil {
+		log.Printf("OIDC provisioning failed: %v\n", err)
+		http.Error(w, "Could not sign you in", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := createAndSetAuthCookie(w, user.ID); err != nil {
+		http.Error(w, "Could not sign you in", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/projects", http.StatusSeeOther)
+}
+
+func readFlowCookie(r *http.Request) (jwt.MapClaims, error) {
+	cookie, err := r.Cookie(oidcFlowCookie)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.Parse(cookie.Value, func(t *jwt.Token) (any, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return flowKey(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, _ := token.Claims.(jwt.MapClaims)
+	return claims, nil
+}
+
+// provision maps verified claims to a user: first by linked identity, then
+// by verified email, otherwise by creating a user without a local password.
+func (s *OIDCService) provision(claims jwt.MapClaims) (*User, error) {
+	issuer, _ := claims["iss"].(string)
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, errOIDCNoSub
+	}
+
+	if user, err := s.store.GetUserByIdentity(issuer, subject); err == nil {
+		return user, nil
+	}
+
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return nil, errOIDCNoMail
+	}
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		return nil, errOIDCUnverified
+	}
+
+	user, err := s.store.GetUserByEmail(email)
+	switch {
+	case err == nil:
+		// The issuer has just proven what the verification email would. The
+		// password is dropped though: whoever registered the address may not
+		// own it, and mustn't keep a way in. Revoking sessions and tokens
+		// covers anything else issued to the pending account.
+		if user.Status == UserPending {
+			if err := s.store.ResetPassword(user.ID, "", time.Now()); err != nil {
+				return nil, err
+			}
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		firstName, _ := claims["given_name"].(string)
+		lastName, _ := claims["family_name"].(string)
+		// No local password: bcrypt never matches an empty hash.
+		user, err = s.store.CreateUser(&User{Email: email, FirstName: firstName, LastName: lastName})
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	if err := s.store.CreateIdentity(user.ID, issuer, subject); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
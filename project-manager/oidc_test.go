@@ -0,0 +1,315 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// fakeIssuer is a minimal OpenID provider: discovery, JWKS and a token
+// endpoint that enforces PKCE. Authorization is simulated by authorize,
+// which plays the part of the user signing in at the issuer.
+type fakeIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	codes  map[string]fakeGrant
+	claims jwt.MapClaims
+}
+
+type fakeGrant struct {
+	challenge string
+	nonce     string
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeIssuer{key: key, codes: map[string]fakeGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		WriteJson(w, http.StatusOK, map[string]string{
+			"issuer":                 f.URL,
+			"authorization_endpoint": f.URL + "/authorize",
+			"token_endpoint":         f.URL + "/token",
+			"jwks_uri":               f.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		WriteJson(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+			"kid": "test-key",
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", f.handleToken)
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// authorize records the grant the user would approve at the issuer and
+// returns the code the issuer would redirect back with.
+func (f *fakeIssuer) authorize(t *testing.T, authURL string) (code, state string) {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != "project-manager" {
+		t.Fatalf("Unexpected authorization request: %s", authURL)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	code = "code-" + q.Get("state")[:8]
+	f.codes[code] = fakeGrant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	return code, q.Get("state")
+}
+
+func (f *fakeIssuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok || clientID != "project-manager" || secret != "s3cret" {
+		WriteJson(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	f.mu.Lock()
+	grant, ok := f.codes[r.FormValue("code")]
+	delete(f.codes, r.FormValue("code"))
+	claims := jwt.MapClaims{}
+	for k, v := range f.claims {
+		claims[k] = v
+	}
+	f.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge {
+		WriteJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	for k, v := range map[string]any{
+		"iss":   f.URL,
+		"aud":   "project-manager",
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Minute).Unix(),
+		"nonce": grant.nonce,
+	} {
+		if _, set := claims[k]; !set {
+			claims[k] = v
+		}
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test-key"
+	idToken, err := token.SignedString(f.key)
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	WriteJson(w, http.StatusOK, map[string]string{"access_token": "opaque", "token_type": "Bearer", "id_token": idToken})
+}
+
+func (f *fakeIssuer) setClaims(claims jwt.MapClaims) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.claims = claims
+}
+
+// signIn runs the browser side of the flow and returns the callback response.
+func signIn(t *testing.T, router http.Handler, issuer *fakeIssuer, tamper func(q url.Values)) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected redirect to the issuer, got %d: %s", rec.Code, rec.Body)
+	}
+
+	code, state := issuer.authorize(t, rec.Header().Get("Location"))
+	q := url.Values{"code": {code}, "state": {state}}
+	if tamper != nil {
+		tamper(q)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?"+q.Encode(), nil)
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func sessionCookie(rec *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "Authorization" && c.Value != "" {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	store := newMemoryStore()
+	provider := NewOIDCProvider(OIDCConfig{
+		Issuer:       issuer.URL,
+		ClientID:     "project-manager",
+		ClientSecret: "s3cret",
+		RedirectURL:  "http://127.0.0.1:3000/auth/oidc/callback",
+		Scopes:       []string{"openid", "email", "profile"},
+	})
+	router := http.NewServeMux()
+	NewOIDCService(store, provider).RegisterRoutes(router)
+
+	t.Run("Provisions a user on first login", func(t *testing.T) {
+		issuer.setClaims(jwt.MapClaims{"sub": "alice-1", "email": "alice@example.com", "email_verified": true, "given_name": "Alice"})
+
+		rec := signIn(t, router, issuer, nil)
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/projects" {
+			t.Fatalf("Expected redirect to /projects, got %d: %s", rec.Code, rec.Body)
+		}
+
+		cookie := sessionCookie(rec)
+		if cookie == nil {
+			t.Fatal("Expected a session cookie")
+		}
+		token, err := validateToken(cookie.Value)
+		if err != nil {
+			t.Fatalf("Expected a valid session token: %v", err)
+		}
+		user := store.users["alice@example.com"]
+		if user == nil || user.FirstName != "Alice" {
+			t.Fatalf("Expected Alice to be provisioned, got %+v", user)
+		}
+		if claims := token.Claims.(jwt.MapClaims); claims["userID"] != "1" {
+			t.Errorf("Expected session for user 1, got %v", claims["userID"])
+		}
+	})
+
+	t.Run("Reuses the linked identity", func(t *testing.T) {
+		issuer.setClaims(jwt.MapClaims{"sub": "alice-1", "email": "alice@new.example.com", "email_verified": true})
+
+		rec := signIn(t, router, issuer, nil)
+		if rec.Code != http.StatusSeeOther || sessionCookie(rec) == nil {
+			t.Fatalf("Expected a session, got %d: %s", rec.Code, rec.Body)
+		}
+		if len(store.users) != 1 {
+			t.Errorf("Expected no new user, got %d users", len(store.users))
+		}
+	})
+
+	t.Run("Refuses to link an unverified email", func(t *testing.T) {
+		issuer.setClaims(jwt.MapClaims{"sub": "mallory", "email": "alice@example.com", "email_verified": false})
+
+		rec := signIn(t, router, issuer, nil)
+		if rec.Code != http.StatusInternalServerError || sessionCookie(rec) != nil {
+			t.Errorf("Expected sign in to be refused, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Refuses to provision an unverified email", func(t *testing.T) {
+		issuer.setClaims(jwt.MapClaims{"sub": "bob-1", "email": "bob@example.com", "email_verified": false})
+
+		rec := signIn(t, router, issuer, nil)
+		if rec.Code != http.StatusInternalServerError || sessionCookie(rec) != nil {
+			t.Errorf("Expected sign in to be refused, got %d", rec.Code)
+		}
+		if store.users["bob@example.com"] != nil {
+			t.Error("Expected no account to be created")
+		}
+	})
+
+	t.Run("Drops the password of a pending account it activates", func(t *testing.T) {
+		// Registered by someone else, waiting for the owner to sign in.
+		hashed, _ := HashPassword("the squatter's passphrase")
+		store.CreateUser(&User{Email: "carol@example.com", Password: hashed, Status: UserPending})
+		issuer.setClaims(jwt.MapClaims{"sub": "carol-1", "email": "carol@example.com", "email_verified": true})
+
+		rec := signIn(t, router, issuer, nil)
+		if rec.Code != http.StatusSeeOther || sessionCookie(rec) == nil {
+			t.Fatalf("Expected a session, got %d: %s", rec.Code, rec.Body)
+		}
+		user := store.users["carol@example.com"]
+		if user.Status != UserActive || user.SessionsRevokedAt == nil {
+			t.Errorf("Expected an active account with sessions revoked, got %+v", user)
+		}
+		if ComparePassword(user.Password, "the squatter's passphrase") {
+			t.Error("Expected the registered password to stop working")
+		}
+	})
+
+	t.Run("Rejects a mismatched state", func(t *testing.T) {
+		issuer.setClaims(jwt.MapClaims{"sub": "alice-1"})
+
+		rec := signIn(t, router, issuer, func(q url.Values) { q.Set("state", "forged") })
+		if rec.Code != http.StatusBadRequest || sessionCookie(rec) != nil {
+			t.Errorf("Expected %d, got %d", http.StatusBadRequest, rec.Code)
+		}
+	})
+
+	t.Run("Rejects a replayed nonce", func(t *testing.T) {
+		issuer.setClaims(jwt.MapClaims{"sub": "alice-1", "nonce": "from-another-login"})
+
+		rec := signIn(t, router, issuer, nil)
+		if rec.Code != http.StatusUnauthorized || sessionCookie(rec) != nil {
+			t.Errorf("Expected %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+	})
+
+	t.Run("Rejects a token for another client", func(t *testing.T) {
+		issuer.setClaims(jwt.MapClaims{"sub": "alice-1", "aud": "someone-else"})
+
+		rec := signIn(t, router, issuer, nil)
+		if rec.Code != http.StatusUnauthorized || sessionCookie(rec) != nil {
+			t.Errorf("Expected %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+	})
+
+	t.Run("Rejects an expired token", func(t *testing.T) {
+		issuer.setClaims(jwt.MapClaims{"sub": "alice-1", "exp": time.Now().Add(-time.Minute).Unix()})
+
+		rec := signIn(t, router, issuer, nil)
+		if rec.Code != http.StatusUnauthorized || sessionCookie(rec) != nil {
+			t.Errorf("Expected %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+	})
+
+	t.Run("Login page offers SSO when configured", func(t *testing.T) {
+		web := NewWebService(store, NewUserService(store, LogMailer{}, &PasswordPolicy{}), NewTasksService(store, nil))
+		web.sso = true
+		webRouter := http.NewServeMux()
+		web.RegisterRoutes(webRouter)
+
+		rec := httptest.NewRecorder()
+		webRouter.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+		if !strings.Contains(rec.Body.String(), `href="/auth/oidc/login"`) {
+			t.Error("Expected a link to /auth/oidc/login")
+		}
+	})
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebAuthn ceremonies, as named in clientDataJSON.
+const (
+	ceremonyCreate = "webauthn.create"
+	ceremonyGet    = "webauthn.get"
+)
+
+// passkeyChallengeTTL is how long the browser may take for a ceremony.
+const passkeyChallengeTTL = 5 * time.Minute
+
+var errUnknownPasskey = errors.New("unknown passkey")
+var errPasskeyRegistered = errors.New("this passkey is already registered")
+
+// Passkey is a WebAuthn credential a user signs in with. PublicKey is the
+// COSE key from registration; SignCount is the authenticator's counter,
+// which must go up on every use unless the authenticator keeps none.
+type Passkey struct {
+	ID           int64      `json:"id"`
+	UserID       int64      `json:"-"`
+	Name         string     `json:"name"`
+	CredentialID base64URL  `json:"credentialId"`
+	PublicKey    []byte     `json:"-"`
+	SignCount    uint32     `json:"-"`
+	LastUsedAt   *time.Time `json:"lastUsedAt"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+// WebAuthnChallenge is a challenge handed to the browser, stored hashed
+// until the ceremony completes. UserID is 0 for sign-ins, where the user
+// is only known from the passkey.
+type WebAuthnChallenge struct {
+	Hash      string
+	UserID    int64
+	Ceremony  string
+	ExpiresAt time.Time
+}
+
+type credentialDescriptor struct {
+	Type string    `json:"type"`
+	ID   base64URL `json:"id"`
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+// credentialCreationOptions and credentialRequestOptions are the JSON form
+// of the options to navigator.credentials.create() and get(), see
+// PublicKeyCredential.parseCreationOptionsFromJSON.
+type credentialCreationOptions struct {
+	Challenge base64URL `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          base64URL `json:"id"`
+		Name        string    `json:"name"`
+		DisplayName string    `json:"displayName"`
+	} `json:"user"`
+	PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []credentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey        string `json:"residentKey"`
+		RequireResidentKey bool   `json:"requireResidentKey"`
+		UserVerification   string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+type credentialRequestOptions struct {
+	Challenge        base64URL              `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	UserVerification string                 `json:"userVerification"`
+	AllowCredentials []credentialDescriptor `json:"allowCredentials"`
+}
+
+type passkeyOptionsResponse[T any] struct {
+	PublicKey T `json:"publicKey"`
+}
+
+// attestationCredential and assertionCredential are the JSON form of the
+// PublicKeyCredential returned by create() and get(), see toJSON().
+type attestationCredential struct {
+	ID       string    `json:"id"`
+	RawID    base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    base64URL `json:"clientDataJSON"`
+		AttestationObject base64URL `json:"attestationObject"`
+	} `json:"response"`
+}
+
+type assertionCredential struct {
+	ID       string    `json:"id"`
+	RawID    base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    base64URL `json:"clientDataJSON"`
+		AuthenticatorData base64URL `json:"authenticatorData"`
+		Signature         base64URL `json:"signature"`
+		UserHandle        base64URL `json:"userHandle"`
+	} `json:"response"`
+}
+
+type registerPasskeyPayload struct {
+	Name       string                `json:"name"`
+	Credential attestationCredential `json:"credential"`
+}
+
+type passkeyLoginPayload struct {
+	Credential assertionCredential `json:"credential"`
+}
+
+func (s *UserService) registerPasskeyRoutes(router *http.ServeMux) {
+	router.HandleFunc("POST /users/me/passkeys/begin", s.handleBeginPasskeyRegistration)
+	router.HandleFunc("POST /users/me/passkeys", s.handleRegisterPasskey)
+	router.HandleFunc("GET /users/me/passkeys", s.handleListPasskeys)
+	router.HandleFunc("DELETE /users/me/passkeys/{id}", s.handleDeletePasskey)
+	router.HandleFunc("POST /users/login/passkey/begin", s.handleBeginPasskeyLogin)
+	router.HandleFunc("POST /users/login/passkey", s.handlePasskeyLogin)
+}
+
+// userHandle is the WebAuthn user ID stored with a passkey. It must not
+// identify the user outside this site, the database ID doesn't.
+func userHandle(userID int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(userID))
+}
+
+// newWebAuthnChallenge stores a single-use challenge for a ceremony.
+func (s *UserService) newWebAuthnChallenge(userID int64, ceremony string) ([]byte, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	err := s.store.SaveWebAuthnChallenge(&WebAuthnChallenge{
+		Hash:      hashUserToken(string(challenge)),
+		UserID:    userID,
+		Ceremony:  ceremony,
+		ExpiresAt: time.Now().Add(passkeyChallengeTTL),
+	})
+	return challenge, err
+}
+
+// consumeClientData checks the client data of a ceremony and uses up its
+// challenge, so every response is accepted at most once.
+func (s *UserService) consumeClientData(raw []byte, ceremony string) (*WebAuthnChallenge, error) {
+	challenge, err := parseClientData(raw, ceremony)
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.store.ConsumeWebAuthnChallenge(ceremony, hashUserToken(string(challenge)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: challenge is unknown, expired or already used", errWebAuthn)
+	}
+	return c, err
+}
+
+func (s *UserService) handleBeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	existing, err := s.store.ListPasskeys(user.ID)
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error listing passkeys: " + err.Error(),
+		})
+		return
+	}
+	challenge, err := s.newWebAuthnChallenge(user.ID, ceremonyCreate)
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error creating challenge: " + err.Error(),
+		})
+		return
+	}
+
+	var opts credentialCreationOptions
+	opts.Challenge = challenge
+	opts.RP.ID, _ = webAuthnRP()
+	opts.RP.Name = totpIssuer
+	opts.User.ID = userHandle(user.ID)
+	opts.User.Name = user.Email
+	opts.User.DisplayName = strings.TrimSpace(user.FirstName + " " + user.LastName)
+	for _, alg := range coseAlgorithms {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, credentialParameter{Type: "public-key", Alg: alg})
+	}
+	opts.Timeout = passkeyChallengeTTL.Milliseconds()
+	// Don't register the same authenticator twice.
+	opts.ExcludeCredentials = []credentialDescriptor{}
+	for _, p := range existing {
+		opts.ExcludeCredentials = append(opts.ExcludeCredentials, credentialDescriptor{Type: "public-key", ID: p.CredentialID})
+	}
+	// Passkeys replace the password, so the authenticator has to remember
+	// the account and verify the user, with a PIN or biometrics.
+	opts.AuthenticatorSelection.ResidentKey = "required"
+	opts.AuthenticatorSelection.RequireResidentKey = true
+	opts.AuthenticatorSelection.UserVerification = "required"
+	opts.Attestation = "none"
+
+	WriteJson(w, http.StatusOK, passkeyOptionsResponse[credentialCreationOptions]{PublicKey: opts})
+}
+
+func (s *UserService) handleRegisterPasskey(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var payload registerPasskeyPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid Request Payload: " + err.Error(),
+		})
+		return
+	}
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" {
+		payload.Name = "Passkey"
+	}
+	if len(payload.Name) > 100 {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid Request Payload: name must be at most 100 characters",
+		})
+		return
+	}
+
+	passkey, err := s.registerPasskey(user, payload)
+	if errors.Is(err, errPasskeyRegistered) {
+		WriteJson(w, http.StatusConflict, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, errWebAuthn) {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error registering passkey: " + err.Error(),
+		})
+		return
+	}
+
+	WriteJson(w, http.StatusCreated, passkey)
+}
+
+// registerPasskey verifies the response to a registration ceremony and
+// stores the new credential.
+func (s *UserService) registerPasskey(user *User, payload registerPasskeyPayload) (*Passkey, error) {
+	cred := payload.Credential
+	if cred.Type != "public-key" {
+		return nil, fmt.Errorf("%w: unexpected credential type %q", errWebAuthn, cred.Type)
+	}
+	c, err := s.consumeClientData(cred.Response.ClientDataJSON, ceremonyCreate)
+	if err != nil {
+		return nil, err
+	}
+	if c.UserID != user.ID {
+		return nil, fmt.Errorf("%w: challenge was issued to another user", errWebAuthn)
+	}
+
+	d, err := parseAttestationObject(cred.Response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(d.CredentialID, cred.RawID) {
+		return nil, fmt.Errorf("%w: credential ID doesn't match", errWebAuthn)
+	}
+	if _, err := s.store.GetPasskey(d.CredentialID); err == nil {
+		return nil, errPasskeyRegistered
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	passkey := &Passkey{
+		UserID:       user.ID,
+		Name:         payload.Name,
+		CredentialID: d.CredentialID,
+		PublicKey:    d.PublicKey,
+		SignCount:    d.SignCount,
+	}
+	if err := s.store.CreatePasskey(passkey); err != nil {
+		return nil, err
+	}
+	return passkey, nil
+}
+
+func (s *UserService) handleListPasskeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	passkeys, err := s.store.ListPasskeys(userID)
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error listing passkeys: " + err.Error(),
+		})
+		return
+	}
+	if passkeys == nil {
+		passkeys = []*Passkey{}
+	}
+
+	WriteJson(w, http.StatusOK, passkeys)
+}
+
+func (s *UserService) handleDeletePasskey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid passkey ID",
+		})
+		return
+	}
+
+	err = s.store.DeletePasskey(userID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		WriteJson(w, http.StatusNotFound, ErrorResponse{
+			Error: "Passkey not found",
+		})
+		return
+	}
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error deleting passkey: " + err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleBeginPasskeyLogin starts a sign-in with any passkey of this site,
+// the browser lets the user pick the account.
+func (s *UserService) handleBeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	if err := s.guard.checkIP(clientIP(r)); err != nil {
+		writeLocked(w, err.(*lockedError))
+		return
+	}
+
+	challenge, err := s.newWebAuthnChallenge(0, ceremonyGet)
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error creating challenge: " + err.Error(),
+		})
+		return
+	}
+
+	rpID, _ := webAuthnRP()
+	WriteJson(w, http.StatusOK, passkeyOptionsResponse[credentialRequestOptions]{PublicKey: credentialRequestOptions{
+		Challenge:        challenge,
+		RPID:             rpID,
+		Timeout:          passkeyChallengeTTL.Milliseconds(),
+		UserVerification: "required",
+		AllowCredentials: []credentialDescriptor{},
+	}})
+}
+
+// handlePasskeyLogin completes a passkey sign-in with the same session as
+// a password sign-in. The passkey verified the user, so there is no
+// second factor to ask for.
+func (s *UserService) handlePasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	var payload passkeyLoginPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid Request Payload: " + err.Error(),
+		})
+		return
+	}
+
+	user, err := s.authenticatePasskey(r.Context(), clientIP(r), payload.Credential)
+	var locked *lockedError
+	if errors.As(err, &locked) {
+		writeLocked(w, locked)
+		return
+	}
+	if errors.Is(err, errWebAuthn) || errors.Is(err, errUnknownPasskey) {
+		WriteJson(w, http.StatusUnauthorized, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error signing in: " + err.Error(),
+		})
+		return
+	}
+
+	token, err := createAndSetAuthCookie(w, user.ID)
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error creating token: " + err.Error(),
+		})
+		return
+	}
+
+	WriteJson(w, http.StatusOK, TokenResponse{Token: token})
+}
+
+// authenticatePasskey verifies the response to a sign-in ceremony. Failed
+// attempts count towards the IP's lockout.
+func (s *UserService) authenticatePasskey(ctx context.Context, ip string, cred assertionCredential) (*User, error) {
+	if err := s.guard.checkIP(ip); err != nil {
+		return nil, err
+	}
+
+	user, err := s.verifyAssertion(cred)
+	if errors.Is(err, errWebAuthn) || errors.Is(err, errUnknownPasskey) {
+		s.guard.fail(ctx, ip, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.guard.succeed(user)
+	return user, nil
+}
+
+func (s *UserService) verifyAssertion(cred assertionCredential) (*User, error) {
+	if cred.Type != "public-key" {
+		return nil, fmt.Errorf("%w: unexpected credential type %q", errWebAuthn, cred.Type)
+	}
+	if _, err := s.consumeClientData(cred.Response.ClientDataJSON, ceremonyGet); err != nil {
+		return nil, err
+	}
+
+	passkey, err := s.store.GetPasskey(cred.RawID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errUnknownPasskey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if h := cred.Response.UserHandle; len(h) > 0 && !bytes.Equal(h, userHandle(passkey.UserID)) {
+		return nil, fmt.Errorf("%w: passkey belongs to another user", errWebAuthn)
+	}
+
+	d, err := parseAuthenticatorData(cred.Response.AuthenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(passkey.PublicKey, d.Raw, cred.Response.ClientDataJSON, cred.Response.Signature); err != nil {
+		return nil, err
+	}
+	// A counter that doesn't go up means two authenticators hold the key.
+	if (d.SignCount != 0 || passkey.SignCount != 0) && d.SignCount <= passkey.SignCount {
+		return nil, fmt.Errorf("%w: sign counter went backwards, the passkey may have been cloned", errWebAuthn)
+	}
+
+	user, err := s.store.GetUserByID(strconv.FormatInt(passkey.UserID, 10))
+	if err != nil {
+		return nil, errUnknownPasskey
+	}
+	// Like access tokens, passkeys added before a password reset could be
+	// an intruder's and stop working.
+	if user.SessionsRevokedAt != nil && passkey.CreatedAt.Before(*user.SessionsRevokedAt) {
+		return nil, errUnknownPasskey
+	}
+
+	if err := s.store.UsePasskey(passkey.ID, d.SignCount, time.Now()); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
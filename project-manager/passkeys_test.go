@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// cborEncode encodes the few CBOR items an authenticator sends.
+func cborEncode(v any) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n <= 0xff:
+			return []byte{major<<5 | 24, byte(n)}
+		case n <= 0xffff:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		}
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[any]any:
+		b := head(5, uint64(len(v)))
+		for k, item := range v {
+			b = append(b, cborEncode(k)...)
+			b = append(b, cborEncode(item)...)
+		}
+		return b
+	}
+	panic("cbor: unsupported type")
+}
+
+// softAuthenticator is a passkey authenticator in software. It verifies the
+// user and keeps a sign counter unless it uses Ed25519.
+type softAuthenticator struct {
+	origin       string
+	credentialID []byte
+	userHandle   []byte
+	es256        *ecdsa.PrivateKey
+	ed25519      ed25519.PrivateKey
+	signCount    uint32
+}
+
+func newSoftAuthenticator(alg int) *softAuthenticator {
+	_, origin := webAuthnRP()
+	a := &softAuthenticator{origin: origin, credentialID: make([]byte, 16)}
+	rand.Read(a.credentialID)
+	if alg == coseEdDSA {
+		_, a.ed25519, _ = ed25519.GenerateKey(rand.Reader)
+	} else {
+		a.es256, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	return a
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	if a.ed25519 != nil {
+		return cborEncode(map[any]any{1: 1, 3: coseEdDSA, -1: 6, -2: []byte(a.ed25519.Public().(ed25519.PublicKey))})
+	}
+	x, y := make([]byte, 32), make([]byte, 32)
+	a.es256.X.FillBytes(x)
+	a.es256.Y.FillBytes(y)
+	return cborEncode(map[any]any{1: 2, 3: coseES256, -1: 1, -2: x, -3: y})
+}
+
+func (a *softAuthenticator) clientData(ceremony string, challenge []byte) []byte {
+	b, _ := json.Marshal(clientData{
+		Type:      ceremony,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    a.origin,
+	})
+	return b
+}
+
+func (a *softAuthenticator) authData(rpID string, attested bool) []byte {
+	if a.es256 != nil {
+		a.signCount++
+	}
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	flags := byte(flagUserPresent | flagUserVerified)
+	if attested {
+		flags |= flagAttestedCredential
+	}
+	b := binary.BigEndian.AppendUint32(append(rpIDHash[:], flags), a.signCount)
+	if attested {
+		b = append(b, make([]byte, 16)...) // AAGUID
+		b = binary.BigEndian.AppendUint16(b, uint16(len(a.credentialID)))
+		b = append(append(b, a.credentialID...), a.coseKey()...)
+	}
+	return b
+}
+
+func (a *softAuthenticator) create(opts credentialCreationOptions) attestationCredential {
+	a.userHandle = opts.User.ID
+
+	var cred attestationCredential
+	cred.ID = base64.RawURLEncoding.EncodeToString(a.credentialID)
+	cred.RawID = a.credentialID
+	cred.Type = "public-key"
+	cred.Response.ClientDataJSON = a.clientData(ceremonyCreate, opts.Challenge)
+	cred.Response.AttestationObject = cborEncode(map[any]any{
+		"fmt":      "none",
+		"attStmt":  map[any]any{},
+		"authData": a.authData(opts.RP.ID, true),
+	})
+	return cred
+}
+
+func (a *softAuthenticator) get(opts credentialRequestOptions) assertionCredential {
+	var cred assertionCredential
+	cred.ID = base64.RawURLEncoding.EncodeToString(a.credentialID)
+	cred.RawID = a.credentialID
+	cred.Type = "public-key"
+	cred.Response.ClientDataJSON = a.clientData(ceremonyGet, opts.Challenge)
+	cred.Response.AuthenticatorData = a.authData(opts.RPID, false)
+	cred.Response.UserHandle = a.userHandle
+
+	hash := sha256.Sum256(cred.Response.ClientDataJSON)
+	signed := append(append([]byte{}, cred.Response.AuthenticatorData...), hash[:]...)
+	if a.ed25519 != nil {
+		cred.Response.Signature = ed25519.Sign(a.ed25519, signed)
+	} else {
+		digest := sha256.Sum256(signed)
+		cred.Response.Signature, _ = ecdsa.SignASN1(rand.Reader, a.es256, digest[:])
+	}
+	return cred
+}
+
+func TestPasskeys(t *testing.T) {
+	store := newMemoryStore()
+	hashed, _ := HashPassword("a long enough passphrase")
+	ada, _ := store.CreateUser(&User{Email: "ada@example.com", Password: hashed})
+	grace, _ := store.CreateUser(&User{Email: "grace@example.com", Password: hashed})
+
+	api, _, err := NewAPIServer("", store).Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(method, path, token string, body any) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, "/api/v1"+path, bytes.NewReader(b))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec
+	}
+	session, _ := CreateJWT(ada.ID, []byte(Envs.JWTSecret))
+
+	register := func(a *softAuthenticator, name string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := call(http.MethodPost, "/users/me/passkeys/begin", session, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+		}
+		var opts passkeyOptionsResponse[credentialCreationOptions]
+		json.NewDecoder(rec.Body).Decode(&opts)
+		return call(http.MethodPost, "/users/me/passkeys", session, registerPasskeyPayload{Name: name, Credential: a.create(opts.PublicKey)})
+	}
+	login := func(a *softAuthenticator) (assertionCredential, *httptest.ResponseRecorder) {
+		t.Helper()
+		rec := call(http.MethodPost, "/users/login/passkey/begin", "", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+		}
+		var opts passkeyOptionsResponse[credentialRequestOptions]
+		json.NewDecoder(rec.Body).Decode(&opts)
+		cred := a.get(opts.PublicKey)
+		return cred, call(http.MethodPost, "/users/login/passkey", "", passkeyLoginPayload{Credential: cred})
+	}
+
+	laptop := newSoftAuthenticator(coseES256)
+	phone := newSoftAuthenticator(coseEdDSA)
+
+	t.Run("Registers several passkeys", func(t *testing.T) {
+		for i, a := range []*softAuthenticator{laptop, phone} {
+			if rec := register(a, []string{"Laptop", "Phone"}[i]); rec.Code != http.StatusCreated {
+				t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body)
+			}
+		}
+		if !bytes.Equal(laptop.userHandle, userHandle(ada.ID)) {
+			t.Errorf("Unexpected user handle %x", laptop.userHandle)
+		}
+
+		rec := call(http.MethodGet, "/users/me/passkeys", session, nil)
+		var passkeys []*Passkey
+		json.NewDecoder(rec.Body).Decode(&passkeys)
+		if len(passkeys) != 2 || !bytes.Equal(passkeys[1].CredentialID, phone.credentialID) || passkeys[0].Name != "Laptop" {
+			t.Errorf("Unexpected passkeys %+v", passkeys)
+		}
+
+		if rec := register(laptop, "Again"); rec.Code != http.StatusConflict {
+			t.Errorf("Expected registering the same passkey to conflict, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Signs in with a passkey", func(t *testing.T) {
+		for _, a := range []*softAuthenticator{laptop, phone, laptop} {
+			_, rec := login(a)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+			}
+			var res TokenResponse
+			json.NewDecoder(rec.Body).Decode(&res)
+			if me := call(http.MethodGet, "/users/me", res.Token, nil); me.Code != http.StatusOK {
+				t.Errorf("Expected the token to work, got %d", me.Code)
+			}
+		}
+		if store.passkeys[0].SignCount != laptop.signCount || store.passkeys[0].LastUsedAt == nil {
+			t.Errorf("Expected the sign counter and last use to be stored, got %+v", store.passkeys[0])
+		}
+	})
+
+	t.Run("A response is only accepted once", func(t *testing.T) {
+		cred, _ := login(phone)
+		if rec := call(http.MethodPost, "/users/login/passkey", "", passkeyLoginPayload{Credential: cred}); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+	})
+
+	t.Run("Rejects a cloned passkey", func(t *testing.T) {
+		clone := *laptop
+		clone.signCount = 1
+		if _, rec := login(&clone); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+	})
+
+	t.Run("Rejects other origins", func(t *testing.T) {
+		phished := *phone
+		phished.origin = "https://pr0ject-manager.example"
+		if _, rec := login(&phished); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+	})
+
+	t.Run("Only the owner can delete a passkey", func(t *testing.T) {
+		other, _ := CreateJWT(grace.ID, []byte(Envs.JWTSecret))
+		path := "/users/me/passkeys/" + strconv.FormatInt(store.passkeys[1].ID, 10)
+		if rec := call(http.MethodDelete, path, other, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status code %d, got %d", http.StatusNotFound, rec.Code)
+		}
+		if rec := call(http.MethodDelete, path, session, nil); rec.Code != http.StatusNoContent {
+			t.Fatalf("Expected status code %d, got %d", http.StatusNoContent, rec.Code)
+		}
+		if _, rec := login(phone); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected a deleted passkey to be rejected, got %d", rec.Code)
+		}
+	})
+}
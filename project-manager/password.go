@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	errPasswordIsEmail  = errors.New("password must not be your email address")
+	errPasswordBreached = errors.New("password appears in a list of breached passwords, choose another one")
+)
+
+// PasswordPolicy is checked on registration and password changes.
+type PasswordPolicy struct {
+	MinLength int
+	// breached holds lowercase passwords and uppercase SHA-1 hex digests,
+	// so both plain word lists and Have I Been Pwned dumps can be used.
+	breached map[string]struct{}
+}
+
+// bcrypt ignores everything past 72 bytes.
+const maxPasswordBytes = 72
+
+// LoadPasswordPolicy reads the breached password list from path, one entry
+// per line. Lines that are SHA-1 digests, optionally followed by ":count"
+// as in the Have I Been Pwned downloads, match by hash. An empty path
+// disables the check.
+func LoadPasswordPolicy(minLength int, path string) (*PasswordPolicy, error) {
+	p := &PasswordPolicy{MinLength: minLength, breached: make(map[string]struct{})}
+	if path == "" {
+		return p, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("breached password list: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if digest, _, _ := strings.Cut(line, ":"); isSHA1Hex(digest) {
+			p.breached[strings.ToUpper(digest)] = struct{}{}
+			continue
+		}
+		p.breached[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("breached password list: %w", err)
+	}
+	return p, nil
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != sha1.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func (p *PasswordPolicy) Check(email, password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("password must be at most %d bytes", maxPasswordBytes)
+	}
+	if email != "" && strings.EqualFold(strings.TrimSpace(password), strings.TrimSpace(email)) {
+		return errPasswordIsEmail
+	}
+
+	if _, ok := p.breached[strings.ToLower(password)]; ok {
+		return errPasswordBreached
+	}
+	digest := sha1.Sum([]byte(password))
+	if _, ok := p.breached[strings.ToUpper(hex.EncodeToString(digest[:]))]; ok {
+		return errPasswordBreached
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+var errProjectNameRequired = errors.New("project name is required")
+
+type ProjectsService struct {
+	store Store
+}
+
+// ListResponse is one page of a collection. NextCursor is empty on the last
+// page, otherwise it is passed back as ?cursor= to get the next one.
+type ListResponse[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+func NewProjectsService(s Store) *ProjectsService {
+	return &ProjectsService{store: s}
+}
+
+func (s *ProjectsService) RegisterRoutes(r *http.ServeMux) {
+	r.HandleFunc("GET /projects", s.handleListProjects)
+	r.HandleFunc("POST /projects", s.handleCreateProject)
+	r.HandleFunc("GET /projects/{id}", s.handleGetProject)
+	r.HandleFunc("GET /projects/{id}/tasks", s.handleListProjectTasks)
+}
+
+func (s *ProjectsService) handleListProjects(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid pagination: " + err.Error(),
+		})
+		return
+	}
+
+	projects, err := s.store.ListProjects(opts)
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error listing projects: " + err.Error(),
+		})
+		return
+	}
+
+	WriteJson(w, http.StatusOK, newListResponse(projects, opts, func(p *Project) int64 { return p.ID }))
+}
+
+func (s *ProjectsService) handleCreateProject(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error reading request body: " + err.Error(),
+		})
+		return
+	}
+
+	defer r.Body.Close()
+
+	var project *Project
+	err = json.Unmarshal(body, &project)
+	if err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid JSON payload: " + err.Error(),
+		})
+		return
+	}
+
+	if err := project.validate(); err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid project payload: " + err.Error(),
+		})
+		return
+	}
+
+	ownerID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+	project.OwnerID = ownerID
+
+	p, err := s.store.CreateProject(project)
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error creating project: " + err.Error(),
+		})
+		return
+	}
+
+	WriteJson(w, http.StatusCreated, p)
+}
+
+func (s *ProjectsService) handleGetProject(w http.ResponseWriter, r *http.Request) {
+	p, err := s.store.GetProject(r.PathValue("id"))
+	if err != nil {
+		WriteJson(w, http.StatusNotFound, ErrorResponse{
+			Error: "Error getting project: " + err.Error(),
+		})
+		return
+	}
+
+	WriteJson(w, http.StatusOK, p)
+}
+
+func (s *ProjectsService) handleListProjectTasks(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid pagination: " + err.Error(),
+		})
+		return
+	}
+
+	tasks, err := s.store.ListProjectTasks(r.PathValue("id"), opts)
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error listing tasks: " + err.Error(),
+		})
+		return
+	}
+
+	WriteJson(w, http.StatusOK, newListResponse(tasks, opts, func(t *Task) int64 { return t.ID }))
+}
+
+// canAccessProject reports whether the user may see and change the
+// project's tasks, and follow its events: its members and admins can.
+func canAccessProject(store Store, projectID, userID int64) (bool, error) {
+	member, err := store.IsProjectMember(projectID, userID)
+	if err != nil || member {
+		return member, err
+	}
+
+	user, err := store.GetUserByID(strconv.FormatInt(userID, 10))
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil && user.Role == RoleAdmin, err
+}
+
+func (p *Project) validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return errProjectNameRequired
+	}
+	return nil
+}
+
+// parseListOptions reads ?limit= and ?cursor=. One extra row is requested so
+// newListResponse can tell whether there is a next page.
+func parseListOptions(r *http.Request) (ListOptions, error) {
+	opts := ListOptions{Limit: defaultPageSize}
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxPageSize {
+			return opts, errors.New("limit must be between 1 and " + strconv.Itoa(maxPageSize))
+		}
+		opts.Limit = limit
+	}
+
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		after, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || after < 0 {
+			return opts, errors.New("invalid cursor")
+		}
+		opts.AfterID = after
+	}
+
+	opts.Limit++
+	return opts, nil
+}
+
+func newListResponse[T any](items []T, opts ListOptions, id func(T) int64) ListResponse[T] {
+	res := ListResponse[T]{Items: items}
+	if res.Items == nil {
+		res.Items = []T{}
+	}
+
+	if pageSize := opts.Limit - 1; len(items) > pageSize {
+		res.Items = items[:pageSize]
+		res.NextCursor = strconv.FormatInt(id(res.Items[pageSize-1]), 10)
+	}
+	return res
+}
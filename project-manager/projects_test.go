@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestListProjects(t *testing.T) {
+	router := http.NewServeMux()
+	NewProjectsService(&MockStore{}).RegisterRoutes(router)
+
+	t.Run("Returns a page", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/projects?limit=10", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+		}
+
+		var page ListResponse[*Project]
+		if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Items) != 1 || page.NextCursor != "" {
+			t.Errorf("Expected a single last page, got %+v", page)
+		}
+	})
+
+	t.Run("Invalid limit", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/projects?limit=1000", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rec.Code)
+		}
+	})
+}
+
+func TestNewListResponse(t *testing.T) {
+	tasks := []*Task{{ID: 3}, {ID: 5}, {ID: 8}}
+
+	res := newListResponse(tasks, ListOptions{Limit: 3}, func(t *Task) int64 { return t.ID })
+	if len(res.Items) != 2 || res.NextCursor != "5" {
+		t.Errorf("Expected 2 items and cursor 5, got %d items and cursor %q", len(res.Items), res.NextCursor)
+	}
+}
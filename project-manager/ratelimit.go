@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/ZiadMansourM/middleware/middleware"
+)
+
+// authRateLimitRoutes are the routes guessing credentials or sending mail,
+// limited by Config.AuthRateLimit on top of the LoginGuard lockouts.
+var authRateLimitRoutes = []string{
+	"POST /api/{version}/users/register",
+	"POST /api/{version}/users/login",
+	"POST /api/{version}/users/login/mfa",
+	"POST /api/{version}/users/login/passkey",
+	"POST /api/{version}/users/verify",
+	"POST /api/{version}/users/password/forgot",
+	"POST /api/{version}/users/password/reset",
+	"POST /login",
+	"POST /login/mfa",
+	"POST /forgot-password",
+	"POST /reset-password",
+}
+
+// NewRateLimiter limits every client to c.RateLimit requests per
+// c.RateLimitWindow, and to c.AuthRateLimit on authRateLimitRoutes.
+// Health checks and scrapes aren't limited.
+func NewRateLimiter(c Config) *middleware.RateLimiter {
+	var store middleware.RateLimitStore = middleware.NewMemoryRateLimitStore()
+	if c.RedisAddr != "" {
+		store = middleware.NewRedisRateLimitStore(c.RedisAddr, c.RedisPassword)
+	}
+
+	limiter := middleware.NewRateLimiter(store, rateLimitKey, middleware.Rate{
+		Requests: c.RateLimit,
+		Window:   c.RateLimitWindow,
+	})
+	// A sliding window, so a burst of guesses can't follow a quiet period.
+	auth := middleware.Rate{
+		Requests:  c.AuthRateLimit,
+		Window:    c.RateLimitWindow,
+		Algorithm: middleware.SlidingWindow,
+	}
+	for _, pattern := range authRateLimitRoutes {
+		limiter.Route(pattern, auth)
+	}
+	limiter.Route("GET /api/{version}/health", middleware.Rate{})
+	return limiter
+}
+
+// rateLimitKey counts requests per signed in user, and per client IP
+// otherwise. Run the limiter after RequireAuthMiddleware to see the user.
+func rateLimitKey(r *http.Request) string {
+	if info := authInfoFromContext(r.Context()); info != nil {
+		return "user:" + info.UserID
+	}
+	return "ip:" + clientIP(r)
+}
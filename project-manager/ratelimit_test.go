@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ZiadMansourM/middleware/middleware"
+)
+
+func TestRateLimit(t *testing.T) {
+	store := newMemoryStore()
+	ada, _ := store.CreateUser(&User{Email: "ada@example.com"})
+	token, _ := CreateJWT(ada.ID, []byte(Envs.JWTSecret))
+
+	limiter := NewRateLimiter(Config{RateLimit: 3, RateLimitWindow: time.Minute, AuthRateLimit: 2})
+	handler := MiddlewareChain(
+		RequireAuthMiddleware(store),
+		Middleware(middleware.RateLimit(limiter)),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	serve := func(method, path, ip, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Sign in routes have their own, stricter limit", func(t *testing.T) {
+		for i := range 2 {
+			if rec := serve(http.MethodPost, "/api/v1/users/login", "192.0.2.1", ""); rec.Code != http.StatusNoContent {
+				t.Fatalf("Expected attempt %d to be allowed, got %d", i+1, rec.Code)
+			}
+		}
+		// Both API versions count against the same limit.
+		rec := serve(http.MethodPost, "/api/v2/users/login", "192.0.2.1", "")
+		if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+			t.Errorf("Expected a 429 with Retry-After, got %d %v", rec.Code, rec.Header())
+		}
+		if rec := serve(http.MethodPost, "/api/v1/users/login", "192.0.2.2", ""); rec.Code != http.StatusNoContent {
+			t.Errorf("Expected other IPs to be allowed, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Signed in users are limited per user", func(t *testing.T) {
+		for _, ip := range []string{"192.0.2.3", "192.0.2.4", "192.0.2.5"} {
+			rec := serve(http.MethodGet, "/api/v1/users/me", ip, token)
+			if rec.Code != http.StatusNoContent {
+				t.Fatalf("Expected the request to be allowed, got %d", rec.Code)
+			}
+		}
+		rec := serve(http.MethodGet, "/api/v1/users/me", "192.0.2.6", token)
+		if rec.Code != http.StatusTooManyRequests {
+			t.Errorf("Expected the 4th request from any IP to be denied, got %d", rec.Code)
+		}
+		if got := rec.Header().Get("RateLimit-Policy"); got != "3;w=60" {
+			t.Errorf("Expected the default policy, got %q", got)
+		}
+	})
+
+	t.Run("Health checks aren't limited", func(t *testing.T) {
+		for range 5 {
+			rec := serve(http.MethodGet, "/api/v1/health", "192.0.2.7", token)
+			if rec.Code != http.StatusNoContent || rec.Header().Get("RateLimit-Limit") != "" {
+				t.Fatalf("Expected health checks not to be limited, got %d %v", rec.Code, rec.Header())
+			}
+		}
+	})
+}
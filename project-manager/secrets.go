@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// The defaults let the server run on a laptop without any setup. They are
+// public, so production refuses them.
+const (
+	defaultJWTSecret  = "2xFavbztyHyRVFxuWrwtPtSQuwuQ1Y9i"
+	defaultDBPassword = "P@ssw0rd"
+)
+
+const (
+	// minJWTSecretLength is 256 bits, the size of an HS256 key.
+	minJWTSecretLength  = 32
+	minDBPasswordLength = 12
+)
+
+// jwtSecret is the HS256 secret in use. It starts as Envs.JWTSecret and is
+// replaced on SIGHUP, see watchJWTSecret.
+var jwtSecret atomic.Pointer[string]
+
+func init() {
+	secret := Envs.JWTSecret
+	jwtSecret.Store(&secret)
+}
+
+// currentJWTSecret returns the secret to sign and verify with. Anything
+// derived from it must be derived on each use, or a reload would miss it.
+func currentJWTSecret() string {
+	return *jwtSecret.Load()
+}
+
+// lookupSecret reads a secret from the file named by key+"_FILE", the way
+// Docker and Kubernetes mount secrets, or from key itself. A trailing
+// newline in the file is dropped.
+func lookupSecret(key string) (string, bool, error) {
+	path, fromFile := os.LookupEnv(key + "_FILE")
+	value, fromEnv := os.LookupEnv(key)
+	if fromFile && fromEnv {
+		return "", false, fmt.Errorf("set either %s or %s_FILE, not both", key, key)
+	}
+	if !fromFile {
+		return value, fromEnv, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("reading %s_FILE: %w", key, err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), true, nil
+}
+
+// checkSecrets refuses the default and weak secrets in production.
+func (c Config) checkSecrets() error {
+	if c.JWTSecret == "" {
+		return errors.New("JWT_SECRET must not be empty")
+	}
+	if c.AppEnv != "production" {
+		return nil
+	}
+
+	var errs []error
+	if err := checkJWTSecret(c.JWTSecret); err != nil {
+		errs = append(errs, err)
+	}
+	switch {
+	case c.DBPassword == defaultDBPassword:
+		errs = append(errs, errors.New("DB_PASSWORD is the public default, set your own"))
+	case len(c.DBPassword) < minDBPasswordLength:
+		errs = append(errs, fmt.Errorf("DB_PASSWORD must be at least %d characters", minDBPasswordLength))
+	}
+	return errors.Join(errs...)
+}
+
+func checkJWTSecret(secret string) error {
+	switch {
+	case secret == defaultJWTSecret:
+		return errors.New("JWT_SECRET is the public default, set your own")
+	case len(secret) < minJWTSecretLength:
+		return fmt.Errorf("JWT_SECRET must be at least %d bytes", minJWTSecretLength)
+	}
+	return nil
+}
+
+// reloadJWTSecret reads JWT_SECRET (or JWT_SECRET_FILE) again. Sessions
+// signed with the old secret stop working, which is the point when it
+// leaked. On error the old secret stays in use.
+func reloadJWTSecret() error {
+	secret, ok, err := lookupSecret("JWT_SECRET")
+	if err != nil {
+		return err
+	}
+	if !ok || secret == "" {
+		return errors.New("JWT_SECRET is not set")
+	}
+	if Envs.AppEnv == "production" {
+		if err := checkJWTSecret(secret); err != nil {
+			return err
+		}
+	}
+
+	jwtSecret.Store(&secret)
+	return nil
+}
+
+// watchJWTSecret reloads the JWT secret on every SIGHUP until stop is closed.
+func watchJWTSecret(stop <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-hup:
+			if err := reloadJWTSecret(); err != nil {
+				log.Printf("Reloading JWT secret: %v\n", err)
+				continue
+			}
+			log.Println("Reloaded JWT secret")
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLookupSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db_password")
+	os.WriteFile(path, []byte("from a mounted secret\n"), 0o600)
+
+	t.Setenv("DB_PASSWORD_FILE", path)
+	if v, ok, err := lookupSecret("DB_PASSWORD"); err != nil || !ok || v != "from a mounted secret" {
+		t.Errorf("Expected the file's content without the newline, got %q, %v, %v", v, ok, err)
+	}
+
+	t.Setenv("DB_PASSWORD", "from the environment")
+	if _, _, err := lookupSecret("DB_PASSWORD"); err == nil {
+		t.Error("Expected setting both to be an error")
+	}
+
+	t.Setenv("DB_PASSWORD_FILE", filepath.Join(t.TempDir(), "missing"))
+	t.Setenv("DB_PASSWORD", "")
+	os.Unsetenv("DB_PASSWORD")
+	if _, _, err := lookupSecret("DB_PASSWORD"); err == nil {
+		t.Error("Expected a missing file to be an error")
+	}
+}
+
+func TestCheckSecrets(t *testing.T) {
+	strong := Config{
+		AppEnv:     "production",
+		JWTSecret:  strings.Repeat("k", minJWTSecretLength),
+		DBPassword: "correct horse battery staple",
+	}
+	if err := strong.checkSecrets(); err != nil {
+		t.Errorf("Expected strong secrets to pass, got %v", err)
+	}
+
+	for name, c := range map[string]Config{
+		"default JWT secret":  {AppEnv: "production", JWTSecret: defaultJWTSecret, DBPassword: strong.DBPassword},
+		"short JWT secret":    {AppEnv: "production", JWTSecret: "secret", DBPassword: strong.DBPassword},
+		"default DB password": {AppEnv: "production", JWTSecret: strong.JWTSecret, DBPassword: defaultDBPassword},
+		"short DB password":   {AppEnv: "production", JWTSecret: strong.JWTSecret, DBPassword: "hunter2"},
+		"empty JWT secret":    {JWTSecret: ""},
+	} {
+		if err := c.checkSecrets(); err == nil {
+			t.Errorf("Expected %s to be refused", name)
+		}
+	}
+
+	dev := Config{AppEnv: "development", JWTSecret: defaultJWTSecret, DBPassword: defaultDBPassword}
+	if err := dev.checkSecrets(); err != nil {
+		t.Errorf("Expected the defaults to be fine in development, got %v", err)
+	}
+}
+
+func TestReloadJWTSecret(t *testing.T) {
+	old := currentJWTSecret()
+	t.Cleanup(func() { jwtSecret.Store(&old) })
+
+	before, _ := CreateJWT(42, []byte(old))
+
+	path := filepath.Join(t.TempDir(), "jwt_secret")
+	os.WriteFile(path, []byte(strings.Repeat("n", minJWTSecretLength)), 0o600)
+	t.Setenv("JWT_SECRET_FILE", path)
+	t.Setenv("JWT_SECRET", "")
+	os.Unsetenv("JWT_SECRET")
+	if err := reloadJWTSecret(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := validateToken(before); err == nil {
+		t.Error("Expected tokens signed with the old secret to be rejected")
+	}
+	after, _ := CreateJWT(42, []byte(currentJWTSecret()))
+	if _, err := validateToken(after); err != nil {
+		t.Errorf("Expected tokens signed with the new secret to be accepted, got %v", err)
+	}
+
+	os.WriteFile(path, nil, 0o600)
+	if err := reloadJWTSecret(); err == nil {
+		t.Error("Expected an empty secret to be refused")
+	}
+	if currentJWTSecret() == "" {
+		t.Error("Expected the previous secret to stay in use")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Browsers authenticate with the session JWT in an HttpOnly cookie. Because
+// the browser attaches it to cross-site requests too, unsafe methods must
+// also carry a CSRF token: a MAC of the session, readable by our pages from
+// the csrf_token cookie, sent back in the X-CSRF-Token header or the
+// csrf_token form field. Bearer tokens are never sent by the browser on its
+// own, so API clients don't need one.
+const (
+	sessionCookieName = "Authorization"
+	sessionTTL        = 120 * 24 * time.Hour
+	csrfCookieName    = "csrf_token"
+	csrfHeader        = "X-CSRF-Token"
+	csrfFormField     = "csrf_token"
+)
+
+func setSessionCookies(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(sessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   Envs.SessionCookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    csrfToken(token),
+		Path:     "/",
+		MaxAge:   int(sessionTTL.Seconds()),
+		Secure:   Envs.SessionCookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{sessionCookieName, csrfCookieName} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: name == sessionCookieName,
+			Secure:   Envs.SessionCookieSecure,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+}
+
+// sessionToken returns the session JWT from the cookie, if any.
+func sessionToken(r *http.Request) string {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(cookie.Value, "Bearer ")
+}
+
+// csrfToken binds the CSRF token to the session, so a token planted by an
+// attacker (e.g. through a sibling subdomain cookie) is useless without the
+// victim's session, and no server-side state is needed.
+func csrfToken(session string) string {
+	key := sha256.Sum256([]byte("csrf:" + currentJWTSecret()))
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte(session))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// validCSRF reports whether a cookie-authenticated request may proceed.
+func validCSRF(r *http.Request, session string) bool {
+	if isSafeMethod(r.Method) {
+		return true
+	}
+
+	got := r.Header.Get(csrfHeader)
+	if got == "" {
+		got = r.PostFormValue(csrfFormField)
+	}
+	return got != "" && hmac.Equal([]byte(got), []byte(csrfToken(session)))
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSessionCookies(t *testing.T) {
+	rec := httptest.NewRecorder()
+	token, err := createAndSetAuthCookie(rec, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := map[string]*http.Cookie{}
+	for _, c := range rec.Result().Cookies() {
+		cookies[c.Name] = c
+	}
+
+	session := cookies[sessionCookieName]
+	if session == nil || session.Value != token {
+		t.Fatal("Expected the session cookie to carry the token")
+	}
+	if !session.HttpOnly || !session.Secure || session.SameSite != http.SameSiteLaxMode || session.Path != "/" {
+		t.Errorf("Expected a hardened session cookie, got %+v", session)
+	}
+
+	csrf := cookies[csrfCookieName]
+	if csrf == nil || csrf.Value != csrfToken(token) {
+		t.Fatal("Expected the CSRF cookie to carry the token's CSRF token")
+	}
+	if csrf.HttpOnly {
+		t.Error("Expected the CSRF cookie to be readable by scripts")
+	}
+}
+
+func TestCookieAuthenticatedAPI(t *testing.T) {
+	handler := newTestAPI(t)
+	token, err := CreateJWT(42, []byte(Envs.JWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, path, body string) *http.Request {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+		return req
+	}
+
+	t.Run("Safe methods need no CSRF token", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, request(http.MethodGet, "/api/v1/users/me", ""))
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+		}
+	})
+
+	t.Run("Unsafe methods without a CSRF token are forbidden", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, request(http.MethodPost, "/api/v1/projects", `{"name": "Forged"}`))
+
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("Expected status code %d, got %d", http.StatusForbidden, rec.Code)
+		}
+	})
+
+	t.Run("CSRF token of another session is forbidden", func(t *testing.T) {
+		other, _ := CreateJWT(7, []byte(Envs.JWTSecret))
+		req := request(http.MethodPost, "/api/v1/projects", `{"name": "Forged"}`)
+		req.Header.Set(csrfHeader, csrfToken(other))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("Expected status code %d, got %d", http.StatusForbidden, rec.Code)
+		}
+	})
+
+	t.Run("Unsafe methods with the CSRF header are allowed", func(t *testing.T) {
+		req := request(http.MethodPost, "/api/v1/projects", `{"name": "Mine"}`)
+		req.Header.Set(csrfHeader, csrfToken(token))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusCreated {
+			t.Errorf("Expected status code %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("Bearer tokens need no CSRF token", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/projects", strings.NewReader(`{"name": "CI"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusCreated {
+			t.Errorf("Expected status code %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body)
+		}
+	})
+}
+
+func TestWebCSRF(t *testing.T) {
+	router := newWebRouter()
+	token, err := CreateJWT(42, []byte(Envs.JWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Forms without the token are forbidden", func(t *testing.T) {
+		rec := post("/projects", url.Values{"name": {"Forged"}})
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("Expected status code %d, got %d", http.StatusForbidden, rec.Code)
+		}
+	})
+
+	t.Run("Forms with the token are accepted", func(t *testing.T) {
+		rec := post("/projects", url.Values{"name": {"Mine"}, csrfFormField: {csrfToken(token)}})
+		if rec.Code != http.StatusSeeOther {
+			t.Errorf("Expected status code %d, got %d", http.StatusSeeOther, rec.Code)
+		}
+	})
+
+	t.Run("Pages embed the token for forms and htmx", func(t *testing.T) {
+		req := withSessionCookie(t, httptest.NewRequest(http.MethodGet, "/projects", nil))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		body := rec.Body.String()
+		if !strings.Contains(body, `name="csrf_token" value="`+csrfToken(sessionToken(req))+`"`) {
+			t.Error("Expected the CSRF token in the page forms")
+		}
+		if !strings.Contains(body, "hx-headers=") {
+			t.Error("Expected htmx to send the CSRF header")
+		}
+	})
+
+	t.Run("Logout clears both cookies", func(t *testing.T) {
+		rec := post("/logout", url.Values{csrfFormField: {csrfToken(token)}})
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("Expected status code %d, got %d", http.StatusSeeOther, rec.Code)
+		}
+
+		cleared := 0
+		for _, c := range rec.Result().Cookies() {
+			if (c.Name == sessionCookieName || c.Name == csrfCookieName) && c.MaxAge < 0 {
+				cleared++
+			}
+		}
+		if cleared != 2 {
+			t.Errorf("Expected both cookies to be cleared, got %d", cleared)
+		}
+	})
+}
@@ -0,0 +1,31 @@
+// Passkey sign-in on the login page, see passkeys.go. The button stays
+// hidden in browsers without the WebAuthn JSON helpers.
+document.addEventListener("DOMContentLoaded", () => {
+	const button = document.getElementById("passkey-login");
+	const error = document.getElementById("passkey-error");
+	if (!button || !window.PublicKeyCredential?.parseRequestOptionsFromJSON) return;
+	button.hidden = false;
+
+	button.addEventListener("click", async () => {
+		error.hidden = true;
+		try {
+			const begin = await fetch("/api/v1/users/login/passkey/begin", { method: "POST" });
+			if (!begin.ok) throw new Error((await begin.json()).error);
+			const { publicKey } = await begin.json();
+			const credential = await navigator.credentials.get({
+				publicKey: PublicKeyCredential.parseRequestOptionsFromJSON(publicKey),
+			});
+			const res = await fetch("/api/v1/users/login/passkey", {
+				method: "POST",
+				headers: { "Content-Type": "application/json" },
+				body: JSON.stringify({ credential: credential.toJSON() }),
+			});
+			if (!res.ok) throw new Error((await res.json()).error);
+			location.href = "/projects";
+		} catch (err) {
+			if (err.name === "NotAllowedError") return; // the user cancelled
+			error.textContent = err.message;
+			error.hidden = false;
+		}
+	});
+});
@@ -1,14 +1,92 @@
 package main
 
-import "database/sql"
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
 
 type Store interface {
 	// Users
 	CreateUser(u *User) (*User, error)
 	GetUserByID(id string) (*User, error)
+	GetUserByEmail(email string) (*User, error)
+	SetUserStatus(userID int64, status string) error
+	// ResetPassword sets a new password hash, activates the account and
+	// revokes every session and unused token issued before revokedAt.
+	ResetPassword(userID int64, hash string, revokedAt time.Time) error
+	// RecordFailedLogin counts a failed sign-in at the given time and returns
+	// the number of failures since the given time.
+	RecordFailedLogin(userID int64, at, since time.Time) (int, error)
+	LockUser(userID int64, until time.Time) error
+	// UnlockUser lifts the lock and forgets failed sign-ins.
+	UnlockUser(userID int64) error
+	// Audit log
+	RecordAudit(e *AuditEvent) error
+	ListAuditEvents(opts ListOptions) ([]*AuditEvent, error)
+	// Single-use tokens, see UserToken
+	CreateUserToken(t *UserToken) error
+	ConsumeUserToken(purpose, hash string) (*UserToken, error)
+	AttemptUserToken(purpose, hash string) (*UserToken, error)
+	// External identities (OIDC)
+	GetUserByIdentity(issuer, subject string) (*User, error)
+	CreateIdentity(userID int64, issuer, subject string) error
+	// Two-factor authentication, see TOTPEnrollment
+	GetTOTP(userID int64) (*TOTPEnrollment, error)
+	SaveTOTP(e *TOTPEnrollment) error
+	// DeleteTOTP also deletes the recovery codes.
+	DeleteTOTP(userID int64) error
+	// UseTOTPCounter records the time step of an accepted code. It returns
+	// sql.ErrNoRows unless the step is newer than the last one, so a code
+	// can't be replayed.
+	UseTOTPCounter(userID, counter int64) error
+	ReplaceRecoveryCodes(userID int64, hashes []string) error
+	// UseRecoveryCode returns sql.ErrNoRows when there is no unused code.
+	UseRecoveryCode(userID int64, hash string) error
+	// Personal access tokens, see AccessToken
+	CreateAccessToken(t *AccessToken) error
+	GetAccessToken(hash string) (*AccessToken, error)
+	ListAccessTokens(userID int64) ([]*AccessToken, error)
+	// DeleteAccessToken returns sql.ErrNoRows unless the user owns the token.
+	DeleteAccessToken(userID, id int64) error
+	TouchAccessToken(id int64, usedAt time.Time) error
+	// Passkeys, see Passkey
+	CreatePasskey(p *Passkey) error
+	GetPasskey(credentialID []byte) (*Passkey, error)
+	ListPasskeys(userID int64) ([]*Passkey, error)
+	// DeletePasskey returns sql.ErrNoRows unless the user owns the passkey.
+	DeletePasskey(userID, id int64) error
+	UsePasskey(id int64, signCount uint32, usedAt time.Time) error
+	SaveWebAuthnChallenge(c *WebAuthnChallenge) error
+	// ConsumeWebAuthnChallenge deletes an unexpired challenge and returns
+	// it, or sql.ErrNoRows, so a challenge is only used once.
+	ConsumeWebAuthnChallenge(ceremony, hash string) (*WebAuthnChallenge, error)
+	// Projects
+	CreateProject(p *Project) (*Project, error)
+	GetProject(id string) (*Project, error)
+	ListProjects(opts ListOptions) ([]*Project, error)
+	// IsProjectMember reports whether the user owns the project or is
+	// assigned one of its tasks.
+	IsProjectMember(projectID, userID int64) (bool, error)
 	// Tasks
 	CreateTask(t *Task) (*Task, error)
 	GetTask(id string) (*Task, error)
+	UpdateTask(t *Task) (*Task, error)
+	ListProjectTasks(projectID string, opts ListOptions) ([]*Task, error)
+}
+
+// ListOptions pages through rows by ID. A zero Limit returns every row.
+type ListOptions struct {
+	AfterID int64
+	Limit   int
+}
+
+func (o ListOptions) limitClause() string {
+	if o.Limit <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" LIMIT %d", o.Limit)
 }
 
 type Storage struct {
@@ -20,7 +98,14 @@ func NewStore(db *sql.DB) *Storage {
 }
 
 func (s *Storage) CreateUser(u *User) (*User, error) {
-	rows, err := s.db.Exec("INSERT INTO users (email, password, firstName, lastName) VALUES (?, ?, ?, ?)", u.Email, u.Password, u.FirstName, u.LastName)
+	if u.Status == "" {
+		u.Status = UserActive
+	}
+	if u.Role == "" {
+		u.Role = RoleUser
+	}
+
+	rows, err := s.db.Exec("INSERT INTO users (email, password, firstName, lastName, status, role) VALUES (?, ?, ?, ?, ?, ?)", u.Email, u.Password, u.FirstName, u.LastName, u.Status, u.Role)
 	if err != nil {
 		return nil, err
 	}
@@ -35,7 +120,11 @@ func (s *Storage) CreateUser(u *User) (*User, error) {
 }
 
 func (s *Storage) CreateTask(t *Task) (*Task, error) {
-	rows, err := s.db.Exec("INSERT INTO tasks (name, project_id, assigned_to_id) VALUES ($1, $2, $3, $4)", t.Name, t.Status, t.ProjectID, t.AssignedToID)
+	if t.Status == "" {
+		t.Status = "TODO"
+	}
+
+	rows, err := s.db.Exec("INSERT INTO tasks (name, status, projectId, assignedToID) VALUES (?, ?, ?, ?)", t.Name, t.Status, t.ProjectID, t.AssignedToID)
 
 	if err != nil {
 		return nil, err
@@ -52,12 +141,503 @@ func (s *Storage) CreateTask(t *Task) (*Task, error) {
 
 func (s *Storage) GetTask(id string) (*Task, error) {
 	var t Task
-	err := s.db.QueryRow("SELECT id, name, status, project_id, assigned_to_id FROM tasks WHERE id = $1", id).Scan(&t.ID, &t.Name, &t.Status, &t.ProjectID, &t.AssignedToID, &t.CreatedAt)
+	err := s.db.QueryRow("SELECT id, name, status, projectId, assignedToID, createdAt FROM tasks WHERE id = ?", id).Scan(&t.ID, &t.Name, &t.Status, &t.ProjectID, &t.AssignedToID, &t.CreatedAt)
 	return &t, err
 }
 
+func (s *Storage) UpdateTask(t *Task) (*Task, error) {
+	_, err := s.db.Exec("UPDATE tasks SET name = ?, status = ?, assignedToID = ? WHERE id = ?", t.Name, t.Status, t.AssignedToID, t.ID)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
 func (s *Storage) GetUserByID(id string) (*User, error) {
 	var u User
-	err := s.db.QueryRow("SELECT id, email, firstName, lastName, createdAt FROM users WHERE id = ?", id).Scan(&u.ID, &u.Email, &u.FirstName, &u.LastName, &u.CreatedAt)
+	var revokedAt, lockedUntil sql.NullTime
+	err := s.db.QueryRow("SELECT id, email, firstName, lastName, status, role, sessionsRevokedAt, failedLogins, lockedUntil, createdAt FROM users WHERE id = ?", id).Scan(&u.ID, &u.Email, &u.FirstName, &u.LastName, &u.Status, &u.Role, &revokedAt, &u.FailedLogins, &lockedUntil, &u.CreatedAt)
+	if revokedAt.Valid {
+		u.SessionsRevokedAt = &revokedAt.Time
+	}
+	if lockedUntil.Valid {
+		u.LockedUntil = &lockedUntil.Time
+	}
+	return &u, err
+}
+
+func (s *Storage) GetUserByEmail(email string) (*User, error) {
+	var u User
+	var lockedUntil sql.NullTime
+	err := s.db.QueryRow("SELECT id, email, firstName, lastName, password, status, role, failedLogins, lockedUntil, createdAt FROM users WHERE email = ?", email).Scan(&u.ID, &u.Email, &u.FirstName, &u.LastName, &u.Password, &u.Status, &u.Role, &u.FailedLogins, &lockedUntil, &u.CreatedAt)
+	if lockedUntil.Valid {
+		u.LockedUntil = &lockedUntil.Time
+	}
+	return &u, err
+}
+
+func (s *Storage) SetUserStatus(userID int64, status string) error {
+	_, err := s.db.Exec("UPDATE users SET status = ? WHERE id = ?", status, userID)
+	return err
+}
+
+func (s *Storage) ResetPassword(userID int64, hash string, revokedAt time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE users SET password = ?, status = ?, sessionsRevokedAt = ? WHERE id = ?", hash, UserActive, revokedAt, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE user_tokens SET usedAt = ? WHERE userId = ? AND usedAt IS NULL", revokedAt, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecordFailedLogin uses LAST_INSERT_ID(expr) to read the new count back
+// from the same statement, so concurrent failures are all counted.
+func (s *Storage) RecordFailedLogin(userID int64, at, since time.Time) (int, error) {
+	res, err := s.db.Exec("UPDATE users SET failedLogins = LAST_INSERT_ID(IF(lastFailedLoginAt IS NULL OR lastFailedLoginAt < ?, 1, failedLogins + 1)), lastFailedLoginAt = ? WHERE id = ?", since, at, userID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.LastInsertId()
+	return int(n), err
+}
+
+func (s *Storage) LockUser(userID int64, until time.Time) error {
+	_, err := s.db.Exec("UPDATE users SET lockedUntil = ? WHERE id = ?", until, userID)
+	return err
+}
+
+func (s *Storage) UnlockUser(userID int64) error {
+	res, err := s.db.Exec("UPDATE users SET failedLogins = 0, lastFailedLoginAt = NULL, lockedUntil = NULL WHERE id = ?", userID)
+	if err != nil {
+		return err
+	}
+	// MySQL only counts changed rows, and unlocking an unlocked user is fine.
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	var one int
+	return s.db.QueryRow("SELECT 1 FROM users WHERE id = ?", userID).Scan(&one)
+}
+
+func (s *Storage) RecordAudit(e *AuditEvent) error {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	rows, err := s.db.Exec("INSERT INTO audit_log (action, userId, actorId, ip, detail, createdAt) VALUES (?, ?, ?, ?, ?, ?)", e.Action, e.UserID, e.ActorID, e.IP, e.Detail, e.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	e.ID, err = rows.LastInsertId()
+	return err
+}
+
+func (s *Storage) ListAuditEvents(opts ListOptions) ([]*AuditEvent, error) {
+	rows, err := s.db.Query("SELECT id, action, userId, actorId, ip, detail, createdAt FROM audit_log WHERE id > ? ORDER BY id"+opts.limitClause(), opts.AfterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*AuditEvent
+	for rows.Next() {
+		var e AuditEvent
+		var userID, actorID sql.NullInt64
+		if err := rows.Scan(&e.ID, &e.Action, &userID, &actorID, &e.IP, &e.Detail, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if userID.Valid {
+			e.UserID = &userID.Int64
+		}
+		if actorID.Valid {
+			e.ActorID = &actorID.Int64
+		}
+		events = append(events, &e)
+	}
+	return events, rows.Err()
+}
+
+func (s *Storage) CreateUserToken(t *UserToken) error {
+	rows, err := s.db.Exec("INSERT INTO user_tokens (userId, purpose, tokenHash, expiresAt) VALUES (?, ?, ?, ?)", t.UserID, t.Purpose, t.Hash, t.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	t.ID, err = rows.LastInsertId()
+	return err
+}
+
+// ConsumeUserToken marks an unused, unexpired token as used and returns it.
+// It returns sql.ErrNoRows when there is no such token, so a token can only
+// be redeemed once even by concurrent requests.
+func (s *Storage) ConsumeUserToken(purpose, hash string) (*UserToken, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var t UserToken
+	err = tx.QueryRow("SELECT id, userId, purpose, tokenHash, expiresAt, createdAt FROM user_tokens WHERE tokenHash = ? AND purpose = ? AND usedAt IS NULL AND expiresAt > NOW() FOR UPDATE", hash, purpose).Scan(&t.ID, &t.UserID, &t.Purpose, &t.Hash, &t.ExpiresAt, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if _, err := tx.Exec("UPDATE user_tokens SET usedAt = ? WHERE id = ?", now, t.ID); err != nil {
+		return nil, err
+	}
+	t.UsedAt = &now
+	return &t, tx.Commit()
+}
+
+// AttemptUserToken counts a try at redeeming an unused, unexpired token and
+// returns it with the tries so far, sql.ErrNoRows when there is no such
+// token.
+func (s *Storage) AttemptUserToken(purpose, hash string) (*UserToken, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var t UserToken
+	err = tx.QueryRow("SELECT id, userId, purpose, tokenHash, attempts, expiresAt, createdAt FROM user_tokens WHERE tokenHash = ? AND purpose = ? AND usedAt IS NULL AND expiresAt > NOW() FOR UPDATE", hash, purpose).Scan(&t.ID, &t.UserID, &t.Purpose, &t.Hash, &t.Attempts, &t.ExpiresAt, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("UPDATE user_tokens SET attempts = attempts + 1 WHERE id = ?", t.ID); err != nil {
+		return nil, err
+	}
+	t.Attempts++
+	return &t, tx.Commit()
+}
+
+func (s *Storage) GetUserByIdentity(issuer, subject string) (*User, error) {
+	var u User
+	err := s.db.QueryRow("SELECT u.id, u.email, u.firstName, u.lastName, u.createdAt FROM users u JOIN user_identities i ON i.userId = u.id WHERE i.issuer = ? AND i.subject = ?", issuer, subject).Scan(&u.ID, &u.Email, &u.FirstName, &u.LastName, &u.CreatedAt)
 	return &u, err
 }
+
+func (s *Storage) CreateIdentity(userID int64, issuer, subject string) error {
+	_, err := s.db.Exec("INSERT INTO user_identities (userId, issuer, subject) VALUES (?, ?, ?)", userID, issuer, subject)
+	return err
+}
+
+func (s *Storage) GetTOTP(userID int64) (*TOTPEnrollment, error) {
+	var e TOTPEnrollment
+	var confirmedAt sql.NullTime
+	err := s.db.QueryRow("SELECT userId, secret, confirmedAt, lastCounter FROM user_totp WHERE userId = ?", userID).Scan(&e.UserID, &e.Secret, &confirmedAt, &e.LastCounter)
+	if confirmedAt.Valid {
+		e.ConfirmedAt = &confirmedAt.Time
+	}
+	return &e, err
+}
+
+func (s *Storage) SaveTOTP(e *TOTPEnrollment) error {
+	_, err := s.db.Exec("INSERT INTO user_totp (userId, secret, confirmedAt, lastCounter) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE secret = VALUES(secret), confirmedAt = VALUES(confirmedAt), lastCounter = VALUES(lastCounter)", e.UserID, e.Secret, e.ConfirmedAt, e.LastCounter)
+	return err
+}
+
+func (s *Storage) DeleteTOTP(userID int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM mfa_recovery_codes WHERE userId = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_totp WHERE userId = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Storage) UseTOTPCounter(userID, counter int64) error {
+	res, err := s.db.Exec("UPDATE user_totp SET lastCounter = ? WHERE userId = ? AND lastCounter < ?", counter, userID, counter)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+func (s *Storage) ReplaceRecoveryCodes(userID int64, hashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM mfa_recovery_codes WHERE userId = ?", userID); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if _, err := tx.Exec("INSERT INTO mfa_recovery_codes (userId, codeHash) VALUES (?, ?)", userID, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *Storage) UseRecoveryCode(userID int64, hash string) error {
+	res, err := s.db.Exec("UPDATE mfa_recovery_codes SET usedAt = ? WHERE userId = ? AND codeHash = ? AND usedAt IS NULL", time.Now(), userID, hash)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+func (s *Storage) CreateAccessToken(t *AccessToken) error {
+	rows, err := s.db.Exec("INSERT INTO personal_access_tokens (userId, name, scopes, tokenHash, expiresAt) VALUES (?, ?, ?, ?, ?)", t.UserID, t.Name, strings.Join(t.Scopes, " "), t.Hash, t.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	t.ID, err = rows.LastInsertId()
+	if err != nil {
+		return err
+	}
+	t.CreatedAt = time.Now().Truncate(time.Second)
+	return nil
+}
+
+func (s *Storage) GetAccessToken(hash string) (*AccessToken, error) {
+	row := s.db.QueryRow("SELECT id, userId, name, scopes, tokenHash, expiresAt, lastUsedAt, createdAt FROM personal_access_tokens WHERE tokenHash = ?", hash)
+	return scanAccessToken(row)
+}
+
+func (s *Storage) ListAccessTokens(userID int64) ([]*AccessToken, error) {
+	rows, err := s.db.Query("SELECT id, userId, name, scopes, tokenHash, expiresAt, lastUsedAt, createdAt FROM personal_access_tokens WHERE userId = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*AccessToken
+	for rows.Next() {
+		t, err := scanAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func scanAccessToken(row interface{ Scan(...any) error }) (*AccessToken, error) {
+	var t AccessToken
+	var scopes string
+	var lastUsedAt sql.NullTime
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &scopes, &t.Hash, &t.ExpiresAt, &lastUsedAt, &t.CreatedAt); err != nil {
+		return nil, err
+	}
+	t.Scopes = strings.Fields(scopes)
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+	return &t, nil
+}
+
+func (s *Storage) DeleteAccessToken(userID, id int64) error {
+	res, err := s.db.Exec("DELETE FROM personal_access_tokens WHERE id = ? AND userId = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+func (s *Storage) TouchAccessToken(id int64, usedAt time.Time) error {
+	_, err := s.db.Exec("UPDATE personal_access_tokens SET lastUsedAt = ? WHERE id = ?", usedAt, id)
+	return err
+}
+
+func (s *Storage) CreatePasskey(p *Passkey) error {
+	rows, err := s.db.Exec("INSERT INTO passkeys (userId, name, credentialId, publicKey, signCount) VALUES (?, ?, ?, ?, ?)", p.UserID, p.Name, []byte(p.CredentialID), p.PublicKey, p.SignCount)
+	if err != nil {
+		return err
+	}
+
+	p.ID, err = rows.LastInsertId()
+	if err != nil {
+		return err
+	}
+	p.CreatedAt = time.Now().Truncate(time.Second)
+	return nil
+}
+
+func (s *Storage) GetPasskey(credentialID []byte) (*Passkey, error) {
+	row := s.db.QueryRow("SELECT id, userId, name, credentialId, publicKey, signCount, lastUsedAt, createdAt FROM passkeys WHERE credentialId = ?", credentialID)
+	return scanPasskey(row)
+}
+
+func (s *Storage) ListPasskeys(userID int64) ([]*Passkey, error) {
+	rows, err := s.db.Query("SELECT id, userId, name, credentialId, publicKey, signCount, lastUsedAt, createdAt FROM passkeys WHERE userId = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var passkeys []*Passkey
+	for rows.Next() {
+		p, err := scanPasskey(rows)
+		if err != nil {
+			return nil, err
+		}
+		passkeys = append(passkeys, p)
+	}
+	return passkeys, rows.Err()
+}
+
+func scanPasskey(row interface{ Scan(...any) error }) (*Passkey, error) {
+	var p Passkey
+	var credentialID []byte
+	var lastUsedAt sql.NullTime
+	if err := row.Scan(&p.ID, &p.UserID, &p.Name, &credentialID, &p.PublicKey, &p.SignCount, &lastUsedAt, &p.CreatedAt); err != nil {
+		return nil, err
+	}
+	p.CredentialID = credentialID
+	if lastUsedAt.Valid {
+		p.LastUsedAt = &lastUsedAt.Time
+	}
+	return &p, nil
+}
+
+func (s *Storage) DeletePasskey(userID, id int64) error {
+	res, err := s.db.Exec("DELETE FROM passkeys WHERE id = ? AND userId = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+func (s *Storage) UsePasskey(id int64, signCount uint32, usedAt time.Time) error {
+	_, err := s.db.Exec("UPDATE passkeys SET signCount = ?, lastUsedAt = ? WHERE id = ?", signCount, usedAt, id)
+	return err
+}
+
+// SaveWebAuthnChallenge also clears expired challenges, abandoned
+// ceremonies would pile up otherwise.
+func (s *Storage) SaveWebAuthnChallenge(c *WebAuthnChallenge) error {
+	if _, err := s.db.Exec("DELETE FROM webauthn_challenges WHERE expiresAt < NOW()"); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("INSERT INTO webauthn_challenges (challengeHash, userId, ceremony, expiresAt) VALUES (?, ?, ?, ?)", c.Hash, c.UserID, c.Ceremony, c.ExpiresAt)
+	return err
+}
+
+func (s *Storage) ConsumeWebAuthnChallenge(ceremony, hash string) (*WebAuthnChallenge, error) {
+	c := WebAuthnChallenge{Hash: hash, Ceremony: ceremony}
+	err := s.db.QueryRow("SELECT userId, expiresAt FROM webauthn_challenges WHERE challengeHash = ? AND ceremony = ? AND expiresAt > NOW()", hash, ceremony).Scan(&c.UserID, &c.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Of concurrent requests with the same challenge only one deletes it.
+	res, err := s.db.Exec("DELETE FROM webauthn_challenges WHERE challengeHash = ?", hash)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireAffected(res); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// requireAffected turns an update that matched nothing into sql.ErrNoRows.
+func requireAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *Storage) CreateProject(p *Project) (*Project, error) {
+	var ownerID *int64
+	if p.OwnerID != 0 {
+		ownerID = &p.OwnerID
+	}
+	rows, err := s.db.Exec("INSERT INTO projects (name, ownerID) VALUES (?, ?)", p.Name, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := rows.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	p.ID = id
+	return p, nil
+}
+
+func (s *Storage) GetProject(id string) (*Project, error) {
+	var p Project
+	var ownerID sql.NullInt64
+	err := s.db.QueryRow("SELECT id, name, ownerID, createdAt FROM projects WHERE id = ?", id).Scan(&p.ID, &p.Name, &ownerID, &p.CreatedAt)
+	p.OwnerID = ownerID.Int64
+	return &p, err
+}
+
+func (s *Storage) ListProjects(opts ListOptions) ([]*Project, error) {
+	rows, err := s.db.Query("SELECT id, name, ownerID, createdAt FROM projects WHERE id > ? ORDER BY id"+opts.limitClause(), opts.AfterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var projects []*Project
+	for rows.Next() {
+		var p Project
+		var ownerID sql.NullInt64
+		if err := rows.Scan(&p.ID, &p.Name, &ownerID, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		p.OwnerID = ownerID.Int64
+		projects = append(projects, &p)
+	}
+	return projects, rows.Err()
+}
+
+func (s *Storage) IsProjectMember(projectID, userID int64) (bool, error) {
+	var n int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM projects p
+		WHERE p.id = ? AND (p.ownerID = ? OR EXISTS (
+			SELECT 1 FROM tasks t WHERE t.projectId = p.id AND t.assignedToID = ?
+		))`, projectID, userID, userID).Scan(&n)
+	return n > 0, err
+}
+
+func (s *Storage) ListProjectTasks(projectID string, opts ListOptions) ([]*Task, error) {
+	rows, err := s.db.Query("SELECT id, name, status, projectId, assignedToID, createdAt FROM tasks WHERE projectId = ? AND id > ? ORDER BY id"+opts.limitClause(), projectID, opts.AfterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []*Task
+	for rows.Next() {
+		var t Task
+		if err := rows.Scan(&t.ID, &t.Name, &t.Status, &t.ProjectID, &t.AssignedToID, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, &t)
+	}
+	return tasks, rows.Err()
+}
@@ -1,19 +1,613 @@
 package main
 
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
 type MockStore struct{}
 
-func (m *MockStore) CreateUser() error {
-	return nil
+func (m *MockStore) CreateUser(u *User) (*User, error) {
+	return u, nil
 }
 
 func (m *MockStore) CreateTask(t *Task) (*Task, error) {
-	return &Task{}, nil
+	return t, nil
 }
 
 func (m *MockStore) GetTask(id string) (*Task, error) {
 	return &Task{}, nil
 }
 
+func (m *MockStore) UpdateTask(t *Task) (*Task, error) {
+	return t, nil
+}
+
 func (m *MockStore) GetUserByID(id string) (*User, error) {
 	return &User{}, nil
 }
+
+func (m *MockStore) GetUserByEmail(email string) (*User, error) {
+	return &User{Email: email}, nil
+}
+
+func (m *MockStore) SetUserStatus(userID int64, status string) error {
+	return nil
+}
+
+func (m *MockStore) ResetPassword(userID int64, hash string, revokedAt time.Time) error {
+	return nil
+}
+
+func (m *MockStore) CreateUserToken(t *UserToken) error {
+	return nil
+}
+
+func (m *MockStore) ConsumeUserToken(purpose, hash string) (*UserToken, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (m *MockStore) AttemptUserToken(purpose, hash string) (*UserToken, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (m *MockStore) GetUserByIdentity(issuer, subject string) (*User, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (m *MockStore) CreateIdentity(userID int64, issuer, subject string) error {
+	return nil
+}
+
+func (m *MockStore) GetTOTP(userID int64) (*TOTPEnrollment, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (m *MockStore) SaveTOTP(e *TOTPEnrollment) error {
+	return nil
+}
+
+func (m *MockStore) DeleteTOTP(userID int64) error {
+	return nil
+}
+
+func (m *MockStore) UseTOTPCounter(userID, counter int64) error {
+	return sql.ErrNoRows
+}
+
+func (m *MockStore) ReplaceRecoveryCodes(userID int64, hashes []string) error {
+	return nil
+}
+
+func (m *MockStore) UseRecoveryCode(userID int64, hash string) error {
+	return sql.ErrNoRows
+}
+
+func (m *MockStore) CreateAccessToken(t *AccessToken) error {
+	return nil
+}
+
+func (m *MockStore) GetAccessToken(hash string) (*AccessToken, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (m *MockStore) ListAccessTokens(userID int64) ([]*AccessToken, error) {
+	return nil, nil
+}
+
+func (m *MockStore) DeleteAccessToken(userID, id int64) error {
+	return sql.ErrNoRows
+}
+
+func (m *MockStore) TouchAccessToken(id int64, usedAt time.Time) error {
+	return nil
+}
+
+func (m *MockStore) CreatePasskey(p *Passkey) error {
+	return nil
+}
+
+func (m *MockStore) GetPasskey(credentialID []byte) (*Passkey, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (m *MockStore) ListPasskeys(userID int64) ([]*Passkey, error) {
+	return nil, nil
+}
+
+func (m *MockStore) DeletePasskey(userID, id int64) error {
+	return sql.ErrNoRows
+}
+
+func (m *MockStore) UsePasskey(id int64, signCount uint32, usedAt time.Time) error {
+	return nil
+}
+
+func (m *MockStore) SaveWebAuthnChallenge(c *WebAuthnChallenge) error {
+	return nil
+}
+
+func (m *MockStore) ConsumeWebAuthnChallenge(ceremony, hash string) (*WebAuthnChallenge, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (m *MockStore) RecordFailedLogin(userID int64, at, since time.Time) (int, error) {
+	return 0, nil
+}
+
+func (m *MockStore) LockUser(userID int64, until time.Time) error {
+	return nil
+}
+
+func (m *MockStore) UnlockUser(userID int64) error {
+	return nil
+}
+
+func (m *MockStore) RecordAudit(e *AuditEvent) error {
+	return nil
+}
+
+func (m *MockStore) ListAuditEvents(opts ListOptions) ([]*AuditEvent, error) {
+	return nil, nil
+}
+
+func (m *MockStore) CreateProject(p *Project) (*Project, error) {
+	return p, nil
+}
+
+func (m *MockStore) GetProject(id string) (*Project, error) {
+	return &Project{ID: 1, Name: "Test Project"}, nil
+}
+
+func (m *MockStore) ListProjects(opts ListOptions) ([]*Project, error) {
+	return []*Project{{ID: 1, Name: "Test Project"}}, nil
+}
+
+// IsProjectMember counts user 42 in, every task is assigned to them.
+func (m *MockStore) IsProjectMember(projectID, userID int64) (bool, error) {
+	return userID == 42, nil
+}
+
+func (m *MockStore) ListProjectTasks(projectID string, opts ListOptions) ([]*Task, error) {
+	return []*Task{
+		{ID: 1, Name: "Write docs", Status: "TODO", ProjectID: 1, AssignedToID: 42},
+		{ID: 2, Name: "Ship it", Status: "DONE", ProjectID: 1, AssignedToID: 42},
+	}, nil
+}
+
+// memoryStore keeps users, identities, tokens, second factors, access
+// tokens, passkeys and the audit log in memory on top of MockStore, for
+// tests that follow an account through several requests.
+type memoryStore struct {
+	MockStore
+	mu            sync.Mutex
+	users         map[string]*User
+	identities    map[string]int64
+	tokens        []*UserToken
+	totp          map[int64]TOTPEnrollment
+	recoveryCodes map[int64]map[string]bool // hash to used
+	accessTokens  []*AccessToken
+	passkeys      []*Passkey
+	challenges    map[string]*WebAuthnChallenge
+	lastFailures  map[int64]time.Time
+	audit         []*AuditEvent
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		users:         map[string]*User{},
+		identities:    map[string]int64{},
+		totp:          map[int64]TOTPEnrollment{},
+		recoveryCodes: map[int64]map[string]bool{},
+		challenges:    map[string]*WebAuthnChallenge{},
+		lastFailures:  map[int64]time.Time{},
+	}
+}
+
+func (s *memoryStore) userByID(id int64) *User {
+	for _, u := range s.users {
+		if u.ID == id {
+			return u
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) GetUserByEmail(email string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.users[email]; ok {
+		return u, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memoryStore) GetUserByID(id string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, _ := strconv.ParseInt(id, 10, 64)
+	if u := s.userByID(n); u != nil {
+		return u, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memoryStore) CreateUser(u *User) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[u.Email]; ok {
+		return nil, errors.New("duplicate email")
+	}
+	if u.Status == "" {
+		u.Status = UserActive
+	}
+	if u.Role == "" {
+		u.Role = RoleUser
+	}
+	u.ID = int64(len(s.users) + 1)
+	s.users[u.Email] = u
+	return u, nil
+}
+
+func (s *memoryStore) SetUserStatus(userID int64, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u := s.userByID(userID); u != nil {
+		u.Status = status
+	}
+	return nil
+}
+
+func (s *memoryStore) ResetPassword(userID int64, hash string, revokedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.userByID(userID)
+	if u == nil {
+		return sql.ErrNoRows
+	}
+	u.Password, u.Status, u.SessionsRevokedAt = hash, UserActive, &revokedAt
+	for _, t := range s.tokens {
+		if t.UserID == userID && t.UsedAt == nil {
+			t.UsedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) GetUserByIdentity(issuer, subject string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u := s.userByID(s.identities[issuer+"|"+subject]); u != nil {
+		return u, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memoryStore) CreateIdentity(userID int64, issuer, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.identities[issuer+"|"+subject] = userID
+	return nil
+}
+
+func (s *memoryStore) CreateUserToken(t *UserToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t.ID = int64(len(s.tokens) + 1)
+	s.tokens = append(s.tokens, t)
+	return nil
+}
+
+func (s *memoryStore) ConsumeUserToken(purpose, hash string) (*UserToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.tokens {
+		if t.Hash == hash && t.Purpose == purpose && t.UsedAt == nil && time.Now().Before(t.ExpiresAt) {
+			now := time.Now()
+			t.UsedAt = &now
+			return t, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memoryStore) AttemptUserToken(purpose, hash string) (*UserToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.tokens {
+		if t.Hash == hash && t.Purpose == purpose && t.UsedAt == nil && time.Now().Before(t.ExpiresAt) {
+			t.Attempts++
+			return t, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memoryStore) GetTOTP(userID int64) (*TOTPEnrollment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.totp[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &e, nil
+}
+
+func (s *memoryStore) SaveTOTP(e *TOTPEnrollment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.totp[e.UserID] = *e
+	return nil
+}
+
+func (s *memoryStore) DeleteTOTP(userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.totp, userID)
+	delete(s.recoveryCodes, userID)
+	return nil
+}
+
+func (s *memoryStore) UseTOTPCounter(userID, counter int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.totp[userID]
+	if !ok || counter <= e.LastCounter {
+		return sql.ErrNoRows
+	}
+	e.LastCounter = counter
+	s.totp[userID] = e
+	return nil
+}
+
+func (s *memoryStore) ReplaceRecoveryCodes(userID int64, hashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.recoveryCodes[userID] = map[string]bool{}
+	for _, h := range hashes {
+		s.recoveryCodes[userID][h] = false
+	}
+	return nil
+}
+
+func (s *memoryStore) UseRecoveryCode(userID int64, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	used, ok := s.recoveryCodes[userID][hash]
+	if !ok || used {
+		return sql.ErrNoRows
+	}
+	s.recoveryCodes[userID][hash] = true
+	return nil
+}
+
+func (s *memoryStore) CreateAccessToken(t *AccessToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t.ID = 1
+	if n := len(s.accessTokens); n > 0 {
+		t.ID = s.accessTokens[n-1].ID + 1
+	}
+	t.CreatedAt = time.Now().Truncate(time.Second)
+	s.accessTokens = append(s.accessTokens, t)
+	return nil
+}
+
+func (s *memoryStore) GetAccessToken(hash string) (*AccessToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.accessTokens {
+		if t.Hash == hash {
+			copied := *t
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memoryStore) ListAccessTokens(userID int64) ([]*AccessToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tokens []*AccessToken
+	for _, t := range s.accessTokens {
+		if t.UserID == userID {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens, nil
+}
+
+func (s *memoryStore) DeleteAccessToken(userID, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, t := range s.accessTokens {
+		if t.ID == id && t.UserID == userID {
+			s.accessTokens = append(s.accessTokens[:i], s.accessTokens[i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (s *memoryStore) TouchAccessToken(id int64, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.accessTokens {
+		if t.ID == id {
+			t.LastUsedAt = &usedAt
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) RecordFailedLogin(userID int64, at, since time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.userByID(userID)
+	if u == nil {
+		return 0, sql.ErrNoRows
+	}
+	if last, ok := s.lastFailures[userID]; !ok || last.Before(since) {
+		u.FailedLogins = 0
+	}
+	u.FailedLogins++
+	s.lastFailures[userID] = at
+	return u.FailedLogins, nil
+}
+
+func (s *memoryStore) LockUser(userID int64, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u := s.userByID(userID); u != nil {
+		u.LockedUntil = &until
+	}
+	return nil
+}
+
+func (s *memoryStore) UnlockUser(userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.userByID(userID)
+	if u == nil {
+		return sql.ErrNoRows
+	}
+	u.FailedLogins, u.LockedUntil = 0, nil
+	delete(s.lastFailures, userID)
+	return nil
+}
+
+func (s *memoryStore) RecordAudit(e *AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e.ID = int64(len(s.audit) + 1)
+	e.CreatedAt = time.Now()
+	s.audit = append(s.audit, e)
+	return nil
+}
+
+func (s *memoryStore) ListAuditEvents(opts ListOptions) ([]*AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []*AuditEvent
+	for _, e := range s.audit {
+		if e.ID > opts.AfterID && (opts.Limit <= 0 || len(events) < opts.Limit) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (s *memoryStore) CreatePasskey(p *Passkey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n := len(s.passkeys); n > 0 {
+		p.ID = s.passkeys[n-1].ID + 1
+	} else {
+		p.ID = 1
+	}
+	p.CreatedAt = time.Now().Truncate(time.Second)
+	s.passkeys = append(s.passkeys, p)
+	return nil
+}
+
+func (s *memoryStore) GetPasskey(credentialID []byte) (*Passkey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range s.passkeys {
+		if bytes.Equal(p.CredentialID, credentialID) {
+			found := *p
+			return &found, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memoryStore) ListPasskeys(userID int64) ([]*Passkey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var passkeys []*Passkey
+	for _, p := range s.passkeys {
+		if p.UserID == userID {
+			passkeys = append(passkeys, p)
+		}
+	}
+	return passkeys, nil
+}
+
+func (s *memoryStore) DeletePasskey(userID, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, p := range s.passkeys {
+		if p.ID == id && p.UserID == userID {
+			s.passkeys = append(s.passkeys[:i], s.passkeys[i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (s *memoryStore) UsePasskey(id int64, signCount uint32, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range s.passkeys {
+		if p.ID == id {
+			p.SignCount, p.LastUsedAt = signCount, &usedAt
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) SaveWebAuthnChallenge(c *WebAuthnChallenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.challenges[c.Hash] = c
+	return nil
+}
+
+func (s *memoryStore) ConsumeWebAuthnChallenge(ceremony, hash string) (*WebAuthnChallenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.challenges[hash]
+	if !ok || c.Ceremony != ceremony || !time.Now().Before(c.ExpiresAt) {
+		return nil, sql.ErrNoRows
+	}
+	delete(s.challenges, hash)
+	return c, nil
+}
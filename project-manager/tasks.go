@@ -10,18 +10,30 @@ import (
 var errNameRequired = errors.New("task name is required")
 var errProjectIDRequired = errors.New("project ID is required")
 var errUSerIDRequired = errors.New("user ID is required")
+var errInvalidStatus = errors.New("status must be one of TODO, IN_PROGRESS, IN_TESTING, DONE")
+
+// TaskStatuses mirrors the tasks.status ENUM, in board order.
+var TaskStatuses = []string{"TODO", "IN_PROGRESS", "IN_TESTING", "DONE"}
 
 type TasksService struct {
-	store Store
+	store  Store
+	events *EventBroker
+}
+
+type updateTaskPayload struct {
+	Name         *string `json:"name"`
+	Status       *string `json:"status"`
+	AssignedToID *int64  `json:"assignedTo"`
 }
 
-func NewTasksService(s Store) *TasksService {
-	return &TasksService{store: s}
+func NewTasksService(s Store, events *EventBroker) *TasksService {
+	return &TasksService{store: s, events: events}
 }
 
 func (s *TasksService) RegisterRoutes(r *http.ServeMux) {
 	r.HandleFunc("POST /tasks", s.handleCreateTask)
 	r.HandleFunc("GET /tasks/{id}", s.handleGetTask)
+	r.HandleFunc("PATCH /tasks/{id}", s.handleUpdateTask)
 }
 
 func (s *TasksService) handleCreateTask(w http.ResponseWriter, r *http.Request) {
@@ -51,7 +63,7 @@ func (s *TasksService) handleCreateTask(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	t, err := s.store.CreateTask(task)
+	t, err := s.createTask(task)
 	if err != nil {
 		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
 			Error: "Error creating task: " + err.Error(),
@@ -82,6 +94,102 @@ func (s *TasksService) handleGetTask(w http.ResponseWriter, r *http.Request) {
 	WriteJson(w, http.StatusOK, t)
 }
 
+func (s *TasksService) handleUpdateTask(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error reading request body: " + err.Error(),
+		})
+		return
+	}
+
+	defer r.Body.Close()
+
+	var payload updateTaskPayload
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid JSON payload: " + err.Error(),
+		})
+		return
+	}
+
+	t, err := s.store.GetTask(r.PathValue("id"))
+	if err != nil {
+		WriteJson(w, http.StatusNotFound, ErrorResponse{
+			Error: "Error getting task: " + err.Error(),
+		})
+		return
+	}
+
+	previousStatus := t.Status
+	if payload.Name != nil {
+		t.Name = *payload.Name
+	}
+	if payload.Status != nil {
+		t.Status = *payload.Status
+	}
+	if payload.AssignedToID != nil {
+		t.AssignedToID = *payload.AssignedToID
+	}
+
+	if err := t.validate(); err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid task payload: " + err.Error(),
+		})
+		return
+	}
+
+	t, err = s.updateTask(t, previousStatus)
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error updating task: " + err.Error(),
+		})
+		return
+	}
+
+	WriteJson(w, http.StatusOK, t)
+}
+
+// createTask stores an already validated task and announces it.
+func (s *TasksService) createTask(t *Task) (*Task, error) {
+	t, err := s.store.CreateTask(t)
+	if err != nil {
+		return nil, err
+	}
+
+	s.events.Publish(t.ProjectID, EventTaskCreated, t)
+	return t, nil
+}
+
+// updateTask stores an already validated task and announces the change,
+// including a status change when it moved away from previousStatus.
+func (s *TasksService) updateTask(t *Task, previousStatus string) (*Task, error) {
+	t, err := s.store.UpdateTask(t)
+	if err != nil {
+		return nil, err
+	}
+
+	s.events.Publish(t.ProjectID, EventTaskUpdated, t)
+	if t.Status != previousStatus {
+		s.events.Publish(t.ProjectID, EventTaskStatusChanged, TaskStatusChange{
+			Task: t,
+			From: previousStatus,
+			To:   t.Status,
+		})
+	}
+	return t, nil
+}
+
+func isValidTaskStatus(status string) bool {
+	for _, s := range TaskStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
 func (t *Task) validate() error {
 	if t.Name == "" {
 		return errNameRequired
@@ -95,5 +203,9 @@ func (t *Task) validate() error {
 		return errUSerIDRequired
 	}
 
+	if t.Status != "" && !isValidTaskStatus(t.Status) {
+		return errInvalidStatus
+	}
+
 	return nil
 }
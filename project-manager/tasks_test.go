@@ -27,7 +27,7 @@ func TestCreateTask(t *testing.T) {
 		router := http.NewServeMux()
 
 		ms := &MockStore{}
-		service := NewTasksService(ms)
+		service := NewTasksService(ms, nil)
 		service.RegisterRoutes(router)
 
 		router.HandleFunc("/tasks", service.handleCreateTask)
@@ -58,7 +58,7 @@ func TestCreateTask(t *testing.T) {
 		router := http.NewServeMux()
 
 		ms := &MockStore{}
-		service := NewTasksService(ms)
+		service := NewTasksService(ms, nil)
 		service.RegisterRoutes(router)
 
 		router.HandleFunc("/tasks", service.handleCreateTask)
@@ -72,7 +72,7 @@ func TestCreateTask(t *testing.T) {
 
 func TestGetTask(t *testing.T) {
 	ms := &MockStore{}
-	service := NewTasksService(ms)
+	service := NewTasksService(ms, nil)
 
 	t.Run("Return task", func(t *testing.T) {
 		req, err := http.NewRequest(http.MethodGet, "/tasks/42", nil)
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ZiadMansourM/middleware/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const serviceName = "project-manager"
+
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// NewTracerProvider exports spans to the OTLP/HTTP receiver at
+// c.OTLPEndpoint, e.g. the Tempo container of monitoring/compose.yaml.
+// Without an endpoint spans are still created, so trace IDs reach the
+// response headers, but go nowhere. Shut it down to flush the last batch.
+func NewTracerProvider(ctx context.Context, c Config) (*sdktrace.TracerProvider, error) {
+	if c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		return nil, errors.New("TRACE_SAMPLE_RATIO must be between 0 and 1")
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		// Callers that already decided whether to sample keep their decision.
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.TraceSampleRatio))),
+	}
+	if c.OTLPEndpoint != "" {
+		exporterOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(c.OTLPEndpoint)}
+		if c.OTLPInsecure {
+			exporterOpts = append(exporterOpts, otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(ctx, exporterOpts...)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter,
+			sdktrace.WithBatchTimeout(c.TraceBatchTimeout),
+			sdktrace.WithMaxExportBatchSize(c.TraceBatchSize),
+		))
+	}
+	return sdktrace.NewTracerProvider(opts...), nil
+}
+
+// TracingMiddleware starts a server span for every request, continuing the
+// caller's W3C trace. Spans are named by the route pattern that served the
+// request, "GET /api/v1/tasks/{id}" rather than the path, and record the
+// status and the authenticated user.
+func TracingMiddleware(tp trace.TracerProvider) Middleware {
+	tracer := tp.Tracer("github.com/ZiadMansourM/project-manager")
+
+	return func(next http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tracer.Start(ctx, r.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("http.request.method", r.Method),
+					attribute.String("url.path", r.URL.Path),
+					attribute.String("server.address", r.Host),
+					attribute.String("client.address", r.RemoteAddr),
+					attribute.String("user_agent.original", r.UserAgent()),
+				),
+			)
+			defer span.End()
+			// Let clients quote the trace ID when reporting a problem.
+			propagator.Inject(ctx, propagation.HeaderCarrier(w.Header()))
+
+			r = middleware.WithRoute(r.WithContext(ctx))
+			w, rec := middleware.WrapResponseWriter(w)
+			next.ServeHTTP(w, r)
+
+			if route := middleware.Route(r); route != "" {
+				if !strings.Contains(route, " ") {
+					route = r.Method + " " + route
+				}
+				span.SetName(route)
+				_, path, _ := strings.Cut(route, " ")
+				span.SetAttributes(attribute.String("http.route", path))
+			}
+			span.SetAttributes(attribute.Int("http.response.status_code", rec.Status()))
+			if rec.Status() >= 500 {
+				span.SetStatus(codes.Error, http.StatusText(rec.Status()))
+			}
+		}
+	}
+}
+
+// setSpanUser records the authenticated user on the request's span.
+func setSpanUser(ctx context.Context, userID string) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("enduser.id", userID))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	global := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() { otel.SetTracerProvider(global) })
+
+	store := newMemoryStore()
+	hashed, _ := HashPassword("a long enough passphrase")
+	user, _ := store.CreateUser(&User{Email: "ada@example.com", Password: hashed})
+	token, _ := CreateJWT(user.ID, []byte(Envs.JWTSecret))
+
+	api, _, err := NewAPIServer("", store).Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(req *http.Request) (*httptest.ResponseRecorder, tracetest.SpanStub) {
+		t.Helper()
+		exporter.Reset()
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		spans := exporter.GetSpans()
+		if len(spans) != 1 {
+			t.Fatalf("Expected one span, got %d", len(spans))
+		}
+		return rec, spans[0]
+	}
+	attributes := func(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+		m := make(map[attribute.Key]attribute.Value)
+		for _, kv := range span.Attributes {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+
+	t.Run("Names the span by route and records the user", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v2/tasks/404", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		rec, span := serve(req)
+
+		if span.Name != "GET /api/v2/tasks/{id}" || span.SpanKind != trace.SpanKindServer {
+			t.Errorf("Unexpected span %q of kind %v", span.Name, span.SpanKind)
+		}
+		if span.Parent.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || span.Parent.SpanID().String() != "00f067aa0ba902b7" {
+			t.Errorf("Expected the caller's span as parent, got %v", span.Parent)
+		}
+		attrs := attributes(span)
+		if attrs["http.route"].AsString() != "/api/v2/tasks/{id}" || attrs["http.response.status_code"].AsInt64() != int64(rec.Code) || attrs["enduser.id"].AsString() != strconv.FormatInt(user.ID, 10) {
+			t.Errorf("Unexpected attributes %v", span.Attributes)
+		}
+		if span.Status.Code == codes.Error {
+			t.Error("Expected a client error to leave the span status unset")
+		}
+		if rec.Header().Get("traceparent") != "00-4bf92f3577b34da6a3ce929d0e0e4736-"+span.SpanContext.SpanID().String()+"-01" {
+			t.Errorf("Expected the response to carry the span's traceparent, got %q", rec.Header().Get("traceparent"))
+		}
+	})
+
+	t.Run("Names routes outside the API", func(t *testing.T) {
+		_, span := serve(httptest.NewRequest(http.MethodGet, "/login", nil))
+		if _, ok := attributes(span)["enduser.id"]; span.Name != "GET /login" || ok {
+			t.Errorf("Unexpected span %q %v", span.Name, span.Attributes)
+		}
+	})
+
+	t.Run("Unauthenticated requests are traced too", func(t *testing.T) {
+		_, span := serve(httptest.NewRequest(http.MethodGet, "/api/v1/users/me", nil))
+		if attributes(span)["http.response.status_code"].AsInt64() != http.StatusUnauthorized || span.Parent.IsValid() {
+			t.Errorf("Unexpected span %q %v", span.Name, span.Attributes)
+		}
+	})
+}
@@ -6,6 +6,15 @@ type ErrorResponse struct {
 	Error string `json:"error"`
 }
 
+type Project struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// OwnerID is the user who created the project, 0 for projects created
+	// by older versions.
+	OwnerID   int64     `json:"ownerID,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
 type Task struct {
 	ID           int64     `json:"id"`
 	Name         string    `json:"name"`
@@ -21,5 +30,25 @@ type User struct {
 	FirstName string    `json:"firstName"`
 	LastName  string    `json:"lastName"`
 	Password  string    `json:"password"`
+	Status    string    `json:"status"`
+	Role      string    `json:"role"`
 	CreatedAt time.Time `json:"createdAt"`
+	// SessionsRevokedAt invalidates every token issued before it.
+	SessionsRevokedAt *time.Time `json:"-"`
+	// Failed sign-ins counted by LoginGuard, and the lockout they caused.
+	FailedLogins int        `json:"-"`
+	LockedUntil  *time.Time `json:"-"`
 }
+
+// User statuses. Self-registered users stay pending until they follow the
+// link in the verification email.
+const (
+	UserPending = "pending"
+	UserActive  = "active"
+)
+
+// User roles. Admins can unlock accounts and read the audit log.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
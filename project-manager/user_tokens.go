@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// Purposes of single-use tokens mailed to users. A token only redeems for
+// the purpose it was issued for.
+const (
+	TokenEmailVerification = "email_verification"
+	TokenPasswordReset     = "password_reset"
+	// TokenMFAChallenge is the jti of a second sign-in step, see
+	// newMFAChallenge. It's never mailed.
+	TokenMFAChallenge = "mfa_challenge"
+)
+
+var errInvalidUserToken = errors.New("token is invalid, expired or already used")
+
+// UserToken is a single-use token. Only the SHA-256 of the token is stored,
+// so a database leak doesn't hand out working links.
+type UserToken struct {
+	ID        int64
+	UserID    int64
+	Purpose   string
+	Hash      string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+
+	// Attempts counts tries at redeeming the token, see AttemptUserToken.
+	Attempts int
+}
+
+// newUserToken returns the raw token to mail and the record to store.
+func newUserToken(userID int64, purpose string, ttl time.Duration) (string, *UserToken, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	raw := base64.RawURLEncoding.EncodeToString(b)
+
+	return raw, &UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		Hash:      hashUserToken(raw),
+		ExpiresAt: time.Now().Add(ttl),
+	}, nil
+}
+
+func hashUserToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
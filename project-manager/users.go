@@ -1,30 +1,89 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type UserService struct {
-	store Store
+	store  Store
+	mailer Mailer
+	policy *PasswordPolicy
+	guard  *LoginGuard
 }
 
+// How long the links in the verification and password reset emails work.
+const (
+	verificationTTL  = 48 * time.Hour
+	passwordResetTTL = time.Hour
+)
+
 var errEmailRequired = errors.New("email is required")
 var errPasswordRequired = errors.New("password is required")
+var errInvalidCredentials = errors.New("invalid email or password")
+var errAccountPending = errors.New("verify your email address before signing in, check your inbox for the link")
+
+// RegisterPayload is what a client may set on a new account. The role and
+// status are the server's to decide.
+type RegisterPayload struct {
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+}
+
+type LoginPayload struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
 
-func NewUserService(store Store) *UserService {
+// TokenResponse carries the session token, or for accounts with two-factor
+// authentication the challenge to pass to /users/login/mfa with a code.
+type TokenResponse struct {
+	Token       string `json:"token,omitempty"`
+	MFARequired bool   `json:"mfaRequired,omitempty"`
+	MFAToken    string `json:"mfaToken,omitempty"`
+}
+
+func NewUserService(store Store, mailer Mailer, policy *PasswordPolicy) *UserService {
 	return &UserService{
-		store: store,
+		store:  store,
+		mailer: mailer,
+		policy: policy,
+		guard:  NewLoginGuard(store, Envs),
 	}
 }
 
 func (s *UserService) RegisterRoutes(router *http.ServeMux) {
 	router.HandleFunc("POST /users/register", s.handleUserRegistration)
+	router.HandleFunc("POST /users/login", s.handleUserLogin)
+	router.HandleFunc("POST /users/verify", s.handleVerifyEmail)
+	router.HandleFunc("POST /users/password/forgot", s.handleForgotPassword)
+	router.HandleFunc("POST /users/password/reset", s.handleResetPassword)
+	router.HandleFunc("GET /users/me", s.handleGetCurrentUser)
+	s.registerMFARoutes(router)
+	s.registerPasskeyRoutes(router)
 }
 
 func (s *UserService) handleUserRegistration(w http.ResponseWriter, r *http.Request) {
+	// Rejected registrations count as failures of the IP, so registration
+	// can't be used to probe for accounts or burn bcrypt time either.
+	ip := clientIP(r)
+	if err := s.guard.checkIP(ip); err != nil {
+		writeLocked(w, err.(*lockedError))
+		return
+	}
+
 	// get payload: email and password
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -36,14 +95,22 @@ func (s *UserService) handleUserRegistration(w http.ResponseWriter, r *http.Requ
 
 	defer r.Body.Close()
 
-	var payload *User
-	err = json.Unmarshal(body, &payload)
+	var input RegisterPayload
+	err = json.Unmarshal(body, &input)
 	if err != nil {
 		WriteJson(w, http.StatusBadRequest, ErrorResponse{
 			Error: "Invalid Request Payload: " + err.Error(),
 		})
 		return
 	}
+	payload := &User{
+		Email:     input.Email,
+		Password:  input.Password,
+		FirstName: input.FirstName,
+		LastName:  input.LastName,
+		Status:    UserPending,
+		Role:      RoleUser,
+	}
 
 	// validate payload
 	if err := payload.validate(); err != nil {
@@ -53,6 +120,14 @@ func (s *UserService) handleUserRegistration(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if err := s.policy.Check(payload.Email, payload.Password); err != nil {
+		s.guard.fail(r.Context(), ip, nil)
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid Request Payload: " + err.Error(),
+		})
+		return
+	}
+
 	hashedPassword, err := HashPassword(payload.Password)
 	if err != nil {
 		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
@@ -66,23 +141,343 @@ func (s *UserService) handleUserRegistration(w http.ResponseWriter, r *http.Requ
 	// create user
 	user, err := s.store.CreateUser(payload)
 	if err != nil {
+		s.guard.fail(r.Context(), ip, nil)
 		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
 			Error: "Error creating user: " + err.Error(),
 		})
 		return
 	}
 
-	// Create a token
-	_, err = createAndSetAuthCookie(w, user.ID)
+	// No session until the email is verified
+	if err := s.sendVerificationEmail(r.Context(), user); err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error sending verification email: " + err.Error(),
+		})
+		return
+	}
+
+	// return user, without the password hash
+	created := *user
+	created.Password = ""
+	WriteJson(w, http.StatusCreated, created)
+}
+
+func (s *UserService) sendVerificationEmail(ctx context.Context, user *User) error {
+	raw, token, err := newUserToken(user.ID, TokenEmailVerification, verificationTTL)
+	if err != nil {
+		return err
+	}
+	if err := s.store.CreateUserToken(token); err != nil {
+		return err
+	}
+
+	link := strings.TrimSuffix(Envs.PublicURL, "/") + "/verify-email?token=" + url.QueryEscape(raw)
+	return s.mailer.Send(ctx, Message{
+		To:      user.Email,
+		Subject: "Verify your Project Manager account",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address to activate your account:\n\n%s\n\nThe link expires in %d hours. If you didn't sign up, ignore this email.\n",
+			user.FirstName, link, int(verificationTTL.Hours())),
+	})
+}
+
+type verifyEmailPayload struct {
+	Token string `json:"token"`
+}
+
+func (s *UserService) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var payload verifyEmailPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid Request Payload: " + err.Error(),
+		})
+		return
+	}
+
+	if err := s.verifyEmail(payload.Token); err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verifyEmail redeems a verification token and activates its account.
+func (s *UserService) verifyEmail(raw string) error {
+	if raw == "" {
+		return errInvalidUserToken
+	}
+
+	token, err := s.store.ConsumeUserToken(TokenEmailVerification, hashUserToken(raw))
+	if errors.Is(err, sql.ErrNoRows) {
+		return errInvalidUserToken
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.store.SetUserStatus(token.UserID, UserActive)
+}
+
+type forgotPasswordPayload struct {
+	Email string `json:"email"`
+}
+
+type resetPasswordPayload struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// forgotPasswordResponse is the same whether or not the account exists.
+var forgotPasswordResponse = map[string]string{
+	"message": "If an account exists for that email, a password reset link is on its way.",
+}
+
+func (s *UserService) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var payload forgotPasswordPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid Request Payload: " + err.Error(),
+		})
+		return
+	}
+	if payload.Email == "" {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid Request Payload: " + errEmailRequired.Error(),
+		})
+		return
+	}
+
+	s.forgotPassword(r.Context(), payload.Email)
+	WriteJson(w, http.StatusAccepted, forgotPasswordResponse)
+}
+
+// forgotPassword mails a reset link in the background, so the response
+// takes as long for unknown emails as for real accounts.
+func (s *UserService) forgotPassword(ctx context.Context, email string) {
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		if err := s.sendPasswordResetEmail(ctx, email); err != nil {
+			log.Printf("Password reset for %s: %v\n", email, err)
+		}
+	}()
+}
+
+func (s *UserService) sendPasswordResetEmail(ctx context.Context, email string) error {
+	user, err := s.store.GetUserByEmail(email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	raw, token, err := newUserToken(user.ID, TokenPasswordReset, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+	if err := s.store.CreateUserToken(token); err != nil {
+		return err
+	}
+
+	link := strings.TrimSuffix(Envs.PublicURL, "/") + "/reset-password?token=" + url.QueryEscape(raw)
+	return s.mailer.Send(ctx, Message{
+		To:      user.Email,
+		Subject: "Reset your Project Manager password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. Choose a new one here:\n\n%s\n\nThe link expires in %d minutes and signs you out everywhere. If it wasn't you, ignore this email.\n",
+			user.FirstName, link, int(passwordResetTTL.Minutes())),
+	})
+}
+
+func (s *UserService) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var payload resetPasswordPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid Request Payload: " + err.Error(),
+		})
+		return
+	}
+
+	if err := s.resetPassword(payload.Token, payload.Password); err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resetPassword redeems a reset token. The new password is checked against
+// the policy before the token is used up, so a typo doesn't burn the link.
+func (s *UserService) resetPassword(raw, password string) error {
+	if raw == "" {
+		return errInvalidUserToken
+	}
+	if password == "" {
+		return errPasswordRequired
+	}
+	// The email isn't known until the token is redeemed, so the email rule
+	// is checked afterwards.
+	if err := s.policy.Check("", password); err != nil {
+		return err
+	}
+
+	hashed, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	token, err := s.store.ConsumeUserToken(TokenPasswordReset, hashUserToken(raw))
+	if errors.Is(err, sql.ErrNoRows) {
+		return errInvalidUserToken
+	}
+	if err != nil {
+		return err
+	}
+
+	user, err := s.store.GetUserByID(strconv.FormatInt(token.UserID, 10))
+	if err != nil {
+		return err
+	}
+	if err := s.policy.Check(user.Email, password); err != nil {
+		return err
+	}
+
+	// Truncated because MySQL rounds TIMESTAMPs to the second, which could
+	// revoke a session created right after the reset.
+	return s.store.ResetPassword(user.ID, hashed, time.Now().Truncate(time.Second))
+}
+
+func (s *UserService) handleUserLogin(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Error reading Request Body: " + err.Error(),
+		})
+		return
+	}
+
+	defer r.Body.Close()
+
+	var payload LoginPayload
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		WriteJson(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid Request Payload: " + err.Error(),
+		})
+		return
+	}
+
+	user, err := s.authenticate(r.Context(), clientIP(r), payload.Email, payload.Password)
+	var locked *lockedError
+	if errors.As(err, &locked) {
+		writeLocked(w, locked)
+		return
+	}
+	if errors.Is(err, errAccountPending) {
+		WriteJson(w, http.StatusForbidden, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		WriteJson(w, http.StatusUnauthorized, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	// No session yet for accounts with a second factor, only a challenge.
+	challenge, err := s.mfaChallenge(user)
 	if err != nil {
 		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
 			Error: "Error creating token: " + err.Error(),
 		})
 		return
 	}
+	if challenge != "" {
+		WriteJson(w, http.StatusOK, TokenResponse{MFARequired: true, MFAToken: challenge})
+		return
+	}
+	s.guard.succeed(user)
+
+	token, err := createAndSetAuthCookie(w, user.ID)
+	if err != nil {
+		WriteJson(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "Error creating token: " + err.Error(),
+		})
+		return
+	}
+
+	WriteJson(w, http.StatusOK, TokenResponse{Token: token})
+}
+
+// mfaChallenge returns a challenge token when the user has to enter a code
+// to finish signing in, or "" when the password is enough.
+func (s *UserService) mfaChallenge(user *User) (string, error) {
+	enabled, err := s.mfaEnabled(user.ID)
+	if err != nil || !enabled {
+		return "", err
+	}
+	return s.newMFAChallenge(user.ID)
+}
+
+func (s *UserService) handleGetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	info := authInfoFromContext(r.Context())
+	if info == nil {
+		WriteJson(w, http.StatusUnauthorized, ErrorResponse{
+			Error: "Unauthorized",
+		})
+		return
+	}
+
+	user, err := s.store.GetUserByID(info.UserID)
+	if err != nil {
+		WriteJson(w, http.StatusNotFound, ErrorResponse{
+			Error: "Error getting user: " + err.Error(),
+		})
+		return
+	}
 
-	// return user
-	WriteJson(w, http.StatusCreated, user)
+	WriteJson(w, http.StatusOK, user)
+}
+
+// authenticate checks an email and password pair. The same error is returned
+// for unknown emails and wrong passwords, after as long a bcrypt comparison,
+// so accounts can't be enumerated.
+// Failures count towards a lockout, see LoginGuard, and locked out accounts
+// and IPs get a *lockedError without the password being checked.
+func (s *UserService) authenticate(ctx context.Context, ip, email, password string) (*User, error) {
+	if email == "" || password == "" {
+		return nil, errInvalidCredentials
+	}
+	if err := s.guard.checkIP(ip); err != nil {
+		return nil, err
+	}
+
+	user, err := s.store.GetUserByEmail(email)
+	if err != nil {
+		ComparePassword(dummyPasswordHash, password)
+		s.guard.fail(ctx, ip, nil)
+		return nil, errInvalidCredentials
+	}
+	if err := s.guard.checkUser(user); err != nil {
+		return nil, err
+	}
+
+	if !ComparePassword(user.Password, password) {
+		s.guard.fail(ctx, ip, user)
+		return nil, errInvalidCredentials
+	}
+
+	// Only revealed to someone who knows the password.
+	if user.Status == UserPending {
+		return nil, errAccountPending
+	}
+
+	return user, nil
 }
 
 func (u *User) validate() error {
@@ -98,17 +493,13 @@ func (u *User) validate() error {
 }
 
 func createAndSetAuthCookie(w http.ResponseWriter, userID int64) (string, error) {
-	secret := []byte(Envs.JWTSecret)
+	secret := []byte(currentJWTSecret())
 	token, err := CreateJWT(userID, secret)
 	if err != nil {
 		return "", err
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "Authorization",
-		Value:    token,
-		HttpOnly: true,
-	})
+	setSessionCookies(w, token)
 
 	return token, nil
 }
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// recordingMailer keeps sent messages for assertions.
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *recordingMailer) last(t *testing.T) Message {
+	t.Helper()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.sent) == 0 {
+		t.Fatal("Expected an email to be sent")
+	}
+	return m.sent[len(m.sent)-1]
+}
+
+var linkToken = regexp.MustCompile(`token=([A-Za-z0-9_-]+)`)
+
+// tokenFromMail extracts the token from the link in a mailed message.
+func tokenFromMail(t *testing.T, m Message) string {
+	t.Helper()
+
+	match := linkToken.FindStringSubmatch(m.Body)
+	if match == nil {
+		t.Fatalf("Expected a link with a token in %q", m.Body)
+	}
+	return match[1]
+}
+
+func postJSON(handler http.Handler, path string, body any) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(body)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(b)))
+	return rec
+}
+
+func TestRegistration(t *testing.T) {
+	dir := t.TempDir()
+	breached := filepath.Join(dir, "breached.txt")
+	// "hunter2hunter2" in plain text, "correct horse battery" as a HIBP line.
+	list := "# common\nhunter2hunter2\n98DECC62ECE399A22ED30D490EF333BE7FDE7385:12\n"
+	if err := os.WriteFile(breached, []byte(list), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadPasswordPolicy(10, breached)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newMemoryStore()
+	mailer := &recordingMailer{}
+	users := NewUserService(store, mailer, policy)
+	router := http.NewServeMux()
+	users.RegisterRoutes(router)
+
+	register := func(email, password string) *httptest.ResponseRecorder {
+		return postJSON(router, "/users/register", map[string]string{"email": email, "password": password, "firstName": "Ada"})
+	}
+
+	for name, tc := range map[string]struct{ email, password string }{
+		"too short":         {"ada@example.com", "short"},
+		"email as password": {"ada@example.com", "Ada@Example.com"},
+		"breached":          {"ada@example.com", "HUNTER2hunter2"},
+		"breached (hashed)": {"ada@example.com", "correct horse battery"},
+	} {
+		t.Run("Rejects a password that is "+name, func(t *testing.T) {
+			if rec := register(tc.email, tc.password); rec.Code != http.StatusBadRequest {
+				t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rec.Code)
+			}
+		})
+	}
+
+	t.Run("Creates a pending account and mails a link", func(t *testing.T) {
+		rec := register("ada@example.com", "a long enough passphrase")
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body)
+		}
+		if len(rec.Result().Cookies()) != 0 {
+			t.Error("Expected no session before the email is verified")
+		}
+
+		var u User
+		json.NewDecoder(rec.Body).Decode(&u)
+		if u.Status != UserPending || u.Password != "" {
+			t.Errorf("Expected a pending user without the password hash, got %+v", u)
+		}
+		if m := mailer.last(t); m.To != "ada@example.com" || !strings.Contains(m.Body, "/verify-email?token=") {
+			t.Errorf("Unexpected email: %+v", m)
+		}
+	})
+
+	t.Run("Pending accounts cannot sign in", func(t *testing.T) {
+		rec := postJSON(router, "/users/login", LoginPayload{Email: "ada@example.com", Password: "a long enough passphrase"})
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("Expected status code %d, got %d", http.StatusForbidden, rec.Code)
+		}
+	})
+
+	t.Run("Following the link activates the account once", func(t *testing.T) {
+		token := tokenFromMail(t, mailer.last(t))
+
+		if rec := postJSON(router, "/users/verify", verifyEmailPayload{Token: token}); rec.Code != http.StatusNoContent {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusNoContent, rec.Code, rec.Body)
+		}
+		if rec := postJSON(router, "/users/verify", verifyEmailPayload{Token: token}); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected a used token to be rejected, got %d", rec.Code)
+		}
+
+		rec := postJSON(router, "/users/login", LoginPayload{Email: "ada@example.com", Password: "a long enough passphrase"})
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected status code %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("The web UI handles the link from the email", func(t *testing.T) {
+		register("grace@example.com", "another long passphrase")
+		token := tokenFromMail(t, mailer.last(t))
+
+		web := NewWebService(store, users, NewTasksService(store, nil))
+		webRouter := http.NewServeMux()
+		web.RegisterRoutes(webRouter)
+
+		rec := httptest.NewRecorder()
+		webRouter.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/verify-email?token="+url.QueryEscape(token), nil))
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "verified") {
+			t.Errorf("Expected a confirmation page, got %d", rec.Code)
+		}
+		if store.users["grace@example.com"].Status != UserActive {
+			t.Error("Expected the account to be active")
+		}
+	})
+
+	t.Run("Ignores a role or status from the client", func(t *testing.T) {
+		rec := postJSON(router, "/users/register", map[string]string{"email": "mallory@example.com", "password": "a long enough passphrase", "role": RoleAdmin, "status": UserActive})
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body)
+		}
+		if u := store.users["mallory@example.com"]; u.Role != RoleUser || u.Status != UserPending {
+			t.Errorf("Expected a pending user account, got role %q status %q", u.Role, u.Status)
+		}
+	})
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := &PasswordPolicy{MinLength: 8, breached: map[string]struct{}{}}
+
+	if err := policy.Check("a@example.com", strings.Repeat("é", 8)); err != nil {
+		t.Errorf("Expected length to count characters, not bytes: %v", err)
+	}
+	if err := policy.Check("a@example.com", strings.Repeat("x", maxPasswordBytes+1)); err == nil {
+		t.Error("Expected passwords bcrypt would truncate to be rejected")
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer, err := NewMailer(Config{Mailer: "file", MailDir: dir, MailFrom: "pm@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mailer.Send(context.Background(), Message{To: "ada@example.com", Subject: "Hi", Body: "line 1\nline 2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("Expected one .eml file, got %d", len(files))
+	}
+	b, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(b), "To: ada@example.com\r\n") || !strings.HasSuffix(string(b), "line 1\r\nline 2") {
+		t.Errorf("Unexpected message:\n%s", b)
+	}
+}
+
+// wait blocks until n messages have been sent.
+func (m *recordingMailer) wait(t *testing.T, n int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		m.mu.Lock()
+		sent := len(m.sent)
+		m.mu.Unlock()
+		if sent >= n {
+			return
+		}
+	}
+	t.Fatalf("Expected %d emails to be sent", n)
+}
+
+func TestPasswordReset(t *testing.T) {
+	store := newMemoryStore()
+	hashed, _ := HashPassword("the old passphrase")
+	ada, _ := store.CreateUser(&User{Email: "ada@example.com", Password: hashed})
+
+	mailer := &recordingMailer{}
+	policy := &PasswordPolicy{MinLength: 10}
+	router := http.NewServeMux()
+	NewUserService(store, mailer, policy).RegisterRoutes(router)
+
+	api, _, err := NewAPIServer("", store).Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A session from before the reset.
+	oldSession, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"userID": strconv.FormatInt(ada.ID, 10),
+		"iat":    time.Now().Add(-time.Hour).Unix(),
+	}).SignedString([]byte(Envs.JWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	me := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := me(oldSession); code != http.StatusOK {
+		t.Fatalf("Expected the session to work before the reset, got %d", code)
+	}
+
+	t.Run("Responses don't reveal whether the account exists", func(t *testing.T) {
+		unknown := postJSON(router, "/users/password/forgot", forgotPasswordPayload{Email: "nobody@example.com"})
+		known := postJSON(router, "/users/password/forgot", forgotPasswordPayload{Email: "ada@example.com"})
+
+		if unknown.Code != http.StatusAccepted || known.Code != http.StatusAccepted {
+			t.Errorf("Expected %d for both, got %d and %d", http.StatusAccepted, unknown.Code, known.Code)
+		}
+		if unknown.Body.String() != known.Body.String() {
+			t.Errorf("Expected identical bodies, got %q and %q", unknown.Body, known.Body)
+		}
+
+		mailer.wait(t, 1)
+		if m := mailer.last(t); m.To != "ada@example.com" || !strings.Contains(m.Body, "/reset-password?token=") {
+			t.Errorf("Unexpected email: %+v", m)
+		}
+	})
+
+	token := tokenFromMail(t, mailer.last(t))
+
+	t.Run("A weak password doesn't use up the token", func(t *testing.T) {
+		rec := postJSON(router, "/users/password/reset", resetPasswordPayload{Token: token, Password: "short"})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rec.Code)
+		}
+	})
+
+	t.Run("Resets the password and revokes sessions", func(t *testing.T) {
+		rec := postJSON(router, "/users/password/reset", resetPasswordPayload{Token: token, Password: "the new passphrase"})
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusNoContent, rec.Code, rec.Body)
+		}
+
+		if code := me(oldSession); code != http.StatusUnauthorized {
+			t.Errorf("Expected the old session to be revoked, got %d", code)
+		}
+
+		rec = postJSON(router, "/users/login", LoginPayload{Email: "ada@example.com", Password: "the new passphrase"})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected to sign in with the new password, got %d", rec.Code)
+		}
+		var res TokenResponse
+		json.NewDecoder(rec.Body).Decode(&res)
+		if code := me(res.Token); code != http.StatusOK {
+			t.Errorf("Expected the new session to work, got %d", code)
+		}
+	})
+
+	t.Run("Tokens are single use", func(t *testing.T) {
+		rec := postJSON(router, "/users/password/reset", resetPasswordPayload{Token: token, Password: "yet another passphrase"})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rec.Code)
+		}
+	})
+
+	t.Run("Expired tokens are rejected", func(t *testing.T) {
+		raw, expired, _ := newUserToken(ada.ID, TokenPasswordReset, -time.Minute)
+		store.CreateUserToken(expired)
+
+		rec := postJSON(router, "/users/password/reset", resetPasswordPayload{Token: raw, Password: "yet another passphrase"})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rec.Code)
+		}
+	})
+}
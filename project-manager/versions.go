@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ZiadMansourM/middleware/middleware"
+)
+
+// Per-version usage, exposed on /debug/vars. Deprecated calls are keyed by
+// route pattern so we know which clients still need migrating.
+var (
+	apiVersionRequests    = expvar.NewMap("api_version_requests")
+	apiDeprecatedRequests = expvar.NewMap("api_deprecated_requests")
+)
+
+// Deprecation is announced on deprecated routes with the Deprecation
+// (RFC 9745), Sunset (RFC 8594) and Link headers.
+type Deprecation struct {
+	At     time.Time
+	Sunset time.Time
+	Link   string
+}
+
+// NewDeprecation parses YYYY-MM-DD dates. It returns nil, meaning not
+// deprecated, when at is empty.
+func NewDeprecation(at, sunset, link string) (*Deprecation, error) {
+	if at == "" {
+		return nil, nil
+	}
+
+	d := &Deprecation{Link: link}
+
+	var err error
+	if d.At, err = time.Parse(time.DateOnly, at); err != nil {
+		return nil, fmt.Errorf("deprecation date: %w", err)
+	}
+	if sunset != "" {
+		if d.Sunset, err = time.Parse(time.DateOnly, sunset); err != nil {
+			return nil, fmt.Errorf("sunset date: %w", err)
+		}
+		if d.Sunset.Before(d.At) {
+			return nil, errors.New("sunset date is before the deprecation date")
+		}
+	}
+	return d, nil
+}
+
+// mountAPIVersion serves router under /api/<name>/, counting requests per
+// version and applying the version's middlewares (e.g. VersionDeprecated).
+func mountAPIVersion(root *http.ServeMux, name string, router *http.ServeMux, middlewares ...Middleware) {
+	prefix := "/api/" + name
+
+	handler := http.StripPrefix(prefix, MiddlewareChain(middlewares...)(middleware.Routes(prefix, router)))
+
+	root.Handle(prefix+"/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiVersionRequests.Add(name, 1)
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+// VersionDeprecated marks the routes of a version as deprecated when the
+// successor version serves the same route. Routes without a successor, such
+// as login or the event stream, are left alone. A nil d is a no-op.
+func VersionDeprecated(d *Deprecation, successorPrefix string, successor *http.ServeMux) Middleware {
+	return func(next http.Handler) http.HandlerFunc {
+		if d == nil {
+			return next.ServeHTTP
+		}
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			// StripPrefix has already run, the path is relative to the version.
+			probe := r.Clone(r.Context())
+			if _, pattern := successor.Handler(probe); pattern != "" {
+				setDeprecationHeaders(w.Header(), d, successorPrefix+r.URL.Path)
+				apiDeprecatedRequests.Add(pattern, 1)
+			}
+
+			next.ServeHTTP(w, r)
+		}
+	}
+}
+
+func setDeprecationHeaders(h http.Header, d *Deprecation, successorPath string) {
+	h.Set("Deprecation", fmt.Sprintf("@%d", d.At.Unix()))
+	if !d.Sunset.IsZero() {
+		h.Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+	}
+
+	links := []string{fmt.Sprintf("<%s>; rel=\"successor-version\"", successorPath)}
+	if d.Link != "" {
+		links = append(links, fmt.Sprintf("<%s>; rel=\"deprecation\"; type=\"text/html\"", d.Link))
+	}
+	h.Add("Link", strings.Join(links, ", "))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ZiadMansourM/middleware/utils"
+)
+
+func newTestAPI(t *testing.T) http.Handler {
+	t.Helper()
+
+	handler, _, err := NewAPIServer("", &MockStore{}).Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func authedRequest(t *testing.T, method, path string) *http.Request {
+	t.Helper()
+
+	token, err := CreateJWT(42, []byte(Envs.JWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestAPIVersions(t *testing.T) {
+	deprecatedAt, sunset := Envs.APIV1DeprecatedAt, Envs.APIV1Sunset
+	t.Cleanup(func() { Envs.APIV1DeprecatedAt, Envs.APIV1Sunset = deprecatedAt, sunset })
+	Envs.APIV1DeprecatedAt, Envs.APIV1Sunset = "2026-11-01", "2027-05-01"
+	handler := newTestAPI(t)
+
+	t.Run("v1 routes with a v2 successor are deprecated", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, authedRequest(t, http.MethodGet, "/api/v1/tasks/42"))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+		}
+		if !strings.HasPrefix(rec.Header().Get("Deprecation"), "@") {
+			t.Errorf("Expected a Deprecation header, got %q", rec.Header().Get("Deprecation"))
+		}
+		if rec.Header().Get("Sunset") == "" {
+			t.Error("Expected a Sunset header")
+		}
+		if link := rec.Header().Get("Link"); !strings.Contains(link, `</api/v2/tasks/42>; rel="successor-version"`) {
+			t.Errorf("Expected a successor-version link, got %q", link)
+		}
+		if apiDeprecatedRequests.Get("GET /tasks/{id}") == nil {
+			t.Error("Expected deprecated usage to be counted by route")
+		}
+	})
+
+	t.Run("v1 routes without a successor are not deprecated", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/users/login", strings.NewReader("{}")))
+
+		if rec.Header().Get("Deprecation") != "" {
+			t.Errorf("Expected no Deprecation header, got %q", rec.Header().Get("Deprecation"))
+		}
+	})
+
+	t.Run("v2 uses its own DTOs", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, authedRequest(t, http.MethodGet, "/api/v2/projects"))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+		}
+		if rec.Header().Get("Deprecation") != "" {
+			t.Error("Expected v2 not to be deprecated")
+		}
+
+		var body listV2[map[string]any]
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if len(body.Data) != 1 || body.Data[0]["created_at"] == nil {
+			t.Errorf("Expected snake_case projects in a data envelope, got %+v", body)
+		}
+	})
+
+	t.Run("v2 requires authentication", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v2/projects", nil))
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+	})
+
+	t.Run("v2 errors are problem details", func(t *testing.T) {
+		req := authedRequest(t, http.MethodPost, "/api/v2/tasks")
+		req.Body = http.NoBody
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Errorf("Expected application/problem+json, got %q", ct)
+		}
+		var p utils.Problem
+		if err := json.NewDecoder(rec.Body).Decode(&p); err != nil || p.Status != http.StatusBadRequest || p.Type != "about:blank" {
+			t.Errorf("Expected the middleware's problem shape, got %+v", p)
+		}
+	})
+
+	if apiVersionRequests.Get("v1") == nil || apiVersionRequests.Get("v2") == nil {
+		t.Error("Expected usage to be counted per version")
+	}
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+)
+
+//go:embed templates static
+var webFS embed.FS
+
+var statusTitles = map[string]string{
+	"TODO":        "To do",
+	"IN_PROGRESS": "In progress",
+	"IN_TESTING":  "In testing",
+	"DONE":        "Done",
+}
+
+// WebService serves the server-rendered kanban UI. It authenticates with the
+// same JWT as the API, read from the cookie set on login.
+type WebService struct {
+	store     Store
+	users     *UserService
+	tasks     *TasksService
+	templates map[string]*template.Template
+	// sso shows "Sign in with SSO" on the login page, see OIDCService.
+	sso bool
+}
+
+type pageData struct {
+	UserID    string
+	CSRFToken string
+}
+
+type loginPage struct {
+	pageData
+	Email  string
+	Error  string
+	Notice string
+	SSO    bool
+}
+
+type mfaPage struct {
+	pageData
+	MFAToken string
+	Error    string
+}
+
+type passwordPage struct {
+	pageData
+	Token  string
+	Error  string
+	Notice string
+}
+
+type projectsPage struct {
+	pageData
+	Projects []*Project
+}
+
+type boardColumn struct {
+	Status string
+	Title  string
+	Tasks  []*Task
+}
+
+type boardPage struct {
+	pageData
+	Project *Project
+	Columns []boardColumn
+}
+
+func NewWebService(store Store, users *UserService, tasks *TasksService) *WebService {
+	return &WebService{
+		store:     store,
+		users:     users,
+		tasks:     tasks,
+		templates: parseTemplates(),
+	}
+}
+
+func parseTemplates() map[string]*template.Template {
+	templates := make(map[string]*template.Template)
+	for _, page := range []string{"login.html", "mfa.html", "password.html", "projects.html", "board.html"} {
+		templates[page] = template.Must(template.ParseFS(webFS,
+			"templates/layout.html",
+			"templates/task.html",
+			"templates/"+page,
+		))
+	}
+	return templates
+}
+
+func (s *WebService) RegisterRoutes(router *http.ServeMux) {
+	router.Handle("GET /static/", http.FileServerFS(webFS))
+
+	router.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/projects", http.StatusSeeOther)
+	})
+	router.HandleFunc("GET /login", s.handleLoginPage)
+	router.HandleFunc("POST /login", s.handleLogin)
+	router.HandleFunc("POST /login/mfa", s.handleLoginMFA)
+	router.HandleFunc("POST /logout", s.handleLogout)
+	router.HandleFunc("GET /verify-email", s.handleVerifyEmail)
+	router.HandleFunc("GET /forgot-password", s.handleForgotPasswordPage)
+	router.HandleFunc("POST /forgot-password", s.handleForgotPassword)
+	router.HandleFunc("GET /reset-password", s.handleResetPasswordPage)
+	router.HandleFunc("POST /reset-password", s.handleResetPassword)
+
+	router.HandleFunc("GET /projects", s.requireSession(s.handleProjects))
+	router.HandleFunc("POST /projects", s.requireSession(s.handleCreateProject))
+	router.HandleFunc("GET /projects/{id}", s.requireSession(s.handleBoard))
+	router.HandleFunc("POST /projects/{id}/tasks", s.requireSession(s.handleCreateTask))
+	router.HandleFunc("PATCH /tasks/{id}/status", s.requireSession(s.handleMoveTask))
+}
+
+// requireSession redirects to the login page unless the request carries a
+// valid session cookie. htmx requests are redirected with HX-Redirect.
+// Unsafe methods also need the CSRF token.
+func (s *WebService) requireSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info, err := sessionFromCookie(s.store, r)
+		if err != nil {
+			if r.Header.Get("HX-Request") == "true" {
+				w.Header().Set("HX-Redirect", "/login")
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		if !validCSRF(r, sessionToken(r)) {
+			http.Error(w, "Missing or invalid CSRF token, reload the page and try again", http.StatusForbidden)
+			return
+		}
+
+		next(w, r.WithContext(withAuthInfo(r.Context(), info)))
+	}
+}
+
+func sessionFromCookie(store Store, r *http.Request) (*AuthInfo, error) {
+	session := sessionToken(r)
+	if session == "" {
+		return nil, http.ErrNoCookie
+	}
+
+	token, err := validateToken(session)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, _ := token.Claims.(jwt.MapClaims)
+	userID, ok := claims["userID"].(string)
+	if !ok {
+		return nil, jwt.NewValidationError("missing userID claim", jwt.ValidationErrorClaimsInvalid)
+	}
+
+	user, err := store.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if sessionRevoked(user, claims) {
+		return nil, jwt.NewValidationError("session was revoked", jwt.ValidationErrorClaimsInvalid)
+	}
+
+	info := &AuthInfo{UserID: userID}
+	info.ExpiresAt, _ = tokenExpiry(claims)
+	return info, nil
+}
+
+func (s *WebService) render(w http.ResponseWriter, status int, page string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := s.templates[page].ExecuteTemplate(w, "layout", data); err != nil {
+		log.Printf("Error rendering %s: %v\n", page, err)
+	}
+}
+
+func (s *WebService) renderTask(w http.ResponseWriter, t *Task) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.templates["board.html"].ExecuteTemplate(w, "task", t); err != nil {
+		log.Printf("Error rendering task: %v\n", err)
+	}
+}
+
+func (s *WebService) handleLoginPage(w http.ResponseWriter, r *http.Request) {
+	s.render(w, http.StatusOK, "login.html", loginPage{SSO: s.sso})
+}
+
+func (s *WebService) handleLogin(w http.ResponseWriter, r *http.Request) {
+	email := r.FormValue("email")
+
+	user, err := s.users.authenticate(r.Context(), clientIP(r), email, r.FormValue("password"))
+	var locked *lockedError
+	if errors.As(err, &locked) {
+		w.Header().Set("Retry-After", locked.retryAfter())
+		s.render(w, http.StatusTooManyRequests, "login.html", loginPage{
+			Email: email,
+			Error: "Too many failed attempts, try again later.",
+			SSO:   s.sso,
+		})
+		return
+	}
+	if errors.Is(err, errAccountPending) {
+		s.render(w, http.StatusForbidden, "login.html", loginPage{
+			Email: email,
+			Error: "Verify your email address first, check your inbox for the link.",
+			SSO:   s.sso,
+		})
+		return
+	}
+	if err != nil {
+		s.render(w, http.StatusUnauthorized, "login.html", loginPage{
+			Email: email,
+			Error: "Invalid email or password.",
+			SSO:   s.sso,
+		})
+		return
+	}
+
+	challenge, err := s.users.mfaChallenge(user)
+	if err != nil {
+		s.render(w, http.StatusInternalServerError, "login.html", loginPage{
+			Email: email,
+			Error: "Could not sign you in, please try again.",
+			SSO:   s.sso,
+		})
+		return
+	}
+	if challenge != "" {
+		s.render(w, http.StatusOK, "mfa.html", mfaPage{MFAToken: challenge})
+		return
+	}
+	s.users.guard.succeed(user)
+
+	if _, err := createAndSetAuthCookie(w, user.ID); err != nil {
+		s.render(w, http.StatusInternalServerError, "login.html", loginPage{
+			Email: email,
+			Error: "Could not sign you in, please try again.",
+			SSO:   s.sso,
+		})
+		return
+	}
+
+	http.Redirect(w, r, "/projects", http.StatusSeeOther)
+}
+
+// handleLoginMFA is the second step of signing in with two-factor
+// authentication. A wrong code can be retried until the challenge expires.
+func (s *WebService) handleLoginMFA(w http.ResponseWriter, r *http.Request) {
+	challenge := r.FormValue("mfa_token")
+
+	user, err := s.users.completeMFA(r.Context(), clientIP(r), challenge, r.FormValue("code"))
+	var locked *lockedError
+	if errors.As(err, &locked) {
+		w.Header().Set("Retry-After", locked.retryAfter())
+		s.render(w, http.StatusTooManyRequests, "login.html", loginPage{
+			Error: "Too many failed attempts, try again later.",
+			SSO:   s.sso,
+		})
+		return
+	}
+	if errors.Is(err, errInvalidMFACode) {
+		s.render(w, http.StatusUnauthorized, "mfa.html", mfaPage{
+			MFAToken: challenge,
+			Error:    "Invalid code, try again.",
+		})
+		return
+	}
+	if err != nil {
+		s.render(w, http.StatusUnauthorized, "login.html", loginPage{
+			Error: "Your sign-in attempt expired, please sign in again.",
+			SSO:   s.sso,
+		})
+		return
+	}
+
+	if _, err := createAndSetAuthCookie(w, user.ID); err != nil {
+		s.render(w, http.StatusInternalServerError, "login.html", loginPage{
+			Error: "Could not sign you in, please try again.",
+			SSO:   s.sso,
+		})
+		return
+	}
+
+	http.Redirect(w, r, "/projects", http.StatusSeeOther)
+}
+
+// handleVerifyEmail is where the link in the verification email lands.
+func (s *WebService) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if err := s.users.verifyEmail(r.URL.Query().Get("token")); err != nil {
+		s.render(w, http.StatusBadRequest, "login.html", loginPage{
+			Error: "This verification link is invalid or has expired.",
+			SSO:   s.sso,
+		})
+		return
+	}
+
+	s.render(w, http.StatusOK, "login.html", loginPage{
+		Notice: "Your email address is verified, you can sign in now.",
+		SSO:    s.sso,
+	})
+}
+
+func (s *WebService) handleForgotPasswordPage(w http.ResponseWriter, r *http.Request) {
+	s.render(w, http.StatusOK, "password.html", passwordPage{})
+}
+
+func (s *WebService) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if email := r.FormValue("email"); email != "" {
+		s.users.forgotPassword(r.Context(), email)
+	}
+
+	s.render(w, http.StatusOK, "password.html", passwordPage{
+		Notice: forgotPasswordResponse["message"],
+	})
+}
+
+// handleResetPasswordPage is where the link in the reset email lands.
+func (s *WebService) handleResetPasswordPage(w http.ResponseWriter, r *http.Request) {
+	s.render(w, http.StatusOK, "password.html", passwordPage{Token: r.URL.Query().Get("token")})
+}
+
+func (s *WebService) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+
+	err := s.users.resetPassword(token, r.FormValue("password"))
+	if errors.Is(err, errInvalidUserToken) {
+		s.render(w, http.StatusBadRequest, "password.html", passwordPage{
+			Error: "This reset link is invalid or has expired, request a new one.",
+		})
+		return
+	}
+	if err != nil {
+		s.render(w, http.StatusBadRequest, "password.html", passwordPage{Token: token, Error: err.Error()})
+		return
+	}
+
+	s.render(w, http.StatusOK, "login.html", loginPage{
+		Notice: "Your password has been changed, sign in with the new one.",
+		SSO:    s.sso,
+	})
+}
+
+func (s *WebService) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if session := sessionToken(r); session != "" && !validCSRF(r, session) {
+		http.Error(w, "Missing or invalid CSRF token, reload the page and try again", http.StatusForbidden)
+		return
+	}
+
+	clearSessionCookies(w)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// pageData describes the signed in user to the layout.
+func (s *WebService) pageData(r *http.Request) pageData {
+	return pageData{
+		UserID:    authInfoFromContext(r.Context()).UserID,
+		CSRFToken: csrfToken(sessionToken(r)),
+	}
+}
+
+// requireProjectAccess answers 403 unless the signed-in user is a member of
+// the project or an admin.
+func (s *WebService) requireProjectAccess(w http.ResponseWriter, r *http.Request, projectID int64) bool {
+	userID, err := strconv.ParseInt(authInfoFromContext(r.Context()).UserID, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return false
+	}
+
+	allowed, err := canAccessProject(s.store, projectID, userID)
+	if err != nil {
+		http.Error(w, "Error checking project membership", http.StatusInternalServerError)
+		return false
+	}
+	if !allowed {
+		http.Error(w, "Forbidden: not a member of this project", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (s *WebService) handleProjects(w http.ResponseWriter, r *http.Request) {
+	projects, err := s.store.ListProjects(ListOptions{})
+	if err != nil {
+		http.Error(w, "Error listing projects", http.StatusInternalServerError)
+		return
+	}
+
+	s.render(w, http.StatusOK, "projects.html", projectsPage{
+		pageData: s.pageData(r),
+		Projects: projects,
+	})
+}
+
+func (s *WebService) handleCreateProject(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "Project name is required", http.StatusBadRequest)
+		return
+	}
+
+	ownerID, _ := strconv.ParseInt(authInfoFromContext(r.Context()).UserID, 10, 64)
+	if _, err := s.store.CreateProject(&Project{Name: name, OwnerID: ownerID}); err != nil {
+		http.Error(w, "Error creating project", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/projects", http.StatusSeeOther)
+}
+
+func (s *WebService) handleBoard(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	project, err := s.store.GetProject(id)
+	if err != nil {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if !s.requireProjectAccess(w, r, project.ID) {
+		return
+	}
+
+	tasks, err := s.store.ListProjectTasks(id, ListOptions{})
+	if err != nil {
+		http.Error(w, "Error listing tasks", http.StatusInternalServerError)
+		return
+	}
+
+	columns := make([]boardColumn, len(TaskStatuses))
+	index := make(map[string]int, len(TaskStatuses))
+	for i, status := range TaskStatuses {
+		columns[i] = boardColumn{Status: status, Title: statusTitles[status]}
+		index[status] = i
+	}
+	for _, t := range tasks {
+		if i, ok := index[t.Status]; ok {
+			columns[i].Tasks = append(columns[i].Tasks, t)
+		}
+	}
+
+	s.render(w, http.StatusOK, "board.html", boardPage{
+		pageData: s.pageData(r),
+		Project:  project,
+		Columns:  columns,
+	})
+}
+
+func (s *WebService) handleCreateTask(w http.ResponseWriter, r *http.Request) {
+	project, err := s.store.GetProject(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if !s.requireProjectAccess(w, r, project.ID) {
+		return
+	}
+
+	userID, err := strconv.ParseInt(authInfoFromContext(r.Context()).UserID, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return
+	}
+
+	task := &Task{
+		Name:         strings.TrimSpace(r.FormValue("name")),
+		Status:       r.FormValue("status"),
+		ProjectID:    project.ID,
+		AssignedToID: userID,
+	}
+	if err := task.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	t, err := s.tasks.createTask(task)
+	if err != nil {
+		http.Error(w, "Error creating task", http.StatusInternalServerError)
+		return
+	}
+
+	s.renderTask(w, t)
+}
+
+func (s *WebService) handleMoveTask(w http.ResponseWriter, r *http.Request) {
+	t, err := s.store.GetTask(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if !s.requireProjectAccess(w, r, t.ProjectID) {
+		return
+	}
+
+	status := r.FormValue("status")
+	if !isValidTaskStatus(status) {
+		http.Error(w, errInvalidStatus.Error(), http.StatusBadRequest)
+		return
+	}
+
+	previousStatus := t.Status
+	t.Status = status
+	if err := t.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	t, err = s.tasks.updateTask(t, previousStatus)
+	if err != nil {
+		http.Error(w, "Error updating task", http.StatusInternalServerError)
+		return
+	}
+
+	s.renderTask(w, t)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newWebRouter() *http.ServeMux {
+	ms := &MockStore{}
+	router := http.NewServeMux()
+	service := NewWebService(ms, NewUserService(ms, LogMailer{}, &PasswordPolicy{}), NewTasksService(ms, nil))
+	service.RegisterRoutes(router)
+	return router
+}
+
+func withSessionCookie(t *testing.T, req *http.Request) *http.Request {
+	t.Helper()
+	return withSessionCookieFor(t, req, 42)
+}
+
+func withSessionCookieFor(t *testing.T, req *http.Request, userID int64) *http.Request {
+	t.Helper()
+
+	token, err := CreateJWT(userID, []byte(Envs.JWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: "Authorization", Value: token})
+	req.Header.Set("X-CSRF-Token", csrfToken(token))
+	return req
+}
+
+func TestWebUI(t *testing.T) {
+	router := newWebRouter()
+
+	t.Run("Redirects to login without a session", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/projects", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login" {
+			t.Errorf("Expected redirect to /login, got %d %q", rec.Code, rec.Header().Get("Location"))
+		}
+	})
+
+	t.Run("htmx requests get HX-Redirect", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPatch, "/tasks/1/status", nil)
+		req.Header.Set("HX-Request", "true")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusUnauthorized || rec.Header().Get("HX-Redirect") != "/login" {
+			t.Errorf("Expected HX-Redirect to /login, got %d %q", rec.Code, rec.Header().Get("HX-Redirect"))
+		}
+	})
+
+	t.Run("Board renders a column per status", func(t *testing.T) {
+		req := withSessionCookie(t, httptest.NewRequest(http.MethodGet, "/projects/1", nil))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+		}
+		body := rec.Body.String()
+		for _, status := range TaskStatuses {
+			if !strings.Contains(body, `data-status="`+status+`"`) {
+				t.Errorf("Expected a %s column", status)
+			}
+		}
+		if !strings.Contains(body, "Write docs") || !strings.Contains(body, "Ship it") {
+			t.Error("Expected tasks to be rendered on the board")
+		}
+	})
+
+	t.Run("Inline task creation returns a card", func(t *testing.T) {
+		form := url.Values{"name": {"New card"}, "status": {"IN_TESTING"}}
+		req := withSessionCookie(t, httptest.NewRequest(http.MethodPost, "/projects/1/tasks", strings.NewReader(form.Encode())))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), "New card") {
+			t.Error("Expected the new task card")
+		}
+	})
+
+	t.Run("Moving to an unknown status is rejected", func(t *testing.T) {
+		form := url.Values{"status": {"ARCHIVED"}}
+		req := withSessionCookie(t, httptest.NewRequest(http.MethodPatch, "/tasks/1/status", strings.NewReader(form.Encode())))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rec.Code)
+		}
+	})
+	t.Run("Only members see and change a project", func(t *testing.T) {
+		form := url.Values{"name": {"New card"}, "status": {"TODO"}}.Encode()
+		for _, req := range []*http.Request{
+			httptest.NewRequest(http.MethodGet, "/projects/1", nil),
+			httptest.NewRequest(http.MethodPost, "/projects/1/tasks", strings.NewReader(form)),
+			httptest.NewRequest(http.MethodPatch, "/tasks/1/status", strings.NewReader("status=DONE")),
+		} {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, withSessionCookieFor(t, req, 7))
+
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("Expected %s %s to be forbidden, got %d", req.Method, req.URL.Path, rec.Code)
+			}
+		}
+	})
+}
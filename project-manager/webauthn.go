@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+)
+
+// This file holds the parts of WebAuthn (https://www.w3.org/TR/webauthn-2/)
+// the passkey ceremonies need: the CBOR subset authenticators emit, COSE
+// public keys, authenticator data and client data. Attestation statements
+// aren't verified, registration asks for "none".
+
+// COSE algorithms accepted for passkeys, in order of preference.
+const (
+	coseES256 = -7
+	coseEdDSA = -8
+	coseRS256 = -257
+)
+
+var coseAlgorithms = []int64{coseES256, coseEdDSA, coseRS256}
+
+// Flags of the authenticator data.
+const (
+	flagUserPresent        = 0x01
+	flagUserVerified       = 0x04
+	flagAttestedCredential = 0x40
+	flagExtensions         = 0x80
+)
+
+var errWebAuthn = errors.New("invalid passkey response")
+
+// base64URL is binary data, which WebAuthn's JSON encodes as unpadded
+// base64url.
+type base64URL []byte
+
+func (b base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = v
+	return nil
+}
+
+// webAuthnRP returns the relying party ID and the origin ceremonies must
+// come from, both from PUBLIC_URL.
+func webAuthnRP() (id, origin string) {
+	u, err := url.Parse(Envs.PublicURL)
+	if err != nil {
+		return "", ""
+	}
+	return u.Hostname(), u.Scheme + "://" + u.Host
+}
+
+// clientData is the part of clientDataJSON that is checked.
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// parseClientData checks the ceremony type and origin and returns the
+// challenge, which the caller must check was issued and not used yet.
+func parseClientData(raw []byte, ceremony string) (challenge []byte, err error) {
+	var c clientData
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, fmt.Errorf("%w: client data: %v", errWebAuthn, err)
+	}
+	if c.Type != ceremony {
+		return nil, fmt.Errorf("%w: expected a %s ceremony, got %q", errWebAuthn, ceremony, c.Type)
+	}
+	if _, origin := webAuthnRP(); c.Origin != origin || c.CrossOrigin {
+		return nil, fmt.Errorf("%w: unexpected origin %q", errWebAuthn, c.Origin)
+	}
+	challenge, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(c.Challenge, "="))
+	if err != nil || len(challenge) == 0 {
+		return nil, fmt.Errorf("%w: invalid challenge", errWebAuthn)
+	}
+	return challenge, nil
+}
+
+// authenticatorData is the authenticator's signed statement. CredentialID
+// and PublicKey are only set during registration.
+type authenticatorData struct {
+	Raw          []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte // COSE_Key
+}
+
+// parseAuthenticatorData checks that the data is for this relying party and
+// that the user was present and verified.
+func parseAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, fmt.Errorf("%w: authenticator data too short", errWebAuthn)
+	}
+	rpID, _ := webAuthnRP()
+	if rpIDHash := sha256.Sum256([]byte(rpID)); !bytes.Equal(raw[:32], rpIDHash[:]) {
+		return nil, fmt.Errorf("%w: credential is for another site", errWebAuthn)
+	}
+
+	d := &authenticatorData{
+		Raw:       raw,
+		Flags:     raw[32],
+		SignCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	if d.Flags&flagUserPresent == 0 || d.Flags&flagUserVerified == 0 {
+		return nil, fmt.Errorf("%w: user wasn't verified", errWebAuthn)
+	}
+
+	rest := raw[37:]
+	if d.Flags&flagAttestedCredential != 0 {
+		// AAGUID, then the length-prefixed credential ID and the key.
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("%w: attested credential data too short", errWebAuthn)
+		}
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < n {
+			return nil, fmt.Errorf("%w: credential ID too short", errWebAuthn)
+		}
+		d.CredentialID, rest = rest[:n], rest[n:]
+
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: public key: %v", errWebAuthn, err)
+		}
+		d.PublicKey, rest = rest[:len(rest)-len(after)], after
+	}
+	if d.Flags&flagExtensions != 0 {
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: extensions: %v", errWebAuthn, err)
+		}
+		rest = after
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing authenticator data", errWebAuthn)
+	}
+	return d, nil
+}
+
+// parseAttestationObject returns the authenticator data of a registration.
+func parseAttestationObject(raw []byte) (*authenticatorData, error) {
+	v, rest, err := decodeCBOR(raw)
+	if err != nil || len(rest) != 0 {
+		return nil, fmt.Errorf("%w: attestation object", errWebAuthn)
+	}
+	obj, _ := v.(map[any]any)
+	authData, ok := obj["authData"].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: attestation object without authenticator data", errWebAuthn)
+	}
+
+	d, err := parseAuthenticatorData(authData)
+	if err != nil {
+		return nil, err
+	}
+	if d.CredentialID == nil {
+		return nil, fmt.Errorf("%w: no credential was created", errWebAuthn)
+	}
+	if _, _, err := parseCOSEKey(d.PublicKey); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// parseCOSEKey returns the public key and its COSE algorithm.
+func parseCOSEKey(raw []byte) (crypto.PublicKey, int64, error) {
+	v, _, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: public key: %v", errWebAuthn, err)
+	}
+	m, _ := v.(map[any]any)
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	crv, _ := m[int64(-1)].(int64)
+
+	switch {
+	case kty == 2 && alg == coseES256 && crv == 1:
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if len(x) != 32 || len(y) != 32 {
+			break
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			break
+		}
+		return key, alg, nil
+	case kty == 1 && alg == coseEdDSA && crv == 6:
+		x, _ := m[int64(-2)].([]byte)
+		if len(x) != ed25519.PublicKeySize {
+			break
+		}
+		return ed25519.PublicKey(x), alg, nil
+	case kty == 3 && alg == coseRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			break
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, alg, nil
+	}
+	return nil, 0, fmt.Errorf("%w: unsupported public key (kty %d, alg %d)", errWebAuthn, kty, alg)
+}
+
+// verifySignature checks an authentication signature, which covers the
+// authenticator data and the hash of the client data.
+func verifySignature(coseKey, authData, clientDataJSON, sig []byte) error {
+	key, alg, err := parseCOSEKey(coseKey)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authData...), hash[:]...)
+	digest := sha256.Sum256(signed)
+
+	var ok bool
+	switch alg {
+	case coseES256:
+		ok = ecdsa.VerifyASN1(key.(*ecdsa.PublicKey), digest[:], sig)
+	case coseEdDSA:
+		ok = ed25519.Verify(key.(ed25519.PublicKey), signed, sig)
+	case coseRS256:
+		ok = rsa.VerifyPKCS1v15(key.(*rsa.PublicKey), crypto.SHA256, digest[:], sig) == nil
+	}
+	if !ok {
+		return fmt.Errorf("%w: bad signature", errWebAuthn)
+	}
+	return nil
+}
+
+// decodeCBOR decodes one CBOR item and returns what follows it. Only the
+// definite-length items authenticators produce are supported; integers
+// decode to int64 and maps to map[any]any.
+func decodeCBOR(b []byte) (any, []byte, error) {
+	return decodeCBORDepth(b, 0)
+}
+
+const maxCBORDepth = 16
+
+func decodeCBORDepth(b []byte, depth int) (any, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, errors.New("cbor: nested too deeply")
+	}
+	if len(b) == 0 {
+		return nil, nil, errors.New("cbor: unexpected end of data")
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	b = b[1:]
+
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		n := 1 << (info - 24)
+		if len(b) < n {
+			return nil, nil, errors.New("cbor: unexpected end of data")
+		}
+		for _, c := range b[:n] {
+			arg = arg<<8 | uint64(c)
+		}
+		b = b[n:]
+	default:
+		return nil, nil, fmt.Errorf("cbor: unsupported additional info %d", info)
+	}
+
+	switch major {
+	case 0, 1:
+		if arg > 1<<63-1 {
+			return nil, nil, errors.New("cbor: integer overflows int64")
+		}
+		if major == 1 {
+			return -1 - int64(arg), b, nil
+		}
+		return int64(arg), b, nil
+	case 2, 3:
+		if arg > uint64(len(b)) {
+			return nil, nil, errors.New("cbor: unexpected end of data")
+		}
+		if major == 3 {
+			return string(b[:arg]), b[arg:], nil
+		}
+		return append([]byte{}, b[:arg]...), b[arg:], nil
+	case 4:
+		if arg > uint64(len(b)) {
+			return nil, nil, errors.New("cbor: unexpected end of data")
+		}
+		items := make([]any, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			v, rest, err := decodeCBORDepth(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items, b = append(items, v), rest
+		}
+		return items, b, nil
+	case 5:
+		if arg > uint64(len(b)) {
+			return nil, nil, errors.New("cbor: unexpected end of data")
+		}
+		m := make(map[any]any, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, rest, err := decodeCBORDepth(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errors.New("cbor: unsupported map key")
+			}
+			v, rest, err := decodeCBORDepth(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[k], b = v, rest
+		}
+		return m, b, nil
+	case 7:
+		switch info {
+		case 20:
+			return false, b, nil
+		case 21:
+			return true, b, nil
+		case 22, 23:
+			return nil, b, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("cbor: unsupported item (major type %d)", major)
+}